@@ -0,0 +1,696 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../server/network"
+	"../service/pet"
+	"../service/ratelimit"
+)
+
+// Config is the configuration of the server binary. It is loaded from, in
+// order of precedence: flags, environment variables, a configuration file
+// and the defaults.
+type Config struct {
+	Server    ServerConfig
+	TLS       TLSConfig
+	Storage   StorageConfig
+	Limits    LimitsConfig
+	RateLimit RateLimitConfig
+	Auth      AuthConfig
+	Tenancy   TenancyConfig
+	Log       LogConfig
+}
+
+// ServerConfig is where the server listens, and how long it waits for things
+type ServerConfig struct {
+	Network            string
+	Addr               string
+	Port               int
+	SocketPath         string
+	SocketMode         os.FileMode
+	FD                 int
+	H2C                bool
+	CompressionMinSize int
+	StrictJSON         bool
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownTimeout    time.Duration
+	IdempotencyWindow  time.Duration
+}
+
+// TLSConfig is the certificates for serving HTTPS. The server serves plain
+// HTTP unless CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ReloadInterval time.Duration
+}
+
+// Enabled returns true if the server should serve HTTPS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// StorageConfig is where the pets are stored
+type StorageConfig struct {
+	Backend       string
+	Path          string
+	FlushInterval time.Duration
+	// AuditPath is the file the audit log of the changes to the pets is
+	// appended to
+	AuditPath string
+}
+
+// LimitsConfig caps the work a single request, or all of them, can cause
+type LimitsConfig struct {
+	MaxBodyBytes int
+	// MaxBodyBytesByRoute overrides MaxBodyBytes for routes, as their names
+	// and limits, e.g. "importPets=1073741824,batchPets=16777216"
+	MaxBodyBytesByRoute string
+	MaxJSONDepth        int
+	MaxBatchOperations  int
+	MaxImportLineBytes  int
+	MaxImportErrors     int
+	JobWorkers          int
+	JobQueueSize        int
+}
+
+// RouteMaxBodyBytes returns the limits of MaxBodyBytesByRoute, by route name
+func (c LimitsConfig) RouteMaxBodyBytes() (map[string]int64, error) {
+	var limits = make(map[string]int64)
+	for _, entry := range strings.Split(c.MaxBodyBytesByRoute, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q is not a route name and limit, e.g. importPets=1073741824", entry)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("limit of route %s must be a number of bytes greater than 0, got %q", strings.TrimSpace(parts[0]), parts[1])
+		}
+		limits[strings.TrimSpace(parts[0])] = limit
+	}
+	return limits, nil
+}
+
+// RateLimitConfig is how often, and how much, each client can call the API
+type RateLimitConfig struct {
+	Enabled bool
+	// Requests can be made every Period by each client
+	Requests int
+	Period   time.Duration
+	// DailyQuota is how many requests each client can make in a day, none
+	// if it is 0
+	DailyQuota int
+	// QuotaPath is the file the daily counts are saved to, instead of the
+	// storage file of the file backend
+	QuotaPath string
+	// KeyBy is what clients are told apart by, one of ratelimit.Keys
+	KeyBy string
+	// FailedAuths is how many failed authentications each IP address can
+	// make every Period, none if it is 0
+	FailedAuths int
+	// TrustedProxies are the networks of the proxies in front of the
+	// server, in CIDR notation separated by commas
+	TrustedProxies string
+}
+
+// TrustedProxyNetworks returns the networks of TrustedProxies
+func (c RateLimitConfig) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(c.TrustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a network in CIDR notation, e.g. 10.0.0.0/8", cidr)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// AuthConfig is how requests are authenticated
+type AuthConfig struct {
+	// Mode is "none", or the AuthModes that are turned on separated by
+	// commas
+	Mode string
+	// KeysPath is the file the API keys are saved to
+	KeysPath string
+	// AdminKey is an admin API key, to create the other keys with
+	AdminKey string
+	// PolicyFile maps roles to the permissions they grant, the built-in
+	// policy is used without one
+	PolicyFile string
+	JWT        JWTConfig
+}
+
+// Has returns true if requests can be authenticated with mode
+func (c AuthConfig) Has(mode string) bool {
+	for _, m := range strings.Split(c.Mode, ",") {
+		if strings.TrimSpace(m) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTConfig is the issuer of the JWT bearer tokens requests can be
+// authenticated with, and the keys it signs them with
+type JWTConfig struct {
+	Issuer   string
+	Audience string
+	// JWKSFile or JWKSURL is where the public keys of the issuer are
+	JWKSFile        string
+	JWKSURL         string
+	RefreshInterval time.Duration
+	// Leeway is how far the clock of the issuer may be off
+	Leeway time.Duration
+	// RolesClaim is the claim with the roles of the principal
+	RolesClaim string
+	// TenantClaim is the claim with the tenant of the principal
+	TenantClaim string
+}
+
+// TenancyConfig shares the API between tenants, each with their own pets
+type TenancyConfig struct {
+	Enabled bool
+	// Header is the request header with the tenant, for principals that
+	// aren't bound to one
+	Header string
+}
+
+// LogConfig is what gets logged
+type LogConfig struct {
+	Level string
+}
+
+// AuthModes lists the ways requests can be authenticated
+var AuthModes = []string{"none", "api_key", "jwt"}
+
+// MinAdminKeyLength is the shortest auth.admin_key allowed, so that it can't
+// be guessed
+var MinAdminKeyLength = 32
+
+// LogLevels lists the log levels, from the most to the least verbose
+var LogLevels = []string{"debug", "info", "notice", "warning", "error", "critical"}
+
+// EnvPrefix is prefixed to the environment variable of each setting, e.g.
+// PETS_SERVER_PORT for server.port
+var EnvPrefix = "PETS_"
+
+// Default returns the configuration used when nothing else is set
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Network:            network.TCP,
+			Port:               8080,
+			SocketMode:         0660,
+			FD:                 3,
+			CompressionMinSize: 1024,
+			ReadHeaderTimeout:  10 * time.Second,
+			ReadTimeout:        time.Minute,
+			WriteTimeout:       2 * time.Minute,
+			IdleTimeout:        2 * time.Minute,
+			ShutdownTimeout:    30 * time.Second,
+			IdempotencyWindow:  24 * time.Hour,
+		},
+		TLS: TLSConfig{
+			ReloadInterval: time.Minute,
+		},
+		Storage: StorageConfig{
+			Backend:       pet.StorageMemory,
+			FlushInterval: 5 * time.Second,
+		},
+		Limits: LimitsConfig{
+			MaxBodyBytes:       1 << 20,
+			MaxJSONDepth:       32,
+			MaxBatchOperations: 1000,
+			MaxImportLineBytes: 1 << 20,
+			MaxImportErrors:    1000,
+			JobWorkers:         4,
+			JobQueueSize:       100,
+		},
+		RateLimit: RateLimitConfig{
+			Requests:    600,
+			Period:      time.Minute,
+			KeyBy:       ratelimit.KeyByPrincipal,
+			FailedAuths: 10,
+		},
+		Auth: AuthConfig{
+			Mode: "none",
+			JWT: JWTConfig{
+				RefreshInterval: time.Hour,
+				Leeway:          time.Minute,
+				RolesClaim:      "roles",
+				TenantClaim:     "tenant",
+			},
+		},
+		Tenancy: TenancyConfig{Header: "X-Tenant-ID"},
+		Log:     LogConfig{Level: "debug"},
+	}
+}
+
+// setting is a single configuration value, addressed by its key in the
+// configuration file, e.g. server.port
+type setting struct {
+	key   string
+	usage string
+	// value points to the field of the Config
+	value interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.network", "What to listen on: " + strings.Join(network.Names, ", "), &c.Server.Network},
+		{"server.addr", "Address to listen on, empty for all interfaces, for the tcp network", &c.Server.Addr},
+		{"server.port", "Port to listen on, for the tcp network", &c.Server.Port},
+		{"server.socket_path", "Path of the socket, for the unix network", &c.Server.SocketPath},
+		{"server.socket_mode", "Permissions of the socket in octal, for the unix network", &c.Server.SocketMode},
+		{"server.fd", "File descriptor of the listening socket, for the fd network", &c.Server.FD},
+		{"server.h2c", "Serve HTTP/2 without TLS to clients that ask for it", &c.Server.H2C},
+		{"server.compression_min_size", "Smallest response in bytes that is compressed", &c.Server.CompressionMinSize},
+		{"server.strict_json", "Refuse JSON request bodies with fields the API doesn't know", &c.Server.StrictJSON},
+		{"server.read_header_timeout", "Longest time to read the headers of a request", &c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", "Longest time to read a whole request", &c.Server.ReadTimeout},
+		{"server.write_timeout", "Longest time to write a response", &c.Server.WriteTimeout},
+		{"server.idle_timeout", "How long idle keep-alive connections are kept open", &c.Server.IdleTimeout},
+		{"server.shutdown_timeout", "How long to wait for requests and background jobs when stopping", &c.Server.ShutdownTimeout},
+		{"server.idempotency_window", "How long responses are kept for replay to retries", &c.Server.IdempotencyWindow},
+		{"tls.cert_file", "PEM certificate to serve HTTPS with, along with tls.key_file", &c.TLS.CertFile},
+		{"tls.key_file", "PEM private key of tls.cert_file", &c.TLS.KeyFile},
+		{"tls.client_ca_file", "PEM bundle of CAs to require and verify client certificates against", &c.TLS.ClientCAFile},
+		{"tls.reload_interval", "How often the certificate files are checked for changes", &c.TLS.ReloadInterval},
+		{"storage.backend", "Where to store the pets: " + strings.Join(pet.Backends, ", "), &c.Storage.Backend},
+		{"storage.path", "File to store the pets in, for the file backend", &c.Storage.Path},
+		{"storage.flush_interval", "How often changes are written to the file, for the file backend", &c.Storage.FlushInterval},
+		{"storage.audit_path", "File to append the audit log of the changes to the pets to, it is lost on restart without one", &c.Storage.AuditPath},
+		{"limits.max_body_bytes", "Largest request body in bytes, for routes without a limit of their own", &c.Limits.MaxBodyBytes},
+		{"limits.max_body_bytes_by_route", "Largest request body in bytes of routes by name, e.g. importPets=1073741824,batchPets=16777216", &c.Limits.MaxBodyBytesByRoute},
+		{"limits.max_json_depth", "How deeply JSON request bodies can be nested", &c.Limits.MaxJSONDepth},
+		{"limits.max_batch_operations", "Most operations in a batch request", &c.Limits.MaxBatchOperations},
+		{"limits.max_import_line_bytes", "Longest line allowed in an import", &c.Limits.MaxImportLineBytes},
+		{"limits.max_import_errors", "Most errors reported for an import", &c.Limits.MaxImportErrors},
+		{"limits.job_workers", "How many background jobs run at once", &c.Limits.JobWorkers},
+		{"limits.job_queue_size", "How many background jobs can wait to run", &c.Limits.JobQueueSize},
+		{"rate_limit.enabled", "Limit how often, and how much, each client can call the API", &c.RateLimit.Enabled},
+		{"rate_limit.requests", "Requests each client can make every rate_limit.period", &c.RateLimit.Requests},
+		{"rate_limit.period", "Period that rate_limit.requests can be made in", &c.RateLimit.Period},
+		{"rate_limit.daily_quota", "Requests each client can make in a day, in UTC, 0 for no quota", &c.RateLimit.DailyQuota},
+		{"rate_limit.quota_path", "File to save the daily quota counts in, instead of storage.path for the file backend, they are lost on restart with neither", &c.RateLimit.QuotaPath},
+		{"rate_limit.key_by", "What clients are told apart by: " + strings.Join(ratelimit.Keys, ", "), &c.RateLimit.KeyBy},
+		{"rate_limit.failed_auths", "Failed authentications each IP address can make every rate_limit.period before it is refused, 0 for no limit", &c.RateLimit.FailedAuths},
+		{"rate_limit.trusted_proxies", "Networks of the proxies in front of the server, e.g. 10.0.0.0/8, whose clients are told apart by X-Forwarded-For", &c.RateLimit.TrustedProxies},
+		{"auth.mode", "How requests are authenticated: none, or any of " + strings.Join(AuthModes[1:], ", ") + " separated by commas", &c.Auth.Mode},
+		{"auth.keys_path", "File to save the API keys in, for the api_key mode, they are lost on restart without one", &c.Auth.KeysPath},
+		{"auth.admin_key", "Admin API key to create the other keys with, for the api_key mode", &c.Auth.AdminKey},
+		{"auth.policy_file", "JSON file mapping roles to the permissions they grant, instead of the built-in reader, editor and admin roles", &c.Auth.PolicyFile},
+		{"auth.jwt.issuer", "Issuer (iss) that tokens must come from, for the jwt mode", &c.Auth.JWT.Issuer},
+		{"auth.jwt.audience", "Audience (aud) that tokens must be meant for, for the jwt mode", &c.Auth.JWT.Audience},
+		{"auth.jwt.jwks_file", "JWKS file with the keys of the issuer, for the jwt mode", &c.Auth.JWT.JWKSFile},
+		{"auth.jwt.jwks_url", "URL of the JWKS of the issuer, instead of auth.jwt.jwks_file", &c.Auth.JWT.JWKSURL},
+		{"auth.jwt.refresh_interval", "How often the JWKS is read again for rotated keys", &c.Auth.JWT.RefreshInterval},
+		{"auth.jwt.leeway", "How far the clock of the issuer may be off when checking expiry", &c.Auth.JWT.Leeway},
+		{"auth.jwt.roles_claim", "Claim of tokens with the roles of the principal", &c.Auth.JWT.RolesClaim},
+		{"auth.jwt.tenant_claim", "Claim of tokens with the tenant of the principal, for tenancy.enabled", &c.Auth.JWT.TenantClaim},
+		{"tenancy.enabled", "Scope the pets, jobs and idempotency keys of each request to its tenant", &c.Tenancy.Enabled},
+		{"tenancy.header", "Request header with the tenant, for principals that aren't bound to one", &c.Tenancy.Header},
+		{"log.level", "Least severe log level to write: " + strings.Join(LogLevels, ", "), &c.Log.Level},
+	}
+}
+
+// envName returns the environment variable for the setting with key
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// set parses raw into the value of s
+func (s setting) set(raw string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 30s or 5m", raw)
+		}
+		*v = d
+	case *os.FileMode:
+		m, err := strconv.ParseUint(raw, 8, 32)
+		if err != nil {
+			return fmt.Errorf("%q is not an octal file mode, e.g. 0660", raw)
+		}
+		*v = os.FileMode(m)
+	default:
+		panic(fmt.Sprintf("config: setting %s has an unsupported type %T", s.key, s.value))
+	}
+	return nil
+}
+
+// String returns the value of s as it would be set
+func (s setting) String() string {
+	switch v := s.value.(type) {
+	case *string:
+		return *v
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *time.Duration:
+		return v.String()
+	case *os.FileMode:
+		return fmt.Sprintf("%04o", uint32(*v))
+	}
+	return fmt.Sprint(s.value)
+}
+
+// Error lists everything wrong with the configuration, so it can all be
+// fixed at once
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+func (e *Error) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Load builds the configuration from the command line args, which don't
+// include the program name, and the environment. The configuration file is
+// given with the -config flag, or the PETS_CONFIG environment variable.
+// Settings from flags win over the environment, which wins over the file.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	var c = Default()
+	var settings = c.settings()
+
+	fs := flag.NewFlagSet("pets", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to a YAML or TOML configuration file, also "+envName("config"))
+	for _, s := range settings {
+		fs.String(s.key, s.String(), fmt.Sprintf("%s, also %s", s.usage, envName(s.key)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	var errs Error
+
+	// The file
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv(envName("config"))
+	}
+	if path != "" {
+		values, err := ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		var known = make(map[string]bool)
+		for _, s := range settings {
+			known[s.key] = true
+			if v, exists := values[s.key]; exists {
+				if err := s.set(v.Raw); err != nil {
+					errs.add("%s (%s line %d): %v", s.key, path, v.Line, err)
+				}
+			}
+		}
+		var unknown []string
+		for key := range values {
+			if !known[key] {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			errs.add("%s (%s line %d): unknown setting", key, path, values[key].Line)
+		}
+	}
+
+	// The environment
+	for _, s := range settings {
+		if raw, exists := lookupEnv(envName(s.key)); exists {
+			if err := s.set(raw); err != nil {
+				errs.add("%s (environment variable %s): %v", s.key, envName(s.key), err)
+			}
+		}
+	}
+
+	// The flags that were given
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.key == f.Name {
+				if err := s.set(f.Value.String()); err != nil {
+					errs.add("%s (flag -%s): %v", s.key, s.key, err)
+				}
+			}
+		}
+	})
+
+	// Values that couldn't be parsed make validating the rest misleading
+	if err := errs.err(); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// Validate returns an *Error with every setting that isn't valid
+func (c Config) Validate() error {
+	var errs Error
+
+	checkOneOf(&errs, "server.network", c.Server.Network, network.Names)
+	switch c.Server.Network {
+	case network.TCP:
+		if c.Server.Port < 1 || c.Server.Port > 65535 {
+			errs.add("server.port: must be between 1 and 65535, got %d", c.Server.Port)
+		}
+	case network.Unix:
+		if c.Server.SocketPath == "" {
+			errs.add("server.socket_path: is required for the unix network")
+		} else if info, err := os.Stat(filepath.Dir(c.Server.SocketPath)); err != nil || !info.IsDir() {
+			errs.add("server.socket_path: directory %s does not exist", filepath.Dir(c.Server.SocketPath))
+		}
+		if c.Server.SocketMode == 0 || c.Server.SocketMode&^os.ModePerm != 0 {
+			errs.add("server.socket_mode: must be permissions between 0001 and 0777, got %04o", uint32(c.Server.SocketMode))
+		}
+	case network.FD:
+		if c.Server.FD < 0 {
+			errs.add("server.fd: must be 0 or greater, got %d", c.Server.FD)
+		}
+	}
+	checkPositive(&errs, "server.compression_min_size", c.Server.CompressionMinSize)
+	checkPositive(&errs, "server.read_header_timeout", c.Server.ReadHeaderTimeout)
+	checkPositive(&errs, "server.read_timeout", c.Server.ReadTimeout)
+	checkPositive(&errs, "server.write_timeout", c.Server.WriteTimeout)
+	checkPositive(&errs, "server.idle_timeout", c.Server.IdleTimeout)
+	checkPositive(&errs, "server.shutdown_timeout", c.Server.ShutdownTimeout)
+	checkPositive(&errs, "server.idempotency_window", c.Server.IdempotencyWindow)
+
+	if c.TLS.Enabled() {
+		checkFile(&errs, "tls.cert_file", c.TLS.CertFile)
+		checkFile(&errs, "tls.key_file", c.TLS.KeyFile)
+		if c.TLS.ClientCAFile != "" {
+			checkFile(&errs, "tls.client_ca_file", c.TLS.ClientCAFile)
+		}
+		checkPositive(&errs, "tls.reload_interval", c.TLS.ReloadInterval)
+	} else if c.TLS.ClientCAFile != "" {
+		errs.add("tls.client_ca_file: requires tls.cert_file and tls.key_file")
+	}
+
+	checkOneOf(&errs, "storage.backend", c.Storage.Backend, pet.Backends)
+	if c.Storage.Backend == pet.StorageFile {
+		if c.Storage.Path == "" {
+			errs.add("storage.path: is required for the %s backend", pet.StorageFile)
+		} else if info, err := os.Stat(filepath.Dir(c.Storage.Path)); err != nil || !info.IsDir() {
+			errs.add("storage.path: directory %s does not exist", filepath.Dir(c.Storage.Path))
+		}
+		checkPositive(&errs, "storage.flush_interval", c.Storage.FlushInterval)
+	}
+	if c.Storage.AuditPath != "" {
+		if info, err := os.Stat(filepath.Dir(c.Storage.AuditPath)); err != nil || !info.IsDir() {
+			errs.add("storage.audit_path: directory %s does not exist", filepath.Dir(c.Storage.AuditPath))
+		}
+	}
+
+	checkPositive(&errs, "limits.max_body_bytes", c.Limits.MaxBodyBytes)
+	if _, err := c.Limits.RouteMaxBodyBytes(); err != nil {
+		errs.add("limits.max_body_bytes_by_route: %v", err)
+	}
+	checkPositive(&errs, "limits.max_json_depth", c.Limits.MaxJSONDepth)
+	checkPositive(&errs, "limits.max_batch_operations", c.Limits.MaxBatchOperations)
+	checkPositive(&errs, "limits.max_import_line_bytes", c.Limits.MaxImportLineBytes)
+	checkPositive(&errs, "limits.max_import_errors", c.Limits.MaxImportErrors)
+	checkPositive(&errs, "limits.job_workers", c.Limits.JobWorkers)
+	checkPositive(&errs, "limits.job_queue_size", c.Limits.JobQueueSize)
+
+	if c.RateLimit.Enabled {
+		checkPositive(&errs, "rate_limit.requests", c.RateLimit.Requests)
+		checkPositive(&errs, "rate_limit.period", c.RateLimit.Period)
+		if c.RateLimit.DailyQuota < 0 {
+			errs.add("rate_limit.daily_quota: must be 0 or greater, got %d", c.RateLimit.DailyQuota)
+		}
+		if c.RateLimit.QuotaPath != "" {
+			if info, err := os.Stat(filepath.Dir(c.RateLimit.QuotaPath)); err != nil || !info.IsDir() {
+				errs.add("rate_limit.quota_path: directory %s does not exist", filepath.Dir(c.RateLimit.QuotaPath))
+			}
+		}
+		checkOneOf(&errs, "rate_limit.key_by", c.RateLimit.KeyBy, ratelimit.Keys)
+		if c.RateLimit.KeyBy == ratelimit.KeyByTenant && !c.Tenancy.Enabled {
+			errs.add("rate_limit.key_by: tenant requires tenancy.enabled")
+		}
+		if c.RateLimit.FailedAuths < 0 {
+			errs.add("rate_limit.failed_auths: must be 0 or greater, got %d", c.RateLimit.FailedAuths)
+		}
+		if _, err := c.RateLimit.TrustedProxyNetworks(); err != nil {
+			errs.add("rate_limit.trusted_proxies: %v", err)
+		}
+	}
+
+	checkAuthMode(&errs, c.Auth.Mode)
+	if c.Auth.Has("api_key") {
+		if c.Auth.AdminKey == "" && c.Auth.KeysPath == "" {
+			errs.add("auth.admin_key: is required for the api_key mode, unless auth.keys_path has keys")
+		}
+		if c.Auth.AdminKey != "" && len(c.Auth.AdminKey) < MinAdminKeyLength {
+			errs.add("auth.admin_key: must be at least %d characters long", MinAdminKeyLength)
+		}
+		if c.Auth.KeysPath != "" {
+			if info, err := os.Stat(filepath.Dir(c.Auth.KeysPath)); err != nil || !info.IsDir() {
+				errs.add("auth.keys_path: directory %s does not exist", filepath.Dir(c.Auth.KeysPath))
+			}
+		}
+	}
+	if c.Auth.PolicyFile != "" {
+		if info, err := os.Stat(c.Auth.PolicyFile); err != nil || info.IsDir() {
+			errs.add("auth.policy_file: file %s does not exist", c.Auth.PolicyFile)
+		}
+	}
+	if c.Auth.Has("jwt") {
+		jwt := c.Auth.JWT
+		if jwt.Issuer == "" {
+			errs.add("auth.jwt.issuer: is required for the jwt mode")
+		}
+		if jwt.Audience == "" {
+			errs.add("auth.jwt.audience: is required for the jwt mode")
+		}
+		switch {
+		case jwt.JWKSFile == "" && jwt.JWKSURL == "":
+			errs.add("auth.jwt.jwks_file: is required for the jwt mode, unless auth.jwt.jwks_url is set")
+		case jwt.JWKSFile != "" && jwt.JWKSURL != "":
+			errs.add("auth.jwt.jwks_url: can't be set along with auth.jwt.jwks_file")
+		case jwt.JWKSFile != "":
+			if info, err := os.Stat(jwt.JWKSFile); err != nil || info.IsDir() {
+				errs.add("auth.jwt.jwks_file: file %s does not exist", jwt.JWKSFile)
+			}
+		default:
+			if u, err := url.Parse(jwt.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs.add("auth.jwt.jwks_url: must be an http or https URL, got %q", jwt.JWKSURL)
+			}
+		}
+		checkPositive(&errs, "auth.jwt.refresh_interval", jwt.RefreshInterval)
+		if jwt.Leeway < 0 {
+			errs.add("auth.jwt.leeway: must be 0 or greater, got %s", jwt.Leeway)
+		}
+		if jwt.RolesClaim == "" {
+			errs.add("auth.jwt.roles_claim: is required for the jwt mode")
+		}
+		if c.Tenancy.Enabled && jwt.TenantClaim == "" {
+			errs.add("auth.jwt.tenant_claim: is required for the jwt mode when tenancy.enabled is set")
+		}
+	}
+	if c.Tenancy.Enabled && !headerPattern.MatchString(c.Tenancy.Header) {
+		errs.add("tenancy.header: must be an HTTP header name, got %q", c.Tenancy.Header)
+	}
+	checkOneOf(&errs, "log.level", c.Log.Level, LogLevels)
+
+	return errs.err()
+}
+
+func checkPositive(errs *Error, key string, v interface{}) {
+	switch n := v.(type) {
+	case int:
+		if n < 1 {
+			errs.add("%s: must be greater than 0, got %d", key, n)
+		}
+	case time.Duration:
+		if n <= 0 {
+			errs.add("%s: must be greater than 0, got %s", key, n)
+		}
+	}
+}
+
+func checkFile(errs *Error, key, path string) {
+	if path == "" {
+		errs.add("%s: is required to serve HTTPS", key)
+	} else if info, err := os.Stat(path); err != nil || info.IsDir() {
+		errs.add("%s: file %s does not exist", key, path)
+	}
+}
+
+// checkAuthMode checks that mode is "none", or a list of the other AuthModes
+func checkAuthMode(errs *Error, mode string) {
+	if mode == "none" {
+		return
+	}
+	for _, m := range strings.Split(mode, ",") {
+		m = strings.TrimSpace(m)
+		if m == "none" {
+			errs.add("auth.mode: none can't be combined with other modes, got %q", mode)
+			return
+		}
+		checkOneOf(errs, "auth.mode", m, AuthModes)
+	}
+}
+
+func checkOneOf(errs *Error, key, v string, allowed []string) {
+	for _, a := range allowed {
+		if v == a {
+			return
+		}
+	}
+	errs.add("%s: must be one of: %s, got %q", key, strings.Join(allowed, ", "), v)
+}
+
+// headerPattern is what the names of the headers the server reads look like
+var headerPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// env returns a lookupEnv func for the variables in vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, exists := vars[name]
+		return v, exists
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+
+	path := writeConfigFile(t, "pets.toml", `
+[server]
+port = 9000
+addr = "127.0.0.1"
+
+[log]
+level = "info"
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected func(c *Config)
+	}{
+		{
+			name:     "nothing set should be the defaults",
+			expected: func(c *Config) {},
+		},
+		{
+			name: "the file should win over the defaults",
+			args: []string{"-config", path},
+			expected: func(c *Config) {
+				c.Server.Port = 9000
+				c.Server.Addr = "127.0.0.1"
+				c.Log.Level = "info"
+			},
+		},
+		{
+			name: "the file can be given in the environment",
+			env:  map[string]string{"PETS_CONFIG": path},
+			expected: func(c *Config) {
+				c.Server.Port = 9000
+				c.Server.Addr = "127.0.0.1"
+				c.Log.Level = "info"
+			},
+		},
+		{
+			name: "the environment should win over the file",
+			args: []string{"-config", path},
+			env:  map[string]string{"PETS_SERVER_PORT": "9001", "PETS_LIMITS_JOB_WORKERS": "8"},
+			expected: func(c *Config) {
+				c.Server.Port = 9001
+				c.Server.Addr = "127.0.0.1"
+				c.Log.Level = "info"
+				c.Limits.JobWorkers = 8
+			},
+		},
+		{
+			name: "unix sockets should be configurable",
+			env:  map[string]string{"PETS_SERVER_NETWORK": "unix", "PETS_SERVER_SOCKET_PATH": "/tmp/pets.sock", "PETS_SERVER_SOCKET_MODE": "0600"},
+			expected: func(c *Config) {
+				c.Server.Network = "unix"
+				c.Server.SocketPath = "/tmp/pets.sock"
+				c.Server.SocketMode = 0600
+			},
+		},
+		{
+			name: "flags should win over the environment",
+			args: []string{"-config", path, "-server.port", "9002", "-server.shutdown_timeout=1m"},
+			env:  map[string]string{"PETS_SERVER_PORT": "9001"},
+			expected: func(c *Config) {
+				c.Server.Port = 9002
+				c.Server.Addr = "127.0.0.1"
+				c.Server.ShutdownTimeout = time.Minute
+				c.Log.Level = "info"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expected = Default()
+			tt.expected(&expected)
+
+			c, err := Load(tt.args, env(tt.env))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, c)
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+
+	path := writeConfigFile(t, "pets.yaml", `
+server:
+  port: 80a
+  color: blue
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name: "values that can't be parsed should say where they are from",
+			args: []string{"-config", path, "-storage.flush_interval", "soon"},
+			env:  map[string]string{"PETS_LIMITS_JOB_WORKERS": "many"},
+			expected: "invalid configuration:\n" +
+				"  server.port (" + path + " line 3): \"80a\" is not an integer\n" +
+				"  server.color (" + path + " line 4): unknown setting\n" +
+				"  limits.job_workers (environment variable PETS_LIMITS_JOB_WORKERS): \"many\" is not an integer\n" +
+				"  storage.flush_interval (flag -storage.flush_interval): \"soon\" is not a duration, e.g. 30s or 5m",
+		},
+		{
+			name: "every invalid value should be listed",
+			args: []string{"-server.port", "0", "-storage.backend", "file", "-log.level", "loud"},
+			expected: "invalid configuration:\n" +
+				"  server.port: must be between 1 and 65535, got 0\n" +
+				"  storage.path: is required for the file backend\n" +
+				"  log.level: must be one of: debug, info, notice, warning, error, critical, got \"loud\"",
+		},
+		{
+			name: "unix sockets should need a path and valid permissions",
+			args: []string{"-server.network", "unix", "-server.socket_mode", "1777"},
+			expected: "invalid configuration:\n" +
+				"  server.socket_path: is required for the unix network\n" +
+				"  server.socket_mode: must be permissions between 0001 and 0777, got 1777",
+		},
+		{
+			name:     "socket modes should be octal",
+			env:      map[string]string{"PETS_SERVER_SOCKET_MODE": "rw-rw----"},
+			expected: "invalid configuration:\n  server.socket_mode (environment variable PETS_SERVER_SOCKET_MODE): \"rw-rw----\" is not an octal file mode, e.g. 0660",
+		},
+		{
+			name:     "the storage path should be somewhere that exists",
+			env:      map[string]string{"PETS_STORAGE_BACKEND": "file", "PETS_STORAGE_PATH": "/does/not/exist/pets.json"},
+			expected: "invalid configuration:\n  storage.path: directory /does/not/exist does not exist",
+		},
+		{
+			name: "serving HTTPS should need both a certificate and a key",
+			args: []string{"-tls.cert_file", "/does/not/exist.pem", "-tls.reload_interval", "0s"},
+			expected: "invalid configuration:\n" +
+				"  tls.cert_file: file /does/not/exist.pem does not exist\n" +
+				"  tls.key_file: is required to serve HTTPS\n" +
+				"  tls.reload_interval: must be greater than 0, got 0s",
+		},
+		{
+			name:     "client certificates should need HTTPS",
+			env:      map[string]string{"PETS_TLS_CLIENT_CA_FILE": "ca.pem"},
+			expected: "invalid configuration:\n  tls.client_ca_file: requires tls.cert_file and tls.key_file",
+		},
+		{
+			name:     "missing files should be an error",
+			args:     []string{"-config", "/does/not/exist.toml"},
+			expected: "open /does/not/exist.toml: no such file or directory",
+		},
+		{
+			name:     "positional args should be an error",
+			args:     []string{"serve"},
+			expected: "unexpected arguments: serve",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.args, env(tt.env))
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	assert.NoError(t, Default().Validate())
+
+	var c = Default()
+	c.Server.IdempotencyWindow = 0
+	c.Limits.MaxImportErrors = -1
+	c.Auth.Mode = "magic"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  server.idempotency_window: must be greater than 0, got 0s\n"+
+		"  limits.max_import_errors: must be greater than 0, got -1\n"+
+		"  auth.mode: must be one of: none, api_key, jwt, got \"magic\"")
+
+	c = Default()
+	c.Auth.Mode = "api_key"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.admin_key: is required for the api_key mode, unless auth.keys_path has keys")
+	c.Auth.AdminKey = "secret"
+	c.Auth.KeysPath = "/does/not/exist/keys.json"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.admin_key: must be at least 32 characters long\n"+
+		"  auth.keys_path: directory /does/not/exist does not exist")
+
+	c = Default()
+	c.Auth.Mode = "api_key,none"
+	c.Auth.AdminKey = "0123456789abcdef0123456789abcdef"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.mode: none can't be combined with other modes, got \"api_key,none\"")
+
+	c = Default()
+	c.RateLimit.Enabled = true
+	assert.NoError(t, c.Validate())
+	c.RateLimit.Period = 0
+	c.RateLimit.DailyQuota = -1
+	c.RateLimit.KeyBy = "tenant"
+	c.RateLimit.FailedAuths = -1
+	c.RateLimit.TrustedProxies = "10.0.0.0/8, 10.0.0.1"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  rate_limit.period: must be greater than 0, got 0s\n"+
+		"  rate_limit.daily_quota: must be 0 or greater, got -1\n"+
+		"  rate_limit.key_by: tenant requires tenancy.enabled\n"+
+		"  rate_limit.failed_auths: must be 0 or greater, got -1\n"+
+		"  rate_limit.trusted_proxies: \"10.0.0.1\" is not a network in CIDR notation, e.g. 10.0.0.0/8")
+
+	c = Default()
+	c.Storage.AuditPath = "/does/not/exist/audit.log"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  storage.audit_path: directory /does/not/exist does not exist")
+
+	c = Default()
+	c.Limits.MaxBodyBytesByRoute = "importPets=1073741824, batchPets=16777216"
+	assert.NoError(t, c.Validate())
+	limits, err := c.Limits.RouteMaxBodyBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"importPets": 1 << 30, "batchPets": 16 << 20}, limits)
+	c.Limits.MaxBodyBytes = 0
+	c.Limits.MaxBodyBytesByRoute = "importPets=lots"
+	c.Limits.MaxJSONDepth = -1
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  limits.max_body_bytes: must be greater than 0, got 0\n"+
+		"  limits.max_body_bytes_by_route: limit of route importPets must be a number of bytes greater than 0, got \"lots\"\n"+
+		"  limits.max_json_depth: must be greater than 0, got -1")
+	c.Limits.MaxBodyBytes = 1024
+	c.Limits.MaxJSONDepth = 32
+	c.Limits.MaxBodyBytesByRoute = "importPets"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  limits.max_body_bytes_by_route: \"importPets\" is not a route name and limit, e.g. importPets=1073741824")
+
+	c = Default()
+	c.Auth.Mode = "jwt"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.jwt.issuer: is required for the jwt mode\n"+
+		"  auth.jwt.audience: is required for the jwt mode\n"+
+		"  auth.jwt.jwks_file: is required for the jwt mode, unless auth.jwt.jwks_url is set")
+	c.Auth.JWT.Issuer = "https://issuer.example"
+	c.Auth.JWT.Audience = "pets"
+	c.Auth.JWT.JWKSURL = "issuer.example/jwks.json"
+	c.Auth.JWT.Leeway = -time.Second
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.jwt.jwks_url: must be an http or https URL, got \"issuer.example/jwks.json\"\n"+
+		"  auth.jwt.leeway: must be 0 or greater, got -1s")
+	c.Auth.JWT.JWKSURL = "http://127.0.0.1:9000/jwks.json"
+	c.Auth.JWT.Leeway = 0
+	assert.NoError(t, c.Validate())
+	c.Auth.PolicyFile = "/does/not/exist/policy.json"
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.policy_file: file /does/not/exist/policy.json does not exist")
+	c.Auth.PolicyFile = ""
+	assert.True(t, c.Auth.Has("jwt"))
+	assert.False(t, c.Auth.Has("api_key"))
+
+	c.Tenancy.Enabled = true
+	c.Tenancy.Header = "X Tenant"
+	c.Auth.JWT.TenantClaim = ""
+	assert.EqualError(t, c.Validate(), "invalid configuration:\n"+
+		"  auth.jwt.tenant_claim: is required for the jwt mode when tenancy.enabled is set\n"+
+		"  tenancy.header: must be an HTTP header name, got \"X Tenant\"")
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Value is a raw value from a configuration file, along with the line it is on
+type Value struct {
+	Raw  string
+	Line int
+}
+
+// ReadFile reads the settings in the configuration file at path, keyed by
+// their dotted path, e.g. server.port. The format is picked by the extension
+// of the file: .toml, or .yaml and .yml. Only the parts of the formats needed
+// for configuration are supported: tables or nested maps of scalar values.
+func ReadFile(path string) (map[string]Value, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var parse func(*bufio.Scanner) (map[string]Value, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		parse = parseTOML
+	case ".yaml", ".yml":
+		parse = parseYAML
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration file format, expected .toml, .yaml or .yml", path)
+	}
+
+	values, err := parse(bufio.NewScanner(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseTOML parses [tables] of key = value pairs
+func parseTOML(scanner *bufio.Scanner) (map[string]Value, error) {
+	var values = make(map[string]Value)
+	var table string
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table %s", line, text)
+			}
+			table = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		raw, err := unquote(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		key := join(table, strings.TrimSpace(parts[0]))
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set more than once", line, key)
+		}
+		values[key] = Value{Raw: raw, Line: line}
+	}
+	return values, scanner.Err()
+}
+
+// parseYAML parses maps nested by indentation, with scalar values
+func parseYAML(scanner *bufio.Scanner) (map[string]Value, error) {
+	type parent struct {
+		indent int
+		key    string
+	}
+
+	var values = make(map[string]Value)
+	var parents []parent
+	var line int
+	for scanner.Scan() {
+		line++
+		raw := stripComment(scanner.Text())
+		text := strings.TrimSpace(raw)
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.Contains(raw, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", line)
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+
+		// Leave the maps this line is not nested in
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		var prefix string
+		if len(parents) > 0 {
+			prefix = parents[len(parents)-1].key
+		}
+
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.HasPrefix(text, "- ") {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		key := join(prefix, strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if value == "" {
+			parents = append(parents, parent{indent: indent, key: key})
+			continue
+		}
+
+		value, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set more than once", line, key)
+		}
+		values[key] = Value{Raw: value, Line: line}
+	}
+	return values, scanner.Err()
+}
+
+// stripComment removes a # comment that isn't in a quoted string
+func stripComment(text string) string {
+	var quote rune
+	for i, c := range text {
+		switch {
+		case quote != 0 && c == quote && (quote == '\'' || i == 0 || text[i-1] != '\\'):
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// unquote returns the value of a quoted string, or v itself if it isn't one
+func unquote(v string) (string, error) {
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v[1 : len(v)-1], nil
+	}
+	if strings.HasPrefix(v, "\"") {
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", v)
+		}
+		return s, nil
+	}
+	return v, nil
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
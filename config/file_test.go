@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTOML(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]Value
+		isError  bool
+	}{
+		{
+			name: "tables, strings and comments should be parsed",
+			content: `# The pets server
+top = 1
+[server]
+addr = "0.0.0.0" # everywhere
+port = 8080
+
+[storage]
+path = 'C:\pets#1.json'
+`,
+			expected: map[string]Value{
+				"top":          {Raw: "1", Line: 2},
+				"server.addr":  {Raw: "0.0.0.0", Line: 4},
+				"server.port":  {Raw: "8080", Line: 5},
+				"storage.path": {Raw: `C:\pets#1.json`, Line: 8},
+			},
+		},
+		{name: "lines without a value should be an error", content: "[server]\nport\n", isError: true},
+		{name: "arrays of tables should be an error", content: "[[servers]]\n", isError: true},
+		{name: "keys set twice should be an error", content: "a = 1\na = 2\n", isError: true},
+		{name: "bad strings should be an error", content: `a = "\q"`, isError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseTOML(bufio.NewScanner(strings.NewReader(tt.content)))
+			assert.Equal(t, tt.isError, err != nil)
+			if !tt.isError {
+				assert.Equal(t, tt.expected, values)
+			}
+		})
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]Value
+		isError  bool
+	}{
+		{
+			name: "nested maps, strings and comments should be parsed",
+			content: `---
+# The pets server
+server:
+  addr: "0.0.0.0"   # everywhere
+  port: 8080
+  timeouts:
+    shutdown: 30s
+storage:
+  backend: 'file'
+`,
+			expected: map[string]Value{
+				"server.addr":              {Raw: "0.0.0.0", Line: 4},
+				"server.port":              {Raw: "8080", Line: 5},
+				"server.timeouts.shutdown": {Raw: "30s", Line: 7},
+				"storage.backend":          {Raw: "file", Line: 9},
+			},
+		},
+		{name: "lists should be an error", content: "hosts:\n  - a\n", isError: true},
+		{name: "tabs should be an error", content: "server:\n\tport: 1\n", isError: true},
+		{name: "keys set twice should be an error", content: "a: 1\na: 2\n", isError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseYAML(bufio.NewScanner(strings.NewReader(tt.content)))
+			assert.Equal(t, tt.isError, err != nil)
+			if !tt.isError {
+				assert.Equal(t, tt.expected, values)
+			}
+		})
+	}
+}
+
+func TestReadFile_UnsupportedFormat(t *testing.T) {
+	path := writeConfigFile(t, "pets.ini", "port=1")
+	_, err := ReadFile(path)
+	assert.EqualError(t, err, path+": unsupported configuration file format, expected .toml, .yaml or .yml")
+}
@@ -1,20 +1,214 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/teejays/clog"
 
+	"./config"
 	"./server"
+	"./server/handler"
+	"./server/route"
+	"./service/apikey"
+	"./service/audit"
+	"./service/job"
+	"./service/jwt"
+	"./service/pet"
+	"./service/ratelimit"
+	"./service/rbac"
 )
 
-var listenPort = 8080
-
 func main() {
-	// Increase the log level
-	clog.LogLevel = 0
+	c, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		clog.FatalErr(err)
+	}
 
-	err := server.StartServer("", listenPort)
+	err = apply(c)
 	if err != nil {
 		clog.FatalErr(err)
 	}
 
+	var opts = server.Options{
+		H2C:                c.Server.H2C,
+		CompressionMinSize: c.Server.CompressionMinSize,
+	}
+	opts.RouteMaxBodyBytes, err = routeMaxBodyBytes(c.Limits)
+	if err != nil {
+		clog.FatalErr(err)
+	}
+	if c.TLS.Enabled() {
+		opts.TLS = &server.TLSOptions{
+			CertFile:       c.TLS.CertFile,
+			KeyFile:        c.TLS.KeyFile,
+			ClientCAFile:   c.TLS.ClientCAFile,
+			ReloadInterval: c.TLS.ReloadInterval,
+		}
+	}
+
+	if c.Auth.Mode != "none" {
+		opts.Auth = &server.AuthOptions{
+			APIKeys:        c.Auth.Has("api_key"),
+			JWTRolesClaim:  c.Auth.JWT.RolesClaim,
+			JWTTenantClaim: c.Auth.JWT.TenantClaim,
+		}
+	}
+	if c.Auth.Has("jwt") {
+		opts.Auth.JWT, err = newJWTVerifier(c.Auth.JWT)
+		if err != nil {
+			clog.FatalErr(err)
+		}
+	}
+
+	if c.Tenancy.Enabled {
+		opts.Tenancy = &server.TenancyOptions{Header: c.Tenancy.Header}
+	}
+	if c.RateLimit.Enabled {
+		trustedProxies, err := c.RateLimit.TrustedProxyNetworks()
+		if err != nil {
+			clog.FatalErr(err)
+		}
+		opts.RateLimit = &server.RateLimitOptions{
+			Requests:       c.RateLimit.Requests,
+			Period:         c.RateLimit.Period,
+			DailyQuota:     c.RateLimit.DailyQuota,
+			KeyBy:          c.RateLimit.KeyBy,
+			FailedAuths:    c.RateLimit.FailedAuths,
+			TrustedProxies: trustedProxies,
+		}
+	}
+
+	listen := server.ListenOptions{
+		Network:    c.Server.Network,
+		Addr:       c.Server.Addr,
+		Port:       c.Server.Port,
+		SocketPath: c.Server.SocketPath,
+		SocketMode: c.Server.SocketMode,
+		FD:         c.Server.FD,
+	}
+
+	err = server.StartServer(listen, opts)
+	if err != nil {
+		clog.FatalErr(err)
+	}
+
+}
+
+// newJWTVerifier returns a verifier for the tokens of the configured issuer.
+// A JWKS file must be readable straight away, but a JWKS URL is fetched again
+// when tokens come in if the issuer isn't up yet.
+func newJWTVerifier(c config.JWTConfig) (*jwt.Verifier, error) {
+	var keys *jwt.KeySet
+	if c.JWKSFile != "" {
+		keys = jwt.NewFileKeySet(c.JWKSFile, c.RefreshInterval)
+		if err := keys.Refresh(); err != nil {
+			return nil, err
+		}
+	} else {
+		keys = jwt.NewURLKeySet(c.JWKSURL, &http.Client{Timeout: 10 * time.Second}, c.RefreshInterval)
+		if err := keys.Refresh(); err != nil {
+			clog.Warningf("JWT: could not fetch the JWKS, trying again when tokens come in: %v", err)
+		}
+	}
+	return jwt.NewVerifier(c.Issuer, c.Audience, keys, c.Leeway), nil
+}
+
+// routeMaxBodyBytes returns the body limits of routes by name, making sure
+// that the routes exist
+func routeMaxBodyBytes(c config.LimitsConfig) (map[string]int64, error) {
+	limits, err := c.RouteMaxBodyBytes()
+	if err != nil {
+		return nil, err
+	}
+	var names = make(map[string]bool)
+	for _, rt := range route.GetRoutes() {
+		names[rt.Name] = true
+	}
+	for name := range limits {
+		if !names[name] {
+			return nil, fmt.Errorf("limits.max_body_bytes_by_route: there is no route named %s", name)
+		}
+	}
+	return limits, nil
+}
+
+// apply sets the packages up with the configuration
+func apply(c config.Config) error {
+	// clog levels start at 1 for debug
+	for i, level := range config.LogLevels {
+		if level == c.Log.Level {
+			clog.LogLevel = i + 1
+		}
+	}
+
+	server.ReadHeaderTimeout = c.Server.ReadHeaderTimeout
+	server.ReadTimeout = c.Server.ReadTimeout
+	server.WriteTimeout = c.Server.WriteTimeout
+	server.IdleTimeout = c.Server.IdleTimeout
+	server.ShutdownTimeout = c.Server.ShutdownTimeout
+	server.IdempotencyWindow = c.Server.IdempotencyWindow
+	server.StorageFlushInterval = c.Storage.FlushInterval
+	server.MaxBodyBytes = int64(c.Limits.MaxBodyBytes)
+
+	handler.StrictJSON = c.Server.StrictJSON
+	handler.MaxJSONDepth = c.Limits.MaxJSONDepth
+
+	handler.MaxBatchOperations = c.Limits.MaxBatchOperations
+	handler.MaxImportLineBytes = c.Limits.MaxImportLineBytes
+	handler.MaxImportErrors = c.Limits.MaxImportErrors
+
+	if c.Limits.JobWorkers != job.DefaultWorkers || c.Limits.JobQueueSize != job.DefaultQueueSize {
+		job.DefaultWorkers = c.Limits.JobWorkers
+		job.DefaultQueueSize = c.Limits.JobQueueSize
+		// Nothing has been submitted to the default runner yet, so it
+		// stops straight away
+		old := job.DefaultRunner
+		job.DefaultRunner = job.NewRunner(job.DefaultWorkers, job.DefaultQueueSize)
+		old.Shutdown(context.Background())
+	}
+
+	if c.Auth.PolicyFile != "" {
+		policy, err := rbac.LoadFile(c.Auth.PolicyFile)
+		if err != nil {
+			return err
+		}
+		rbac.DefaultPolicy = policy
+	}
+	if c.Auth.KeysPath != "" {
+		if err := apikey.DefaultStore.Open(c.Auth.KeysPath); err != nil {
+			return err
+		}
+	}
+	if c.Auth.AdminKey != "" {
+		apikey.DefaultStore.AddStatic("admin", "Admin key from the configuration", c.Auth.AdminKey, []string{rbac.RoleAdmin})
+	}
+
+	if c.RateLimit.Enabled {
+		if c.RateLimit.QuotaPath != "" {
+			if err := ratelimit.DefaultQuotas.Open(c.RateLimit.QuotaPath); err != nil {
+				return err
+			}
+		} else if c.Storage.Backend == pet.StorageFile {
+			// Keep the counts in the storage file, along with the pets
+			pet.AddSection("quotas", ratelimit.DefaultQuotas)
+		}
+	}
+
+	if c.Storage.AuditPath != "" {
+		if err := audit.DefaultLog.Open(c.Storage.AuditPath); err != nil {
+			return err
+		}
+	}
+	if c.Storage.Backend == pet.StorageFile {
+		return pet.OpenFile(c.Storage.Path)
+	}
+	return nil
 }
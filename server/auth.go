@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"../service/apikey"
+	"../service/jwt"
+	"../service/ratelimit"
+	"../service/rbac"
+	apihandler "./handler"
+	"./route"
+)
+
+// AuthOptions configure how requests are authenticated. Routes that aren't
+// public can only be called by requests authenticated one of the ways that
+// are turned on.
+type AuthOptions struct {
+	// APIKeys authenticates requests with the keys in apikey.DefaultStore,
+	// given in the X-API-Key header or as a bearer token
+	APIKeys bool
+	// JWT authenticates requests with JWT bearer tokens it verifies
+	JWT *jwt.Verifier
+	// JWTRolesClaim is the claim of JWTs with the roles of the principal,
+	// DefaultJWTRolesClaim if it is empty
+	JWTRolesClaim string
+	// JWTTenantClaim is the claim of JWTs with the tenant of the principal,
+	// DefaultJWTTenantClaim if it is empty
+	JWTTenantClaim string
+}
+
+// DefaultJWTRolesClaim is the claim of JWTs that the roles of the principal
+// are taken from by default
+var DefaultJWTRolesClaim = "roles"
+
+// DefaultJWTTenantClaim is the claim of JWTs that the tenant of the principal
+// is taken from by default
+var DefaultJWTTenantClaim = "tenant"
+
+var apiKeyHeader = "X-API-Key"
+
+// authMiddleware returns a middleware that only lets requests to rt through
+// once they are authenticated, with the principal that made them, and only
+// if the roles of the principal grant the permissions of rt. Routes that are
+// public are left alone.
+//
+// Requests that fail to authenticate take from the bucket of the IP address
+// of their client in failures, if it isn't nil, as clientIP finds it with
+// trustedProxies. Once it is used up, requests from there are refused before
+// they are authenticated, so that keys and tokens can't be guessed by trying
+// them one after the other. Clients that can't be told apart, e.g. on a unix
+// socket, are never refused, so that one of them can't lock out the others.
+func authMiddleware(rt route.Route, opts AuthOptions, failures *ratelimit.Limiter, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if rt.Public {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ip string
+			limited := false
+			if failures != nil {
+				ip, limited = clientIP(r, trustedProxies)
+			}
+			if limited {
+				if d := failures.Peek(ip, 1); !d.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+					err := fmt.Errorf("%w: too many failed authentications", ratelimit.ErrRateLimited)
+					apihandler.WriteError(w, r, http.StatusTooManyRequests, err, false)
+					return
+				}
+			}
+
+			p, err := authenticate(r, opts)
+			if err != nil {
+				if limited {
+					failures.Take(ip, 1)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="pets"`)
+				apihandler.WriteError(w, r, http.StatusUnauthorized, err, false)
+				return
+			}
+			for _, perm := range rt.Permissions {
+				if !rbac.DefaultPolicy.Allows(p.Roles, perm) {
+					err := fmt.Errorf("%w: the %s permission is needed", apihandler.ErrForbidden, perm)
+					apihandler.WriteError(w, r, http.StatusForbidden, err, false)
+					return
+				}
+			}
+			next.ServeHTTP(w, apihandler.WithPrincipal(r, p))
+		})
+	}
+}
+
+// authenticate returns the principal that made r
+func authenticate(r *http.Request, opts AuthOptions) (apihandler.Principal, error) {
+	if opts.APIKeys {
+		if secret := getAPIKey(r); secret != "" {
+			key, err := apikey.DefaultStore.Authenticate(secret)
+			if err != nil {
+				return apihandler.Principal{}, err
+			}
+			return apihandler.Principal{
+				Kind:   apihandler.PrincipalAPIKey,
+				ID:     key.ID,
+				Name:   key.Label,
+				Roles:  key.Roles,
+				Tenant: key.Tenant,
+			}, nil
+		}
+	}
+	if opts.JWT != nil {
+		if token := getBearerToken(r); token != "" && !strings.HasPrefix(token, apikey.SecretPrefix) {
+			claims, err := opts.JWT.Verify(token)
+			if err != nil {
+				return apihandler.Principal{}, err
+			}
+			name := claims.String("name")
+			if name == "" {
+				name = claims.Subject()
+			}
+			rolesClaim := opts.JWTRolesClaim
+			if rolesClaim == "" {
+				rolesClaim = DefaultJWTRolesClaim
+			}
+			tenantClaim := opts.JWTTenantClaim
+			if tenantClaim == "" {
+				tenantClaim = DefaultJWTTenantClaim
+			}
+			return apihandler.Principal{
+				Kind:   apihandler.PrincipalJWT,
+				ID:     claims.Subject(),
+				Name:   name,
+				Roles:  claims.Strings(rolesClaim),
+				Tenant: claims.String(tenantClaim),
+				Claims: claims,
+			}, nil
+		}
+	}
+	return apihandler.Principal{}, apihandler.ErrUnauthenticated
+}
+
+// getAPIKey returns the API key in the X-API-Key header of r, or its bearer
+// token if that is an API key
+func getAPIKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if token := getBearerToken(r); strings.HasPrefix(token, apikey.SecretPrefix) {
+		return token
+	}
+	return ""
+}
+
+// getBearerToken returns the token in the Authorization header of r, if it
+// has one
+func getBearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
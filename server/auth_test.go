@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/apikey"
+	"../service/jwt"
+	apihandler "./handler"
+	"./route"
+)
+
+func TestAuth_APIKeys(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("admin", "Admin", "admin-secret", []string{"admin"})
+	_, partner, err := apikey.DefaultStore.Create("partner", "", []string{"reader"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, editor, err := apikey.DefaultStore.Create("editor", "", []string{"editor"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHandler(Options{Auth: &AuthOptions{APIKeys: true}})
+
+	tests := []struct {
+		name          string
+		method        string
+		route         string
+		header        http.Header
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:          "requests without a key should be refused",
+			method:        http.MethodGet,
+			route:         "/v1/pets",
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeUnauthorized,
+		},
+		{
+			name:          "requests with a key that doesn't exist should be refused",
+			method:        http.MethodGet,
+			route:         "/v1/pets",
+			header:        http.Header{"X-Api-Key": {"pk_guess"}},
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeAPIKeyInvalid,
+		},
+		{
+			name:         "keys should be taken from the X-API-Key header",
+			method:       http.MethodGet,
+			route:        "/v1/pets",
+			header:       http.Header{"X-Api-Key": {partner}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "keys should be taken from a bearer token",
+			method:       http.MethodGet,
+			route:        "/v2/pets",
+			header:       http.Header{"Authorization": {"Bearer " + partner}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "admin routes should refuse other keys",
+			method:        http.MethodGet,
+			route:         "/v1/admin/keys",
+			header:        http.Header{"X-Api-Key": {partner}},
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:         "admin routes should take admin keys",
+			method:       http.MethodGet,
+			route:        "/v1/admin/keys",
+			header:       http.Header{"X-Api-Key": {"admin-secret"}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "keys should only be allowed what their roles grant",
+			method:        http.MethodPost,
+			route:         "/v1/pets",
+			header:        http.Header{"X-Api-Key": {partner}},
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:         "keys with a role that grants it should be allowed",
+			method:       http.MethodPost,
+			route:        "/v1/pets",
+			header:       http.Header{"X-Api-Key": {editor}},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "public routes should not need a key",
+			method:       http.MethodGet,
+			route:        "/v1/openapi.json",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.route, bytes.NewBufferString(`{"id": 4601, "name": "Rex"}`))
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedError != "" {
+				var errE apihandler.Error
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedError, errE.Code)
+			}
+			if tt.expectedCode == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="pets"`, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	// Without auth options, the API is open
+	rr := httptest.NewRecorder()
+	newHandler(Options{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/pets", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAuth_ManageAPIKeys(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("admin", "Admin", "admin-secret", []string{"admin"})
+
+	h := newHandler(Options{Auth: &AuthOptions{APIKeys: true}})
+	call := func(method, route, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, route, bytes.NewBufferString(body))
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The admin creates a key for a partner
+	rr := call(http.MethodPost, "/v1/admin/keys", "admin-secret", `{"label": "partner", "roles": ["reader"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created apihandler.APIKeySecret
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "partner", created.Key.Label)
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/v1/pets", created.Secret, "").Code)
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/v1/admin/keys", created.Secret, "").Code)
+
+	// Keys need a label, and roles that are in the policy
+	rr = call(http.MethodPost, "/v1/admin/keys", "admin-secret", `{"label": ""}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = call(http.MethodPost, "/v1/admin/keys", "admin-secret", `{"label": "partner", "roles": ["owner"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), apihandler.CodeUnknownRole)
+
+	// Rotating the key gives it a new secret
+	rr = call(http.MethodPost, "/v1/admin/keys/"+created.Key.ID+":rotate", "admin-secret", `{}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var rotated apihandler.APIKeySecret
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rotated))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/v1/pets", created.Secret, "").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "/v1/pets", rotated.Secret, "").Code)
+
+	// Revoking it stops it from working
+	rr = call(http.MethodDelete, "/v1/admin/keys/"+created.Key.ID, "admin-secret", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = call(http.MethodGet, "/v1/pets", rotated.Secret, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), apihandler.CodeAPIKeyRevoked)
+
+	// Keys from the configuration can't be changed
+	rr = call(http.MethodDelete, "/v1/admin/keys/admin", "admin-secret", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = call(http.MethodDelete, "/v1/admin/keys/missing", "admin-secret", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// testIssuer is a stand-in for an OpenID Connect issuer, that serves its
+// JWKS and signs RS256 tokens
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &testIssuer{key: key}
+	i.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	return i
+}
+
+func (i *testIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuth_JWT(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+
+	apikey.DefaultStore = apikey.NewStore()
+	_, partner, err := apikey.DefaultStore.Create("partner", "", []string{"reader"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	keys := jwt.NewURLKeySet(issuer.URL, issuer.Client(), time.Hour)
+	opts := AuthOptions{APIKeys: true, JWT: jwt.NewVerifier(issuer.URL, "pets", keys, 0)}
+	h := newHandler(Options{Auth: &opts})
+
+	token := func(changes map[string]interface{}) string {
+		claims := map[string]interface{}{
+			"iss":   issuer.URL,
+			"aud":   "pets",
+			"sub":   "alice",
+			"name":  "Alice",
+			"roles": []string{"reader"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range changes {
+			claims[k] = v
+		}
+		return "Bearer " + issuer.sign(claims)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		auth          string
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:         "tokens from the issuer should be accepted",
+			auth:         token(nil),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:          "expired tokens should be refused",
+			auth:          token(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeTokenExpired,
+		},
+		{
+			name:          "tokens for another audience should be refused",
+			auth:          token(map[string]interface{}{"aud": "other"}),
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeTokenInvalid,
+		},
+		{
+			name:          "tokens from another issuer should be refused",
+			auth:          token(map[string]interface{}{"iss": "https://other.test"}),
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeTokenInvalid,
+		},
+		{
+			name:          "tokens should only be allowed what their roles grant",
+			method:        http.MethodPost,
+			auth:          token(nil),
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:          "tokens without roles should be allowed nothing",
+			auth:          token(map[string]interface{}{"roles": nil}),
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:          "tokens that aren't JWTs should be refused",
+			auth:          "Bearer guess",
+			expectedCode:  http.StatusUnauthorized,
+			expectedError: apihandler.CodeTokenInvalid,
+		},
+		{
+			name:         "API keys should still be accepted as bearer tokens",
+			auth:         "Bearer " + partner,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/pets", bytes.NewBufferString(`{"id": 4601, "name": "Rex"}`))
+			req.Header.Set("Authorization", tt.auth)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedError != "" {
+				var errE apihandler.Error
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedError, errE.Code)
+			}
+		})
+	}
+
+	// Handlers get the claims of the token
+	var principal apihandler.Principal
+	req := httptest.NewRequest(http.MethodGet, "/v1/pets", nil)
+	req.Header.Set("Authorization", token(map[string]interface{}{"scope": "pets:read"}))
+	authMiddleware(route.Route{}, opts, nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = apihandler.GetPrincipal(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, apihandler.PrincipalJWT, principal.Kind)
+	assert.Equal(t, "alice", principal.ID)
+	assert.Equal(t, "Alice", principal.Name)
+	assert.Equal(t, "pets:read", principal.Claims["scope"])
+}
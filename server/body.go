@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	apihandler "./handler"
+	"./route"
+)
+
+// getMaxBodyBytes returns the largest request body rt takes with opts
+func getMaxBodyBytes(rt route.Route, opts Options) int64 {
+	if limit, exists := opts.RouteMaxBodyBytes[rt.Name]; exists {
+		return limit
+	}
+	if rt.MaxBodyBytes > 0 {
+		return rt.MaxBodyBytes
+	}
+	return opts.MaxBodyBytes
+}
+
+// bodyLimitMiddleware returns a middleware that refuses request bodies over
+// limit bytes with Request Entity Too Large. Bodies that say they are too
+// large are refused straight away, others once reading them goes over.
+func bodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				err := fmt.Errorf("%w: the limit is %d bytes", apihandler.ErrBodyTooLarge, limit)
+				apihandler.WriteBodyError(w, r, err)
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
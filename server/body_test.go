@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/pet"
+	apihandler "./handler"
+	"./route"
+)
+
+func TestBodyLimits(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	h := newHandler(Options{MaxBodyBytes: 64, RouteMaxBodyBytes: map[string]int64{"createPetV2": 1024}})
+
+	long := `{"id": 1, "name": "` + strings.Repeat("x", 100) + `"}`
+	tests := []struct {
+		name          string
+		route         string
+		body          string
+		chunked       bool
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:         "bodies within the limit should be served",
+			route:        "/v1/pets",
+			body:         `{"id": 1, "name": "Rex"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:          "bodies over the limit should be refused",
+			route:         "/v1/pets",
+			body:          long,
+			expectedCode:  http.StatusRequestEntityTooLarge,
+			expectedError: apihandler.CodeBodyTooLarge,
+		},
+		{
+			name:          "bodies without a length should be refused once they go over",
+			route:         "/v1/pets",
+			body:          long,
+			chunked:       true,
+			expectedCode:  http.StatusRequestEntityTooLarge,
+			expectedError: apihandler.CodeBodyTooLarge,
+		},
+		{
+			name:         "routes should have the limit they are configured with",
+			route:        "/v2/pets",
+			body:         long,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:          "deep bodies should be refused before validation",
+			route:         "/v2/pets",
+			body:          strings.Repeat("[", 100) + strings.Repeat("]", 100),
+			expectedCode:  http.StatusBadRequest,
+			expectedError: apihandler.CodeJSONTooDeep,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.route, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code, w.Body.String())
+			if tt.expectedError != "" {
+				var errE apihandler.Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedError, errE.Code)
+			}
+		})
+	}
+}
+
+func TestGetMaxBodyBytes(t *testing.T) {
+	opts := Options{MaxBodyBytes: 100, RouteMaxBodyBytes: map[string]int64{"b": 300}}
+	assert.Equal(t, int64(100), getMaxBodyBytes(route.Route{Name: "a"}, opts))
+	assert.Equal(t, int64(200), getMaxBodyBytes(route.Route{Name: "a", MaxBodyBytes: 200}, opts))
+	assert.Equal(t, int64(300), getMaxBodyBytes(route.Route{Name: "b", MaxBodyBytes: 200}, opts))
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionMinSize is the smallest response, in bytes, that is compressed.
+// Smaller ones gain little, and cost the client and server time.
+var CompressionMinSize = 1024
+
+// compressor is a compressing writer that can be reused for another response
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoding is a Content-Encoding the server can compress responses with
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+func newEncoding(name string, newCompressor func() compressor) encoding {
+	return encoding{name: name, pool: &sync.Pool{New: func() interface{} { return newCompressor() }}}
+}
+
+// encodings are the encodings the server supports, in the order it prefers
+// them when a client accepts more than one equally
+var encodings = []encoding{
+	newEncoding("zstd", func() compressor {
+		// Responses are compressed by the request goroutine
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return e
+	}),
+	newEncoding("br", func() compressor {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}),
+	newEncoding("gzip", func() compressor {
+		return gzip.NewWriter(nil)
+	}),
+}
+
+// getEncoding returns the encoding to compress a response with for the
+// Accept-Encoding header of a request, following RFC 9110 12.5.3. It
+// returns false if none of them are accepted.
+func getEncoding(acceptEncoding string) (encoding, bool) {
+	var weights = make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		var q = 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	var best encoding
+	var bestQ float64
+	for _, e := range encodings {
+		q, exists := weights[e.name]
+		if !exists {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// compressMiddleware returns a middleware that compresses responses of at
+// least minSize bytes with an encoding the client accepts
+func compressMiddleware(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			e, ok := getEncoding(r.Header.Get("Accept-Encoding"))
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: w, encoding: e, minSize: minSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressResponseWriter holds back the start of a response until it knows
+// whether it is big enough to compress. Responses that are flushed before
+// then are streams, and are compressed too.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding encoding
+	minSize  int
+
+	statusCode int
+	buf        []byte
+	decided    bool
+	// c is set if the response is being compressed
+	c compressor
+}
+
+func (cw *compressResponseWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.statusCode = code
+	// Responses without a body have nothing to compress
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.minSize {
+			if err := cw.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.c != nil {
+		return cw.c.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start writes the header, compressed if compress is true and the handler
+// didn't encode the response itself, and then what was held back
+func (cw *compressResponseWriter) start(compress bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if compress && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", cw.encoding.name)
+		h.Del("Content-Length")
+		cw.c = cw.encoding.pool.Get().(compressor)
+		cw.c.Reset(cw.ResponseWriter)
+	}
+	if cw.statusCode != 0 {
+		cw.ResponseWriter.WriteHeader(cw.statusCode)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Flush sends what has been written so far to the client
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		if len(cw.buf) == 0 {
+			return
+		}
+		cw.start(true)
+	}
+	if cw.c != nil {
+		cw.c.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returns. Responses that
+// never reached minSize are written as they are.
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		cw.start(false)
+	}
+	if cw.c != nil {
+		cw.c.Close()
+		cw.c.Reset(nil)
+		cw.encoding.pool.Put(cw.c)
+	}
+}
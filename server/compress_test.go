@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/pet"
+)
+
+func TestGetEncoding(t *testing.T) {
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{acceptEncoding: "", expected: ""},
+		{acceptEncoding: "gzip", expected: "gzip"},
+		{acceptEncoding: "gzip, deflate, br", expected: "br"},
+		{acceptEncoding: "gzip, br, zstd", expected: "zstd"},
+		{acceptEncoding: "zstd;q=0.5, GZIP", expected: "gzip"},
+		{acceptEncoding: "br;q=0, gzip;q=0.1", expected: "gzip"},
+		{acceptEncoding: "*", expected: "zstd"},
+		{acceptEncoding: "*, zstd;q=0", expected: "br"},
+		{acceptEncoding: "identity", expected: ""},
+		{acceptEncoding: "deflate, *;q=0", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			e, ok := getEncoding(tt.acceptEncoding)
+			assert.Equal(t, tt.expected != "", ok)
+			assert.Equal(t, tt.expected, e.name)
+		})
+	}
+}
+
+// decode returns body decoded from encoding
+func decode(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+func TestCompressMiddleware(t *testing.T) {
+
+	var big = strings.Repeat(`{"id": 1, "name": "Tommy"}`, 100)
+
+	tests := []struct {
+		name             string
+		acceptEncoding   string
+		handler          http.HandlerFunc
+		expectedStatus   int
+		expectedEncoding string
+		expectedBody     string
+	}{
+		{
+			name:           "small responses should not be compressed",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id": 1}`))
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id": 1}`,
+		},
+		{
+			name:           "big responses should not be compressed for clients that can't decode them",
+			acceptEncoding: "identity",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(big))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   big,
+		},
+		{
+			name:           "big responses should be compressed with gzip",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte(big[:500]))
+				w.Write([]byte(big[500:]))
+			},
+			expectedStatus:   http.StatusAccepted,
+			expectedEncoding: "gzip",
+			expectedBody:     big,
+		},
+		{
+			name:           "big responses should be compressed with brotli",
+			acceptEncoding: "br",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(big))
+			},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "br",
+			expectedBody:     big,
+		},
+		{
+			name:           "big responses should be compressed with zstd",
+			acceptEncoding: "gzip, br, zstd",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(big))
+			},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+			expectedBody:     big,
+		},
+		{
+			name:           "flushed responses should be compressed whatever their size",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"id": 1}`))
+				w.(http.Flusher).Flush()
+				w.Write([]byte(`{"id": 2}`))
+			},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "gzip",
+			expectedBody:     `{"id": 1}{"id": 2}`,
+		},
+		{
+			name:           "responses encoded by the handler should be left alone",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "identity")
+				w.Write([]byte(big))
+			},
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "identity",
+			expectedBody:     big,
+		},
+		{
+			name:           "responses without a body should be left alone",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/pets", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+			compressMiddleware(1024)(tt.handler).ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			assert.Equal(t, tt.expectedEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, tt.expectedBody, decode(t, tt.expectedEncoding, rr.Body.Bytes()))
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	for i := 1; i <= 50; i++ {
+		if err := pet.AddPet(pet.DefaultTenant, pet.Pet{ID: int64(i), Name: fmt.Sprintf("Pet %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newHandler(Options{})
+
+	tests := []struct {
+		route            string
+		expectedEncoding string
+	}{
+		{route: "/v1/pets?limit=50", expectedEncoding: "br"},
+		{route: "/v1/pets/1", expectedEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.route, nil)
+			req.Header.Set("Accept-Encoding", "gzip, br")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.expectedEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Contains(t, decode(t, tt.expectedEncoding, rr.Body.Bytes()), `"name":"Pet 1"`)
+		})
+	}
+}
+
+func TestServer_H2C(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	// A client that speaks HTTP/2 straight away, without TLS
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{
+		Transport: &http.Transport{Protocols: &protocols},
+		Timeout:   5 * time.Second,
+	}
+
+	for _, h2c := range []bool{true, false} {
+		t.Run(fmt.Sprintf("h2c %t", h2c), func(t *testing.T) {
+			s, err := Start(ListenOptions{Addr: "127.0.0.1"}, Options{H2C: h2c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			resp, err := client.Get(fmt.Sprintf("http://%s/v1/pets", s.Addr()))
+			if !h2c {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, 2, resp.ProtoMajor)
+			}
+		})
+	}
+}
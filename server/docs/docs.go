@@ -0,0 +1,34 @@
+package docs
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+)
+
+// Path is where the API explorer is served
+var Path = "/docs"
+
+//go:embed index.html
+var files embed.FS
+
+var page = template.Must(template.ParseFS(files, "index.html"))
+
+// Handler returns the handler that serves the API explorer. The page loads the
+// OpenAPI document from specURL and sends requests to the same server, so it
+// needs nothing from outside.
+func Handler(specURL string) (http.Handler, error) {
+	var buf bytes.Buffer
+	err := page.Execute(&buf, struct{ SpecURL string }{specURL})
+	if err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}), nil
+}
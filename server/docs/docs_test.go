@@ -0,0 +1,34 @@
+package docs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+
+	h, err := Handler("/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=UTF-8", w.Header().Get("Content-Type"))
+	// The spec URL should be written into the page as a JS string
+	assert.Contains(t, string(body), `const specURL = "/v1/openapi.json";`)
+	// Everything should be served from here, without any CDN
+	assert.NotContains(t, string(body), "<script src=")
+	assert.NotContains(t, string(body), "<link")
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"../../service/apikey"
+	"../../service/rbac"
+)
+
+// CreateAPIKeyRequest is the request body to create an API key
+type CreateAPIKeyRequest struct {
+	// Label says who or what the key is for
+	Label string `json:"label" schema:"minLength=1,maxLength=100"`
+	// Roles grant the key permissions, they must be in the policy
+	Roles []string `json:"roles,omitempty"`
+	// Tenant is the only tenant the key can act for, any of them if it
+	// isn't set
+	Tenant string `json:"tenant,omitempty"`
+	// ExpiresAt is when the key stops working, never if it isn't set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// RotateAPIKeyRequest is the request body to rotate an API key
+type RotateAPIKeyRequest struct {
+	// ExpiresAt is when the new secret stops working, the same as the old
+	// one if it isn't set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeySecret is an API key along with its secret. It is the only time the
+// secret is given out, so it must be kept by the client.
+type APIKeySecret struct {
+	Key    apikey.Key `json:"key"`
+	Secret string     `json:"secret"`
+}
+
+// getBoundTenant returns the tenant the principal of r is bound to, if it is
+// bound to one. Such principals only manage the keys of their own tenant.
+func getBoundTenant(r *http.Request) (string, bool) {
+	p, ok := GetPrincipal(r)
+	if !ok || p.Tenant == "" {
+		return "", false
+	}
+	return p.Tenant, true
+}
+
+// HandleListAPIKeys returns the API keys, without their secrets. Principals
+// bound to a tenant only get the keys of their tenant.
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys := apikey.DefaultStore.List()
+	if tenant, bound := getBoundTenant(r); bound {
+		var own = []apikey.Key{}
+		for _, key := range keys {
+			if key.Tenant == tenant {
+				own = append(own, key)
+			}
+		}
+		keys = own
+	}
+	writeResponse(w, http.StatusOK, keys)
+}
+
+// HandleCreateAPIKey creates an API key, and returns it with its secret
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if err := rbac.DefaultPolicy.CheckRoles(req.Roles); err != nil {
+		writeError(w, r, http.StatusBadRequest, err, false)
+		return
+	}
+	// Principals bound to a tenant can only create keys for it
+	if tenant, bound := getBoundTenant(r); bound {
+		if req.Tenant != "" && req.Tenant != tenant {
+			writeError(w, r, http.StatusForbidden, fmt.Errorf("%w: keys can only be created for tenant %s", ErrForbidden, tenant), false)
+			return
+		}
+		req.Tenant = tenant
+	}
+	if req.Tenant != "" {
+		if err := CheckTenant(req.Tenant); err != nil {
+			writeError(w, r, http.StatusBadRequest, err, false)
+			return
+		}
+	}
+	key, secret, err := apikey.DefaultStore.Create(req.Label, req.Tenant, req.Roles, expiresAt)
+	if err == apikey.ErrInvalidLabel || err == apikey.ErrInvalidExpiry {
+		writeError(w, r, http.StatusBadRequest, err, false)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, APIKeySecret{Key: key, Secret: secret})
+}
+
+// HandleRotateAPIKey gives the API key that has the provided ID a new secret,
+// and returns it. The old secret stops working straight away.
+func HandleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req RotateAPIKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !checkAPIKeyTenant(w, r, mux.Vars(r)["id"]) {
+		return
+	}
+	key, secret, err := apikey.DefaultStore.Rotate(mux.Vars(r)["id"], expiresAt)
+	if !checkAPIKeyError(w, r, err) {
+		return
+	}
+
+	writeResponse(w, http.StatusOK, APIKeySecret{Key: key, Secret: secret})
+}
+
+// HandleRevokeAPIKey stops the API key that has the provided ID from working
+func HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !checkAPIKeyTenant(w, r, mux.Vars(r)["id"]) {
+		return
+	}
+	key, err := apikey.DefaultStore.Revoke(mux.Vars(r)["id"])
+	if !checkAPIKeyError(w, r, err) {
+		return
+	}
+
+	writeResponse(w, http.StatusOK, key)
+}
+
+// checkAPIKeyTenant writes the error response if the principal of r is
+// bound to a tenant, and the API key with the given ID isn't of that tenant.
+// Keys of other tenants are not found, as if they didn't exist. It returns
+// true if the key can be changed.
+func checkAPIKeyTenant(w http.ResponseWriter, r *http.Request, id string) bool {
+	tenant, bound := getBoundTenant(r)
+	if !bound {
+		return true
+	}
+	key, err := apikey.DefaultStore.Get(id)
+	if err == nil && key.Tenant != tenant {
+		err = apikey.ErrNotExist
+	}
+	return checkAPIKeyError(w, r, err)
+}
+
+// checkAPIKeyError writes the error response for err, if it isn't nil, from
+// changing an API key. It returns true if there was no error.
+func checkAPIKeyError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case apikey.ErrNotExist:
+		writeError(w, r, http.StatusNotFound, err, false)
+	case apikey.ErrStatic:
+		writeError(w, r, http.StatusConflict, err, false)
+	case apikey.ErrInvalidExpiry:
+		writeError(w, r, http.StatusBadRequest, err, false)
+	default:
+		writeError(w, r, http.StatusInternalServerError, err, true)
+	}
+	return false
+}
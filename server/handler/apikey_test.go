@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/apikey"
+)
+
+func TestAPIKeyHandlers(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewStore()
+
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		method       string
+		id           string
+		body         string
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "keys should be created",
+			handler:      HandleCreateAPIKey,
+			method:       http.MethodPost,
+			body:         `{"label": "partner", "expires_at": "2999-01-01T00:00:00Z"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "keys that have already expired should not be created",
+			handler:      HandleCreateAPIKey,
+			method:       http.MethodPost,
+			body:         `{"label": "partner", "expires_at": "2000-01-01T00:00:00Z"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeInvalidRequest,
+		},
+		{
+			name:         "keys with a role that isn't in the policy should not be created",
+			handler:      HandleCreateAPIKey,
+			method:       http.MethodPost,
+			body:         `{"label": "partner", "roles": ["reader", "owner"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeUnknownRole,
+		},
+		{
+			name:         "keys for an invalid tenant should not be created",
+			handler:      HandleCreateAPIKey,
+			method:       http.MethodPost,
+			body:         `{"label": "partner", "tenant": "Acme Inc"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeInvalidTenant,
+		},
+		{
+			name:         "invalid JSON should be a bad request",
+			handler:      HandleCreateAPIKey,
+			method:       http.MethodPost,
+			body:         `{"label":`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeInvalidJSON,
+		},
+		{
+			name:         "rotating a key that doesn't exist should be not found",
+			handler:      HandleRotateAPIKey,
+			method:       http.MethodPost,
+			id:           "missing",
+			body:         `{}`,
+			expectedCode: http.StatusNotFound,
+			expectedErr:  CodeAPIKeyNotFound,
+		},
+		{
+			name:         "revoking a key that doesn't exist should be not found",
+			handler:      HandleRevokeAPIKey,
+			method:       http.MethodDelete,
+			id:           "missing",
+			expectedCode: http.StatusNotFound,
+			expectedErr:  CodeAPIKeyNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/admin/keys", bytes.NewBufferString(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedErr != "" {
+				var errE Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedErr, errE.Code)
+			}
+		})
+	}
+
+	// Only the created key is listed, without its secret
+	w := httptest.NewRecorder()
+	HandleListAPIKeys(w, httptest.NewRequest(http.MethodGet, "/v1/admin/keys", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var keys []apikey.Key
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "partner", keys[0].Label)
+	}
+	assert.NotContains(t, w.Body.String(), "secret")
+	assert.NotContains(t, w.Body.String(), "hash")
+}
+
+func TestAPIKeyHandlers_Tenant(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewStore()
+	acme, _, err := apikey.DefaultStore.Create("acme", "acme", []string{"reader"}, time.Time{})
+	assert.NoError(t, err)
+	globex, _, err := apikey.DefaultStore.Create("globex", "globex", []string{"reader"}, time.Time{})
+	assert.NoError(t, err)
+
+	// The admin of acme is bound to it
+	var asAcmeAdmin = func(method, id, body string) *http.Request {
+		r := httptest.NewRequest(method, "/v1/admin/keys", bytes.NewBufferString(body))
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		return WithPrincipal(r, Principal{Kind: PrincipalAPIKey, ID: "acme-admin", Roles: []string{"admin"}, Tenant: "acme"})
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		r              *http.Request
+		expectedCode   int
+		expectedErr    string
+		expectedTenant string
+	}{
+		{
+			name:           "keys should be created for the tenant of the principal",
+			handler:        HandleCreateAPIKey,
+			r:              asAcmeAdmin(http.MethodPost, "", `{"label": "ci"}`),
+			expectedCode:   http.StatusCreated,
+			expectedTenant: "acme",
+		},
+		{
+			name:           "keys should be created for the same tenant when it is set",
+			handler:        HandleCreateAPIKey,
+			r:              asAcmeAdmin(http.MethodPost, "", `{"label": "ci", "tenant": "acme"}`),
+			expectedCode:   http.StatusCreated,
+			expectedTenant: "acme",
+		},
+		{
+			name:         "keys for other tenants should be forbidden",
+			handler:      HandleCreateAPIKey,
+			r:            asAcmeAdmin(http.MethodPost, "", `{"label": "ci", "tenant": "globex"}`),
+			expectedCode: http.StatusForbidden,
+			expectedErr:  CodeForbidden,
+		},
+		{
+			name:         "keys of other tenants should not be rotated",
+			handler:      HandleRotateAPIKey,
+			r:            asAcmeAdmin(http.MethodPost, globex.ID, `{}`),
+			expectedCode: http.StatusNotFound,
+			expectedErr:  CodeAPIKeyNotFound,
+		},
+		{
+			name:         "keys of other tenants should not be revoked",
+			handler:      HandleRevokeAPIKey,
+			r:            asAcmeAdmin(http.MethodDelete, globex.ID, ""),
+			expectedCode: http.StatusNotFound,
+			expectedErr:  CodeAPIKeyNotFound,
+		},
+		{
+			name:           "keys of the same tenant should be rotated",
+			handler:        HandleRotateAPIKey,
+			r:              asAcmeAdmin(http.MethodPost, acme.ID, `{}`),
+			expectedCode:   http.StatusOK,
+			expectedTenant: "acme",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedErr != "" {
+				var errE Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedErr, errE.Code)
+			}
+			if tt.expectedTenant != "" {
+				var resp APIKeySecret
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, tt.expectedTenant, resp.Key.Tenant)
+			}
+		})
+	}
+
+	// Only the keys of acme are listed
+	w := httptest.NewRecorder()
+	HandleListAPIKeys(w, asAcmeAdmin(http.MethodGet, "", ""))
+	var keys []apikey.Key
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	assert.Len(t, keys, 3)
+	for _, key := range keys {
+		assert.Equal(t, "acme", key.Tenant)
+	}
+
+	// and the key of globex was left alone
+	key, err := apikey.DefaultStore.Get(globex.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, key.RevokedAt)
+	assert.Nil(t, key.RotatedAt)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../../service/audit"
+	"github.com/teejays/clog"
+)
+
+// AnonymousActor is the actor of changes made by requests without a
+// principal, when the API doesn't authenticate them
+const AnonymousActor = "anonymous"
+
+// MaxAuditEntries is the most audit entries a request can list at once
+var MaxAuditEntries = 1000
+
+// getActor returns who is making r, as recorded in the audit log
+func getActor(r *http.Request) audit.Actor {
+	actor := audit.Actor{Principal: AnonymousActor, RequestID: GetRequestID(r)}
+	if p, ok := GetPrincipal(r); ok {
+		actor.Principal = p.Kind + ":" + p.ID
+	} else if id, ok := GetClientIdentity(r); ok {
+		actor.Principal = "client:" + id.Subject
+	}
+	return actor
+}
+
+// HandleListAudit returns the audit entries for the pets of the tenant of
+// the request, oldest first. They can be filtered by pet, actor and time.
+func HandleListAudit(w http.ResponseWriter, r *http.Request) {
+	clog.Debugf("Request Path: %+v", r.URL)
+
+	var f = audit.Filter{Tenant: GetTenant(r)}
+	var err error
+	if f.Limit, err = getQueryParamInt(r, "limit", 100); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	if f.Limit < 1 || f.Limit > MaxAuditEntries {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", MaxAuditEntries), false)
+		return
+	}
+	petID, err := getQueryParamInt(r, "pet_id", 0)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	after, err := getQueryParamInt(r, "after", 0)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	f.PetID, f.After = int64(petID), int64(after)
+	if f.Actor, err = getQueryParamString(r, "actor", ""); err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	since, err := getQueryParamString(r, "since", "")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	if since != "" {
+		if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid since '%s': should be an RFC 3339 date-time", since), false)
+			return
+		}
+	}
+
+	entries := audit.DefaultLog.Query(f)
+
+	// Add the header for next page url, which carries on after the last entry
+	if len(entries) == f.Limit {
+		q := r.URL.Query()
+		q.Set("after", strconv.FormatInt(entries[len(entries)-1].Seq, 10))
+		w.Header().Set("x-next", r.URL.Path+"?"+q.Encode())
+	}
+
+	writeResponse(w, http.StatusOK, entries)
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/audit"
+	"../../service/pet"
+)
+
+func TestHandleListAudit(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(log *audit.Log) {
+		clog.LogLevel = 0
+		audit.DefaultLog = log
+		pet.ResetData()
+	}(audit.DefaultLog)
+	audit.DefaultLog = audit.NewLog()
+
+	// Make some changes as two principals, and one as another tenant
+	var create = func(principal, tenant, requestID, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(body))
+		r = WithPrincipal(r, Principal{Kind: PrincipalAPIKey, ID: principal})
+		r = WithTenant(WithRequestID(r, requestID), tenant)
+		w := httptest.NewRecorder()
+		HandleCreatePet(w, r)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	create("ops", pet.DefaultTenant, "req-1", `{"id": 1, "name": "Rex"}`)
+	create("ops", pet.DefaultTenant, "req-2", `{"id": 1, "name": "Rex", "tag": "dog"}`)
+	create("partner", pet.DefaultTenant, "req-3", `{"id": 2, "name": "Tom"}`)
+	create("ops", "acme", "req-4", `{"id": 1, "name": "Max"}`)
+
+	tests := []struct {
+		name         string
+		query        string
+		params       map[string]interface{}
+		expectedCode int
+		expectedSeqs []int64
+		expectedNext string
+	}{
+		{
+			name:         "changes to the pets of the tenant should be listed",
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{1, 2, 3},
+		},
+		{
+			name:         "changes should be filtered by pet",
+			query:        "?pet_id=1",
+			params:       map[string]interface{}{"pet_id": int64(1)},
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{1, 2},
+		},
+		{
+			name:         "changes should be filtered by actor",
+			query:        "?actor=api_key:partner",
+			params:       map[string]interface{}{"actor": "api_key:partner"},
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{3},
+		},
+		{
+			name:         "changes should be filtered by time",
+			query:        "?since=2999-01-01T00:00:00Z",
+			params:       map[string]interface{}{"since": "2999-01-01T00:00:00Z"},
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{},
+		},
+		{
+			name:         "full pages should link to the next one",
+			query:        "?pet_id=1&limit=1",
+			params:       map[string]interface{}{"pet_id": int64(1), "limit": int64(1)},
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{1},
+			expectedNext: "/v1/audit?after=1&limit=1&pet_id=1",
+		},
+		{
+			name:         "next pages should carry on after the last change",
+			query:        "?after=1&limit=1&pet_id=1",
+			params:       map[string]interface{}{"after": int64(1), "limit": int64(1), "pet_id": int64(1)},
+			expectedCode: http.StatusOK,
+			expectedSeqs: []int64{2},
+			expectedNext: "/v1/audit?after=2&limit=1&pet_id=1",
+		},
+		{
+			name:         "invalid times should be refused",
+			query:        "?since=yesterday",
+			params:       map[string]interface{}{"since": "yesterday"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "limits that are too high should be refused",
+			query:        "?limit=5000",
+			params:       map[string]interface{}{"limit": int64(5000)},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "params the route doesn't declare as integers should be a server error",
+			query:        "?pet_id=1",
+			params:       map[string]interface{}{"pet_id": "1"},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := WithParams(httptest.NewRequest(http.MethodGet, "/v1/audit"+tt.query, nil), tt.params)
+			w := httptest.NewRecorder()
+			HandleListAudit(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var entries []audit.Entry
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+			var seqs = []int64{}
+			for _, e := range entries {
+				seqs = append(seqs, e.Seq)
+			}
+			assert.Equal(t, tt.expectedSeqs, seqs)
+			assert.Equal(t, tt.expectedNext, w.Header().Get("x-next"))
+		})
+	}
+
+	// Entries say who made each change, in which request, and what it was
+	entries := audit.DefaultLog.Query(audit.Filter{PetID: 1})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "api_key:ops", entries[1].Actor)
+	assert.Equal(t, "req-2", entries[1].RequestID)
+	assert.Equal(t, string(pet.EventPetUpdated), entries[1].Action)
+	assert.Equal(t, `{"id":1,"name":"Rex"}`, string(entries[1].Old))
+	assert.Equal(t, `{"id":1,"name":"Rex","tag":"dog"}`, string(entries[1].New))
+	assert.NoError(t, audit.DefaultLog.Verify())
+}
+
+func TestGetActor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/pets", nil)
+	assert.Equal(t, audit.Actor{Principal: AnonymousActor}, getActor(r))
+
+	r = WithRequestID(r, "req-1")
+	r = WithClientIdentity(r, ClientIdentity{Subject: "billing"})
+	assert.Equal(t, audit.Actor{Principal: "client:billing", RequestID: "req-1"}, getActor(r))
+
+	r = WithPrincipal(r, Principal{Kind: PrincipalJWT, ID: "alice"})
+	assert.Equal(t, audit.Actor{Principal: "jwt:alice", RequestID: "req-1"}, getActor(r))
+}
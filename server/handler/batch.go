@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"../../service/audit"
+	"../../service/pet"
+	"../../service/validation"
+)
+
+// Operations supported in a batch request
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpDelete = "delete"
+)
+
+// MaxBatchOperations is the max number of operations allowed in a batch request
+var MaxBatchOperations = 1000
+
+var errBatchNotApplied = fmt.Errorf("not applied: another operation in the atomic batch failed")
+
+// transactAs runs the transactions of batches, it is swapped out in tests
+var transactAs = pet.TransactAs
+
+// BatchRequest is the HTTP request body for a batch of pet operations
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations" schema:"minItems=1"`
+}
+
+// BatchOperation is a single create, update or delete in a batch request.
+// Create and update take a Pet, delete takes an ID.
+type BatchOperation struct {
+	Op  string   `json:"op" schema:"enum=create|update|delete"`
+	ID  int64    `json:"id,omitempty"`
+	Pet *pet.Pet `json:"pet,omitempty"`
+}
+
+// BatchResponse is the HTTP response body for a batch request. It has one
+// result per operation, in the same order as the request.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of a single operation in a batch request
+type BatchResult struct {
+	Index  int      `json:"index"`
+	Status int      `json:"status"`
+	Error  *Error   `json:"error,omitempty"`
+	Pet    *pet.Pet `json:"pet,omitempty"`
+}
+
+// HandleBatchPets applies a batch of create, update and delete operations.
+// With atomic=true the whole batch is applied in one transaction, or not at all.
+func HandleBatchPets(w http.ResponseWriter, r *http.Request) {
+
+	atomic, err := getQueryParamBool(r, "atomic", false)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	// Read the JSON body into the batch
+	var req BatchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.Operations) == 0 {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("batch must have at least one operation"), false)
+		return
+	}
+	if len(req.Operations) > MaxBatchOperations {
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("max operations allowed in a batch is %d", MaxBatchOperations), false)
+		return
+	}
+
+	var resp = BatchResponse{Results: make([]BatchResult, len(req.Operations))}
+	var failed bool
+	var locale = getLocale(r)
+
+	// Validate each of the operations before touching the store
+	for i, op := range req.Operations {
+		resp.Results[i] = BatchResult{Index: i}
+		if err := op.validate(); err != nil {
+			resp.Results[i].setError(locale, http.StatusBadRequest, err)
+			failed = true
+		}
+	}
+
+	if atomic {
+		err := applyBatchAtomic(GetTenant(r), getActor(r), locale, req.Operations, &resp, failed)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, err, true)
+			return
+		}
+	} else {
+		applyBatch(GetTenant(r), getActor(r), locale, req.Operations, &resp)
+	}
+
+	// An atomic batch that failed takes the status of the first failed operation
+	code := http.StatusOK
+	if atomic {
+		for _, res := range resp.Results {
+			if res.Error != nil && res.Error.Status != http.StatusFailedDependency {
+				code = res.Status
+				break
+			}
+		}
+	}
+
+	writeResponse(w, code, resp)
+}
+
+// applyBatch applies each operation that passed validation in its own
+// transaction, on the pets of tenant as actor
+func applyBatch(tenant string, actor audit.Actor, locale string, ops []BatchOperation, resp *BatchResponse) {
+	for i, op := range ops {
+		if resp.Results[i].Error != nil {
+			continue
+		}
+		err := transactAs(tenant, actor, func(tx *pet.Tx) error {
+			return op.apply(tx)
+		})
+		resp.Results[i].setOutcome(locale, op, err)
+	}
+}
+
+// applyBatchAtomic applies all the operations in one transaction on the pets
+// of tenant as actor. If any of them fail, nothing is applied and the others are marked as not applied.
+// If they all succeed but the transaction can't be committed, the error is
+// returned, as none of the operations are to blame.
+func applyBatchAtomic(tenant string, actor audit.Actor, locale string, ops []BatchOperation, resp *BatchResponse, failed bool) error {
+	if !failed {
+		var opErr error
+		err := transactAs(tenant, actor, func(tx *pet.Tx) error {
+			for i, op := range ops {
+				err := op.apply(tx)
+				resp.Results[i].setOutcome(locale, op, err)
+				if err != nil && opErr == nil {
+					opErr = err
+				}
+			}
+			return opErr
+		})
+		if err != nil && opErr == nil {
+			return fmt.Errorf("could not commit the atomic batch: %w", err)
+		}
+		failed = err != nil
+	}
+	if !failed {
+		return nil
+	}
+
+	for i := range resp.Results {
+		if resp.Results[i].Error == nil {
+			resp.Results[i].Pet = nil
+			resp.Results[i].setError(locale, http.StatusFailedDependency, errBatchNotApplied)
+		}
+	}
+	return nil
+}
+
+func (op BatchOperation) validate() error {
+	var v validation.Validator
+	switch op.Op {
+	case BatchOpCreate, BatchOpUpdate:
+		v.Check(op.Pet != nil, "pet", validation.CodeRequired, fmt.Errorf("pet is required for %s operations", op.Op))
+		if op.Pet != nil {
+			v.Nested("pet", op.Pet.Validate())
+		}
+	case BatchOpDelete:
+		v.Check(op.ID >= 1, "id", validation.CodeMinimum, pet.ErrInvalidID)
+	default:
+		v.Check(false, "op", validation.CodeEnum, fmt.Errorf("invalid op '%s': should be one of %s, %s or %s", op.Op, BatchOpCreate, BatchOpUpdate, BatchOpDelete))
+	}
+	return v.Err()
+}
+
+func (op BatchOperation) apply(tx *pet.Tx) error {
+	switch op.Op {
+	case BatchOpCreate:
+		return tx.AddPet(*op.Pet)
+	case BatchOpUpdate:
+		return tx.UpdatePet(*op.Pet)
+	case BatchOpDelete:
+		return tx.DeletePet(op.ID)
+	}
+	return fmt.Errorf("invalid op '%s'", op.Op)
+}
+
+func (res *BatchResult) setOutcome(locale string, op BatchOperation, err error) {
+	if err == pet.ErrNotExist {
+		res.setError(locale, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		res.setError(locale, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch op.Op {
+	case BatchOpCreate:
+		res.Status = http.StatusCreated
+		res.Pet = op.Pet
+	case BatchOpUpdate:
+		res.Status = http.StatusOK
+		res.Pet = op.Pet
+	case BatchOpDelete:
+		res.Status = http.StatusNoContent
+	}
+}
+
+func (res *BatchResult) setError(locale string, code int, err error) {
+	e := newLocalizedError(locale, code, err, code >= http.StatusInternalServerError)
+	res.Status = code
+	res.Error = &e
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/audit"
+	"../../service/pet"
+)
+
+func TestHandleBatchPets(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	tests := []struct {
+		name         string
+		params       map[string]interface{}
+		content      string
+		expectedCode int
+		expectedBody string
+		expectedPets []pet.Pet
+	}{
+		{
+			name:         "an empty batch should return 400",
+			content:      `{"operations": []}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"type":"urn:petsapi:problem:INVALID_REQUEST","title":"Invalid request","status":400,"detail":"batch must have at least one operation","instance":"/v1/pets:batch","code":"INVALID_REQUEST"}`,
+			expectedPets: []pet.Pet{},
+		},
+		{
+			name:         "an atomic param the route doesn't declare as a bool should return 500",
+			params:       map[string]interface{}{"atomic": "maybe"},
+			content:      `{"operations": [{"op": "delete", "id": 1}]}`,
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{"type":"urn:petsapi:problem:INTERNAL_ERROR","title":"Internal error","status":500,"detail":"There was an issues processing the request. Please see the logs.","instance":"/v1/pets:batch","code":"INTERNAL_ERROR"}`,
+			expectedPets: []pet.Pet{},
+		},
+		{
+			name: "a non-atomic batch should apply the valid operations",
+			content: `{"operations": [
+				{"op": "create", "pet": {"id": 1, "name": "Tommy"}},
+				{"op": "create", "pet": {"id": 2}},
+				{"op": "update", "pet": {"id": 3, "name": "Buddy"}},
+				{"op": "shred", "id": 1},
+				{"op": "create", "pet": {"id": 4, "name": "Kitty"}}
+			]}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"results":[` +
+				`{"index":0,"status":201,"pet":{"id":1,"name":"Tommy"}},` +
+				`{"index":1,"status":400,"error":{"type":"urn:petsapi:problem:INVALID_NAME","title":"Invalid name","status":400,"detail":"invalid name: cannot be empty","code":"INVALID_NAME",` +
+				`"errors":[{"field":"pet.name","code":"required","message":"invalid name: cannot be empty"}]}},` +
+				`{"index":2,"status":404,"error":{"type":"urn:petsapi:problem:PET_NOT_FOUND","title":"Pet not found","status":404,"detail":"entity does not exist","code":"PET_NOT_FOUND"}},` +
+				`{"index":3,"status":400,"error":{"type":"urn:petsapi:problem:INVALID_REQUEST","title":"Invalid request","status":400,"detail":"invalid op 'shred': should be one of create, update or delete","code":"INVALID_REQUEST",` +
+				`"errors":[{"field":"op","code":"enum","message":"invalid op 'shred': should be one of create, update or delete"}]}},` +
+				`{"index":4,"status":201,"pet":{"id":4,"name":"Kitty"}}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 4, Name: "Kitty"}},
+		},
+		{
+			name:   "an atomic batch with a failing operation should apply nothing",
+			params: map[string]interface{}{"atomic": true},
+			content: `{"operations": [
+				{"op": "update", "pet": {"id": 1, "name": "Tiger"}},
+				{"op": "delete", "id": 2}
+			]}`,
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"results":[` +
+				`{"index":0,"status":424,"error":{"type":"urn:petsapi:problem:BATCH_NOT_APPLIED","title":"Batch operation not applied","status":424,"detail":"not applied: another operation in the atomic batch failed","code":"BATCH_NOT_APPLIED"}},` +
+				`{"index":1,"status":404,"error":{"type":"urn:petsapi:problem:PET_NOT_FOUND","title":"Pet not found","status":404,"detail":"entity does not exist","code":"PET_NOT_FOUND"}}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 4, Name: "Kitty"}},
+		},
+		{
+			name:   "an atomic batch with an invalid operation should apply nothing",
+			params: map[string]interface{}{"atomic": true},
+			content: `{"operations": [
+				{"op": "delete", "id": 1},
+				{"op": "update"}
+			]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"results":[` +
+				`{"index":0,"status":424,"error":{"type":"urn:petsapi:problem:BATCH_NOT_APPLIED","title":"Batch operation not applied","status":424,"detail":"not applied: another operation in the atomic batch failed","code":"BATCH_NOT_APPLIED"}},` +
+				`{"index":1,"status":400,"error":{"type":"urn:petsapi:problem:INVALID_REQUEST","title":"Invalid request","status":400,"detail":"pet is required for update operations","code":"INVALID_REQUEST",` +
+				`"errors":[{"field":"pet","code":"required","message":"pet is required for update operations"}]}}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 4, Name: "Kitty"}},
+		},
+		{
+			name:   "a valid atomic batch should apply everything",
+			params: map[string]interface{}{"atomic": true},
+			content: `{"operations": [
+				{"op": "update", "pet": {"id": 1, "name": "Tiger"}},
+				{"op": "delete", "id": 4},
+				{"op": "create", "pet": {"id": 4, "name": "Coco"}}
+			]}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"results":[` +
+				`{"index":0,"status":200,"pet":{"id":1,"name":"Tiger"}},` +
+				`{"index":1,"status":204},` +
+				`{"index":2,"status":201,"pet":{"id":4,"name":"Coco"}}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tiger"}, {ID: 4, Name: "Coco"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Create the fake HTTP request
+			var buff = bytes.NewBufferString(tt.content)
+			var r = WithParams(httptest.NewRequest(http.MethodPost, "/v1/pets:batch", buff), tt.params)
+			var w = httptest.NewRecorder()
+
+			// Call the handler
+			HandleBatchPets(w, r)
+
+			// Verify the status code
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			// Verify the response
+			resp := w.Result()
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			assert.Equal(t, tt.expectedBody, string(body))
+
+			// Verify the store
+			pets, err := pet.ListPets(pet.DefaultTenant)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedPets, pets)
+		})
+	}
+}
+
+func TestHandleBatchPets_MaxOperations(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	var ops = make([]string, MaxBatchOperations+1)
+	for i := range ops {
+		ops[i] = `{"op": "delete", "id": 1}`
+	}
+	content := `{"operations": [` + strings.Join(ops, ",") + `]}`
+
+	var r = httptest.NewRequest(http.MethodPost, "/v1/pets:batch", bytes.NewBufferString(content))
+	var w = httptest.NewRecorder()
+	HandleBatchPets(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"type":"urn:petsapi:problem:INVALID_REQUEST","title":"Invalid request","status":400,"detail":"max operations allowed in a batch is 1000","instance":"/v1/pets:batch","code":"INVALID_REQUEST"}`, w.Body.String())
+}
+
+func TestHandleBatchPets_CommitFails(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(fn func(string, audit.Actor, func(*pet.Tx) error) error) {
+		clog.LogLevel = 0
+		transactAs = fn
+		pet.ResetData()
+	}(transactAs)
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy"})
+
+	// The operations all succeed, but the transaction can't be committed,
+	// e.g. because the audit log can't be written to
+	transactAs = func(tenant string, actor audit.Actor, fn func(*pet.Tx) error) error {
+		return pet.TransactAs(tenant, actor, func(tx *pet.Tx) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return errors.New("could not write to the audit log")
+		})
+	}
+
+	var buff = bytes.NewBufferString(`{"operations": [{"op": "update", "pet": {"id": 1, "name": "Tiger"}}]}`)
+	var r = WithParams(httptest.NewRequest(http.MethodPost, "/v1/pets:batch", buff), map[string]interface{}{"atomic": true})
+	var w = httptest.NewRecorder()
+	HandleBatchPets(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, `{"type":"urn:petsapi:problem:INTERNAL_ERROR","title":"Internal error","status":500,"detail":"There was an issues processing the request. Please see the logs.","instance":"/v1/pets:batch","code":"INTERNAL_ERROR"}`, w.Body.String())
+	pets, err := pet.ListPets(pet.DefaultTenant)
+	assert.NoError(t, err)
+	assert.Equal(t, []pet.Pet{{ID: 1, Name: "Tommy"}}, pets)
+}
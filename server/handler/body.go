@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// MaxJSONDepth is how deeply the objects and arrays of a JSON request body
+// can be nested
+var MaxJSONDepth = 32
+
+// StrictJSON rejects JSON request bodies with fields that the API doesn't
+// know, rather than ignoring them
+var StrictJSON = false
+
+var (
+	// ErrBodyTooLarge is returned for request bodies over the limit of
+	// their route
+	ErrBodyTooLarge = errors.New("request body is too large")
+	// ErrJSONTooDeep is returned for JSON request bodies nested deeper than
+	// MaxJSONDepth
+	ErrJSONTooDeep = errors.New("request body is nested too deeply")
+	// ErrUnknownField is returned, with StrictJSON, for JSON request bodies
+	// with a field the API doesn't know
+	ErrUnknownField = errors.New("unknown field")
+	// ErrTrailingData is returned, with StrictJSON, for JSON request bodies
+	// with more data after the JSON value
+	ErrTrailingData = errors.New("request body has data after the JSON value")
+)
+
+// ReadBody reads the body of r. If the body is over the limit set on it with
+// http.MaxBytesReader, the error wraps ErrBodyTooLarge.
+func ReadBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	return body, nil
+}
+
+// bodyError returns an error wrapping ErrBodyTooLarge if err is about the
+// body of a request being over its limit, or err otherwise
+func bodyError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrBodyTooLarge, maxErr.Limit)
+	}
+	return err
+}
+
+// WriteBodyError writes the response for err, from reading the body of r:
+// Request Entity Too Large for bodies over the limit, Bad Request otherwise
+func WriteBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrBodyTooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, err, false)
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, err, false)
+}
+
+// CheckJSONDepth returns an error wrapping ErrJSONTooDeep if the objects and
+// arrays in data are nested deeper than MaxJSONDepth. It is checked before
+// data is decoded, so deep payloads cost nothing more than reading them.
+func CheckJSONDepth(data []byte) error {
+	var depth int
+	var inString, escaped bool
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+			if depth > MaxJSONDepth {
+				return fmt.Errorf("%w: the limit is %d levels", ErrJSONTooDeep, MaxJSONDepth)
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// decodeJSON decodes the JSON request body in data into v. With StrictJSON,
+// fields that v doesn't have and data after the value are errors.
+func decodeJSON(data []byte, v interface{}) error {
+	if err := CheckJSONDepth(data); err != nil {
+		return err
+	}
+	if !StrictJSON {
+		return json.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		// The decoder doesn't have a typed error for unknown fields
+		if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+			return fmt.Errorf("%w %s", ErrUnknownField, field)
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// readJSON reads the JSON body of r into v, writing the error response if it
+// can't. It returns true if v was read.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ReadBody(r)
+	if err != nil {
+		WriteBodyError(w, r, err)
+		return false
+	}
+	defer r.Body.Close()
+
+	if err := decodeJSON(body, v); err != nil {
+		writeError(w, r, http.StatusBadRequest, err, false)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/pet"
+)
+
+func TestCheckJSONDepth(t *testing.T) {
+	defer func(depth int) { MaxJSONDepth = depth }(MaxJSONDepth)
+	MaxJSONDepth = 3
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "flat values should pass", data: `{"id": 1, "tags": ["a", "b"]}`},
+		{name: "values at the limit should pass", data: `{"a": [{"b": 1}]}`},
+		{name: "values past the limit should fail", data: `{"a": [{"b": [1]}]}`, wantErr: true},
+		{name: "brackets in strings should not count", data: `{"a": "[[[[{{{{", "b": "\"[[[["}`},
+		{name: "siblings should not add up", data: `[[[1]], [[2]], [[3]]]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckJSONDepth([]byte(tt.data))
+			assert.Equal(t, tt.wantErr, errors.Is(err, ErrJSONTooDeep))
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	defer func(strict bool) { StrictJSON = strict }(StrictJSON)
+
+	tests := []struct {
+		name        string
+		strict      bool
+		data        string
+		want        pet.Pet
+		expectedErr error
+	}{
+		{
+			name: "unknown fields should be ignored by default",
+			data: `{"id": 1, "name": "Rex", "colour": "brown"}`,
+			want: pet.Pet{ID: 1, Name: "Rex"},
+		},
+		{
+			name:   "known fields should be decoded in strict mode",
+			strict: true,
+			data:   `{"id": 1, "name": "Rex"}`,
+			want:   pet.Pet{ID: 1, Name: "Rex"},
+		},
+		{
+			name:        "unknown fields should be refused in strict mode",
+			strict:      true,
+			data:        `{"id": 1, "name": "Rex", "colour": "brown"}`,
+			expectedErr: ErrUnknownField,
+		},
+		{
+			name:        "data after the value should be refused in strict mode",
+			strict:      true,
+			data:        `{"id": 1, "name": "Rex"} {"id": 2}`,
+			expectedErr: ErrTrailingData,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			StrictJSON = tt.strict
+			var p pet.Pet
+			err := decodeJSON([]byte(tt.data), &p)
+			if tt.expectedErr != nil {
+				assert.True(t, errors.Is(err, tt.expectedErr), "got %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, p)
+		})
+	}
+
+	// The field is named in the error
+	StrictJSON = true
+	var p pet.Pet
+	assert.EqualError(t, decodeJSON([]byte(`{"colour": "brown"}`), &p), `unknown field "colour"`)
+}
+
+func TestHandleCreatePet_BodyLimits(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(strict bool) {
+		clog.LogLevel = 0
+		StrictJSON = strict
+		pet.ResetData()
+	}(StrictJSON)
+	StrictJSON = true
+
+	tests := []struct {
+		name         string
+		body         string
+		limit        int64
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "bodies within the limit should be read",
+			body:         `{"id": 1, "name": "Rex"}`,
+			limit:        1024,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "bodies over the limit should be too large",
+			body:         `{"id": 1, "name": "` + strings.Repeat("x", 100) + `"}`,
+			limit:        64,
+			expectedCode: http.StatusRequestEntityTooLarge,
+			expectedErr:  CodeBodyTooLarge,
+		},
+		{
+			name:         "unknown fields should be a bad request",
+			body:         `{"id": 1, "name": "Rex", "owner": "me"}`,
+			limit:        1024,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeUnknownField,
+		},
+		{
+			name:         "deep bodies should be a bad request",
+			body:         `{"id": 1, "name": "Rex", "tags": ` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`,
+			limit:        1024,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  CodeJSONTooDeep,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+			r.Body = http.MaxBytesReader(w, r.Body, tt.limit)
+			HandleCreatePet(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedErr != "" {
+				var errE Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedErr, errE.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"../../service/apikey"
+	"../../service/idempotency"
+	"../../service/job"
+	"../../service/jwt"
+	"../../service/pet"
+	"../../service/ratelimit"
+	"../../service/rbac"
+	"../../service/validation"
+)
+
+// Error codes returned in Error.Code. Clients can rely on these, so they
+// should never be changed once released.
+const (
+	CodeInvalidRequest           = "INVALID_REQUEST"
+	CodeInvalidJSON              = "INVALID_JSON"
+	CodeUnknownField             = "UNKNOWN_FIELD"
+	CodeJSONTooDeep              = "JSON_TOO_DEEP"
+	CodeBodyTooLarge             = "BODY_TOO_LARGE"
+	CodeValidationFailed         = "VALIDATION_FAILED"
+	CodeInvalidID                = "INVALID_ID"
+	CodeInvalidName              = "INVALID_NAME"
+	CodeInvalidTag               = "INVALID_TAG"
+	CodeNotFound                 = "NOT_FOUND"
+	CodePetNotFound              = "PET_NOT_FOUND"
+	CodeJobNotFound              = "JOB_NOT_FOUND"
+	CodeMethodNotAllowed         = "METHOD_NOT_ALLOWED"
+	CodeNotAcceptable            = "NOT_ACCEPTABLE"
+	CodeUnauthorized             = "UNAUTHORIZED"
+	CodeForbidden                = "FORBIDDEN"
+	CodeAPIKeyInvalid            = "API_KEY_INVALID"
+	CodeAPIKeyExpired            = "API_KEY_EXPIRED"
+	CodeAPIKeyRevoked            = "API_KEY_REVOKED"
+	CodeAPIKeyNotFound           = "API_KEY_NOT_FOUND"
+	CodeAPIKeyStatic             = "API_KEY_STATIC"
+	CodeTokenInvalid             = "TOKEN_INVALID"
+	CodeTokenExpired             = "TOKEN_EXPIRED"
+	CodeUnknownRole              = "UNKNOWN_ROLE"
+	CodeInvalidTenant            = "INVALID_TENANT"
+	CodeTenantRequired           = "TENANT_REQUIRED"
+	CodeRateLimited              = "RATE_LIMITED"
+	CodeQuotaExceeded            = "QUOTA_EXCEEDED"
+	CodeConflict                 = "CONFLICT"
+	CodeJobFinished              = "JOB_FINISHED"
+	CodeJobNotFinished           = "JOB_NOT_FINISHED"
+	CodeJobNoResult              = "JOB_NO_RESULT"
+	CodeIdempotencyKeyReused     = "IDEMPOTENCY_KEY_REUSED"
+	CodeIdempotencyKeyInProgress = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	CodeUnsupportedMediaType     = "UNSUPPORTED_MEDIA_TYPE"
+	CodeBatchNotApplied          = "BATCH_NOT_APPLIED"
+	CodeJobQueueFull             = "JOB_QUEUE_FULL"
+	CodeShuttingDown             = "SHUTTING_DOWN"
+	CodeServiceUnavailable       = "SERVICE_UNAVAILABLE"
+	CodeInternal                 = "INTERNAL_ERROR"
+)
+
+// errorTitles is the catalog of error codes, with the title for each
+var errorTitles = map[string]string{
+	CodeInvalidRequest:           "Invalid request",
+	CodeInvalidJSON:              "Request body is not valid JSON",
+	CodeUnknownField:             "Unknown field",
+	CodeJSONTooDeep:              "Request body nested too deeply",
+	CodeBodyTooLarge:             "Request body too large",
+	CodeValidationFailed:         "Request validation failed",
+	CodeInvalidID:                "Invalid ID",
+	CodeInvalidName:              "Invalid name",
+	CodeInvalidTag:               "Invalid tag",
+	CodeNotFound:                 "Not found",
+	CodePetNotFound:              "Pet not found",
+	CodeJobNotFound:              "Job not found",
+	CodeMethodNotAllowed:         "Method not allowed",
+	CodeNotAcceptable:            "Not acceptable",
+	CodeUnauthorized:             "Authentication required",
+	CodeForbidden:                "Forbidden",
+	CodeAPIKeyInvalid:            "Invalid API key",
+	CodeAPIKeyExpired:            "API key expired",
+	CodeAPIKeyRevoked:            "API key revoked",
+	CodeAPIKeyNotFound:           "API key not found",
+	CodeAPIKeyStatic:             "API key set in the configuration",
+	CodeTokenInvalid:             "Invalid token",
+	CodeTokenExpired:             "Token expired",
+	CodeUnknownRole:              "Unknown role",
+	CodeInvalidTenant:            "Invalid tenant",
+	CodeTenantRequired:           "Tenant required",
+	CodeRateLimited:              "Too many requests",
+	CodeQuotaExceeded:            "Daily quota exceeded",
+	CodeConflict:                 "Conflict",
+	CodeJobFinished:              "Job has already finished",
+	CodeJobNotFinished:           "Job has not finished yet",
+	CodeJobNoResult:              "Job has no result",
+	CodeIdempotencyKeyReused:     "Idempotency key reused for a different request",
+	CodeIdempotencyKeyInProgress: "Request with this idempotency key is in progress",
+	CodeUnsupportedMediaType:     "Unsupported media type",
+	CodeBatchNotApplied:          "Batch operation not applied",
+	CodeJobQueueFull:             "Too many jobs queued",
+	CodeShuttingDown:             "Server is shutting down",
+	CodeServiceUnavailable:       "Service unavailable",
+	CodeInternal:                 "Internal error",
+}
+
+// errorCodes maps the typed errors of the services to their codes
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{pet.ErrNotExist, CodePetNotFound},
+	{pet.ErrInvalidID, CodeInvalidID},
+	{pet.ErrInvalidName, CodeInvalidName},
+	{errInvalidTag, CodeInvalidTag},
+	{job.ErrNotExist, CodeJobNotFound},
+	{job.ErrFinished, CodeJobFinished},
+	{job.ErrNotFinished, CodeJobNotFinished},
+	{job.ErrNoResult, CodeJobNoResult},
+	{job.ErrQueueFull, CodeJobQueueFull},
+	{job.ErrShutdown, CodeShuttingDown},
+	{idempotency.ErrFingerprintMismatch, CodeIdempotencyKeyReused},
+	{idempotency.ErrInProgress, CodeIdempotencyKeyInProgress},
+	{apikey.ErrInvalid, CodeAPIKeyInvalid},
+	{apikey.ErrExpired, CodeAPIKeyExpired},
+	{apikey.ErrRevoked, CodeAPIKeyRevoked},
+	{apikey.ErrNotExist, CodeAPIKeyNotFound},
+	{apikey.ErrStatic, CodeAPIKeyStatic},
+	{jwt.ErrExpired, CodeTokenExpired},
+	{jwt.ErrInvalid, CodeTokenInvalid},
+	{rbac.ErrUnknownRole, CodeUnknownRole},
+	{ErrInvalidTenant, CodeInvalidTenant},
+	{ErrTenantRequired, CodeTenantRequired},
+	{ratelimit.ErrRateLimited, CodeRateLimited},
+	{ratelimit.ErrQuotaExceeded, CodeQuotaExceeded},
+	{ErrBodyTooLarge, CodeBodyTooLarge},
+	{ErrJSONTooDeep, CodeJSONTooDeep},
+	{ErrUnknownField, CodeUnknownField},
+	{ErrTrailingData, CodeInvalidJSON},
+	{ErrUnauthenticated, CodeUnauthorized},
+	{ErrForbidden, CodeForbidden},
+	{errValidationFailed, CodeValidationFailed},
+	{errBatchNotApplied, CodeBatchNotApplied},
+	{errNoRoute, CodeNotFound},
+	{errMethodNotAllowed, CodeMethodNotAllowed},
+}
+
+// statusCodes has the codes used for errors that don't have one of their own
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusNotAcceptable:         CodeNotAcceptable,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeBodyTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusServiceUnavailable:    CodeServiceUnavailable,
+}
+
+// getErrorCode returns the code for err, falling back to the code for the
+// HTTP status when err isn't one of the typed errors of the services
+func getErrorCode(status int, err error) string {
+	// Internal errors are never explained to the client
+	if status == http.StatusInternalServerError {
+		return CodeInternal
+	}
+
+	// A single failed field can be told apart by its own code, but more
+	// than one can only be a failed validation
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) && len(fieldErrs) > 1 {
+		return CodeValidationFailed
+	}
+
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return CodeInvalidJSON
+	}
+
+	if code, exists := statusCodes[status]; exists {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// getTypedErrorCode returns the code of err if it is one of the typed errors
+// of the services itself, rather than wrapping one
+func getTypedErrorCode(err error) string {
+	for _, c := range errorCodes {
+		if err == c.err {
+			return c.code
+		}
+	}
+	return ""
+}
+
+func getErrorTitle(code string) string {
+	if title, exists := errorTitles[code]; exists {
+		return title
+	}
+	return code
+}
+
+var errNoRoute = errors.New("no route matches the request path")
+var errMethodNotAllowed = errors.New("method is not allowed for the request path")
+
+// HandleNotFound responds with an error to requests that match no route
+func HandleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, errNoRoute, false)
+}
+
+// HandleMethodNotAllowed responds with an error to requests whose path
+// matches a route, but not their method
+func HandleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed, false)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/job"
+	"../../service/pet"
+)
+
+func TestGetErrorCode(t *testing.T) {
+
+	var syntaxErr = json.Unmarshal([]byte(`{`), &struct{}{})
+
+	tests := []struct {
+		name     string
+		status   int
+		err      error
+		expected string
+	}{
+		{"service errors should have their own code", http.StatusNotFound, pet.ErrNotExist, CodePetNotFound},
+		{"wrapped service errors should have their own code", http.StatusBadRequest, fmt.Errorf("line 2: %w", pet.ErrInvalidName), CodeInvalidName},
+		{"job errors should have their own code", http.StatusConflict, job.ErrFinished, CodeJobFinished},
+		{"JSON errors should be invalid JSON", http.StatusBadRequest, syntaxErr, CodeInvalidJSON},
+		{"other errors should have the code for the status", http.StatusUnsupportedMediaType, fmt.Errorf("bad type"), CodeUnsupportedMediaType},
+		{"statuses without a code should be invalid requests", http.StatusTeapot, fmt.Errorf("teapot"), CodeInvalidRequest},
+		{"internal errors should never say more", http.StatusInternalServerError, pet.ErrNotExist, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, getErrorCode(tt.status, tt.err))
+		})
+	}
+}
+
+func TestErrorCatalog(t *testing.T) {
+	// Every mapped code should have a title in the catalog
+	for _, c := range errorCodes {
+		assert.NotEmpty(t, errorTitles[c.code], c.code)
+	}
+	for _, code := range statusCodes {
+		assert.NotEmpty(t, errorTitles[code], code)
+	}
+}
+
+func TestHandleNotFound(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	var r = httptest.NewRequest(http.MethodGet, "/v1/cats", nil)
+	var w = httptest.NewRecorder()
+	HandleNotFound(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, MediaTypeProblemJSON, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:petsapi:problem:NOT_FOUND",
+		"title": "Not found",
+		"status": 404,
+		"detail": "no route matches the request path",
+		"instance": "/v1/cats",
+		"code": "NOT_FOUND"
+	}`, w.Body.String())
+}
@@ -2,25 +2,62 @@ package handler
 
 import (
 	"fmt"
+	"net/http"
+
+	"../../service/validation"
 )
 
-// Error is the HTTP response error object
+// Error is the HTTP response error object. It is a problem details object as
+// described by RFC 9457, and is served as application/problem+json.
 type Error struct {
-	Code    int32  `json:"code"`
-	Message string `json:"message"`
+	// Type identifies the kind of problem, and is built from Code
+	Type string `json:"type"`
+	// Title is a short summary of the kind of problem, the same for every Code
+	Title string `json:"title"`
+	// Status is the HTTP status code
+	Status int `json:"status"`
+	// Detail explains this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that had the problem
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable, machine readable code for the kind of problem
+	Code string `json:"code"`
+	// Errors has the details of each field that failed validation, if any
+	Errors []validation.FieldError `json:"errors,omitempty"`
+
+	// locale is the language of the messages
+	locale string
 }
 
+// MediaTypeProblemJSON is the media type of error responses
+var MediaTypeProblemJSON = "application/problem+json"
+
+// ProblemTypeBase is prefixed to an error code to make the problem type URI
+var ProblemTypeBase = "urn:petsapi:problem:"
+
 var apiErrMessageClean = "There was an issues processing the request. Please see the logs."
 
 // Error method makes handler.Error implement golang's error interface
 func (e Error) Error() string {
-	return fmt.Sprintf("Error Code %d: %s", e.Code, e.Message)
+	return fmt.Sprintf("Error %s (%d): %s", e.Code, e.Status, e.Detail)
 }
 
 // NewError returns a new error instance
-func NewError(code int, message string) Error {
+func NewError(status int, code string, detail string) Error {
 	return Error{
-		Code:    int32(code),
-		Message: message,
+		Type:   ProblemTypeBase + code,
+		Title:  getErrorTitle(code),
+		Status: status,
+		Detail: detail,
+		Code:   code,
 	}
 }
+
+var errValidationFailed = fmt.Errorf("request validation failed")
+
+// WriteValidationError writes a Bad Request error with the field errors
+func WriteValidationError(w http.ResponseWriter, r *http.Request, errs []validation.FieldError) {
+	errE := newError(r, http.StatusBadRequest, errValidationFailed, false)
+	errE.Errors = localizeFieldErrors(errE.locale, errs)
+	writeErrorResponse(w, errE)
+}
@@ -8,8 +8,9 @@ import (
 
 func TestNewError(t *testing.T) {
 	type args struct {
-		code    int
-		message string
+		status int
+		code   string
+		detail string
 	}
 	tests := []struct {
 		name string
@@ -18,17 +19,31 @@ func TestNewError(t *testing.T) {
 	}{
 		{
 			"standard case",
-			args{1, "something went wrong"},
+			args{404, CodePetNotFound, "something went wrong"},
 			Error{
-				Code:    int32(1),
-				Message: "something went wrong",
+				Type:   "urn:petsapi:problem:PET_NOT_FOUND",
+				Title:  "Pet not found",
+				Status: 404,
+				Detail: "something went wrong",
+				Code:   CodePetNotFound,
+			},
+		},
+		{
+			"code not in the catalog should be its own title",
+			args{400, "SOMETHING_ELSE", "something went wrong"},
+			Error{
+				Type:   "urn:petsapi:problem:SOMETHING_ELSE",
+				Title:  "SOMETHING_ELSE",
+				Status: 400,
+				Detail: "something went wrong",
+				Code:   "SOMETHING_ELSE",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got := NewError(tt.args.code, tt.args.message)
+			got := NewError(tt.args.status, tt.args.code, tt.args.detail)
 			assert.Equal(t, tt.want, got)
 		})
 	}
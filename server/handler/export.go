@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/teejays/clog"
+
+	"../../service/job"
+	"../../service/pet"
+)
+
+// exportFlushEvery is the number of rows written between flushes of an export
+var exportFlushEvery = 500
+
+// HandleExportPets streams all the pets as NDJSON or CSV. The format is picked
+// with the format query param, and defaults to NDJSON.
+func HandleExportPets(w http.ResponseWriter, r *http.Request) {
+
+	format, err := getExportFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	// ListPets gives us a copy of the pets taken under the read lock, so the
+	// export is a consistent snapshot even if pets change while it streams
+	pets, err := pet.ListPets(GetTenant(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	w.Header().Set("Content-Type", getExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pets.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	// The headers are out, so all we can do with an error now is log it
+	err = ExportPets(r.Context(), w, pets, format, nil)
+	if err != nil {
+		clog.Errorf("Failed to write the pets export: %v", err)
+	}
+}
+
+// HandleExportPetsJob starts a job that exports all the pets as NDJSON or CSV.
+// The export can be downloaded from the job's result link once it's done.
+func HandleExportPetsJob(w http.ResponseWriter, r *http.Request) {
+
+	format, err := getExportFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	tenant := GetTenant(r)
+	submitJob(w, r, JobTypeExport, func(ctx context.Context, t *job.Tracker) (*job.Result, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pets, err := pet.ListPets(tenant)
+		if err != nil {
+			return nil, err
+		}
+		var buff bytes.Buffer
+		err = ExportPets(ctx, &buff, pets, format, t)
+		if err != nil {
+			return nil, err
+		}
+		return &job.Result{ContentType: getExportContentType(format), Data: buff.Bytes()}, nil
+	})
+}
+
+// getExportFormat returns the format query param, which the route validates
+// against the export formats, defaulting to NDJSON
+func getExportFormat(r *http.Request) (string, error) {
+	return getQueryParamString(r, "format", FormatNDJSON)
+}
+
+func getExportContentType(format string) string {
+	if format == FormatCSV {
+		return contentTypeCSV + "; charset=UTF-8"
+	}
+	return contentTypeNDJSON + "; charset=UTF-8"
+}
+
+// ExportPets writes pets to w in the given format. If w is a http.Flusher it
+// is flushed every so often. It stops early if ctx is done. Progress is
+// reported to t, which may be nil.
+func ExportPets(ctx context.Context, w io.Writer, pets []pet.Pet, format string, t *job.Tracker) error {
+	var flush = func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+	t.SetTotal(len(pets))
+
+	switch format {
+	case FormatNDJSON:
+		return writeNDJSON(ctx, w, pets, flush, t)
+	case FormatCSV:
+		return writeCSV(ctx, w, pets, flush, t)
+	}
+	return fmt.Errorf("unsupported format '%s'", format)
+}
+
+func writeNDJSON(ctx context.Context, w io.Writer, pets []pet.Pet, flush func(), t *job.Tracker) error {
+	// json.Encoder terminates each value with a newline
+	enc := json.NewEncoder(w)
+	for i, p := range pets {
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			flush()
+			t.Advance(exportFlushEvery)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	flush()
+	t.Advance(len(pets) % exportFlushEvery)
+	return nil
+}
+
+func writeCSV(ctx context.Context, w io.Writer, pets []pet.Pet, flush func(), t *job.Tracker) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for i, p := range pets {
+		err := cw.Write([]string{strconv.FormatInt(p.ID, 10), p.Name, p.Tag})
+		if err != nil {
+			return err
+		}
+		if (i+1)%exportFlushEvery == 0 {
+			cw.Flush()
+			flush()
+			t.Advance(exportFlushEvery)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	flush()
+	t.Advance(len(pets) % exportFlushEvery)
+	return cw.Error()
+}
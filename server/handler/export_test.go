@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/audit"
+	"../../service/pet"
+)
+
+func TestHandleExportPets(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	pet.PopulateMockPets()
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 21, Name: "Rex, Jr.", Tag: "dog"})
+
+	tests := []struct {
+		name                string
+		params              map[string]interface{}
+		expectedCode        int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "no format should export ndjson",
+			expectedCode:        http.StatusOK,
+			expectedContentType: "application/x-ndjson; charset=UTF-8",
+			expectedBody: `{"id":1,"name":"Tommy"}
+{"id":2,"name":"Tiger"}
+{"id":3,"name":"Buddy"}
+{"id":5,"name":"Kitty"}
+{"id":8,"name":"Coco"}
+{"id":13,"name":"Pebbles"}
+{"id":21,"name":"Rex, Jr.","tag":"dog"}
+`,
+		},
+		{
+			name:                "csv format should export csv",
+			params:              map[string]interface{}{"format": FormatCSV},
+			expectedCode:        http.StatusOK,
+			expectedContentType: "text/csv; charset=UTF-8",
+			expectedBody: `id,name,tag
+1,Tommy,
+2,Tiger,
+3,Buddy,
+5,Kitty,
+8,Coco,
+13,Pebbles,
+21,"Rex, Jr.",dog
+`,
+		},
+		{
+			name:                "a format the route doesn't declare as a string should return 500",
+			params:              map[string]interface{}{"format": int64(1)},
+			expectedCode:        http.StatusInternalServerError,
+			expectedContentType: MediaTypeProblemJSON,
+			expectedBody:        `{"type":"urn:petsapi:problem:INTERNAL_ERROR","title":"Internal error","status":500,"detail":"There was an issues processing the request. Please see the logs.","instance":"/v1/pets:export","code":"INTERNAL_ERROR"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var r = WithParams(httptest.NewRequest(http.MethodGet, "/v1/pets:export", nil), tt.params)
+			var w = httptest.NewRecorder()
+
+			// Call the handler
+			HandleExportPets(w, r)
+
+			// Verify the response
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedContentType, w.Header().Get("Content-Type"))
+			body, err := ioutil.ReadAll(w.Result().Body)
+			if err != nil {
+				t.Error(err)
+			}
+			assert.Equal(t, tt.expectedBody, string(body))
+		})
+	}
+}
+
+func TestExportPets_RoundTrip(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	// Enough pets to need a few flushes
+	var pets []pet.Pet
+	for i := 1; i <= exportFlushEvery*2+1; i++ {
+		pets = append(pets, pet.Pet{ID: int64(i), Name: fmt.Sprintf("Pet %d", i), Tag: "tag, with comma"})
+	}
+
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			defer pet.ResetData()
+
+			var out flushCounter
+			err := ExportPets(context.Background(), &out, pets, format, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, out.flushes)
+
+			result := ImportPets(context.Background(), pet.DefaultTenant, audit.System, strings.NewReader(out.String()), format, nil)
+			assert.Equal(t, len(pets), result.Imported)
+			assert.Nil(t, result.Aborted)
+
+			got, err := pet.ListPets(pet.DefaultTenant)
+			assert.Nil(t, err)
+			assert.Equal(t, pets, got)
+		})
+	}
+}
+
+// flushCounter is a http.Flusher that counts how many times it was flushed
+type flushCounter struct {
+	strings.Builder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+}
@@ -2,14 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"../../service/pet"
-	"github.com/gorilla/mux"
+	"../../service/validation"
 	"github.com/teejays/clog"
 )
 
@@ -20,26 +18,26 @@ func HandleListPets(w http.ResponseWriter, r *http.Request) {
 	defaultLimit := 100
 	limit, err := getQueryParamInt(r, "limit", defaultLimit)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 	clog.Debugf("limit = %d", limit)
 	if limit > defaultLimit {
-		writeError(w, http.StatusBadRequest, fmt.Errorf("max limit allowed is %d", defaultLimit), false)
+		writeError(w, r, http.StatusBadRequest, fmt.Errorf("max limit allowed is %d", defaultLimit), false)
 		return
 	}
 
 	defaultPage := 1
 	page, err := getQueryParamInt(r, "page", defaultPage)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 
 	// Get the pets
-	pets, err := pet.ListPets()
+	pets, err := pet.ListPets(GetTenant(r))
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err, true)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 
@@ -47,7 +45,7 @@ func HandleListPets(w http.ResponseWriter, r *http.Request) {
 	var nextPage int
 	pets, nextPage, err = pet.Paginate(pets, limit, page)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+		writeError(w, r, http.StatusBadRequest, err, false)
 		return
 	}
 
@@ -65,33 +63,25 @@ func HandleListPets(w http.ResponseWriter, r *http.Request) {
 // HandleCreatePet creates a new pet and stores it
 func HandleCreatePet(w http.ResponseWriter, r *http.Request) {
 
-	// Read the HTTP request body
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
-		return
-	}
-	defer r.Body.Close()
-
-	// Unmarshal JSON into Go type
+	// Read the JSON body into a pet
 	var p pet.Pet
-	err = json.Unmarshal(body, &p)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+	if !readJSON(w, r, &p) {
 		return
 	}
 
 	// Validate that it is good to save
-	err = p.Validate()
+	err := p.Validate()
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+		writeError(w, r, http.StatusBadRequest, err, false)
 		return
 	}
 
 	// Save the new pet
-	err = pet.AddPet(p)
+	err = pet.TransactAs(GetTenant(r), getActor(r), func(tx *pet.Tx) error {
+		return tx.AddPet(p)
+	})
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err, true)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 
@@ -106,18 +96,18 @@ func HandleGetPetByID(w http.ResponseWriter, r *http.Request) {
 	// Get the Pet ID
 	id, err := getMuxParamrInt(r, "id")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err, false)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 
 	// Get the pet
-	p, err := pet.GetPetByID(id)
+	p, err := pet.GetPetByID(GetTenant(r), id)
 	if err == pet.ErrNotExist {
-		writeError(w, http.StatusNotFound, err, false)
+		writeError(w, r, http.StatusNotFound, err, false)
 		return
 	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err, true)
+		writeError(w, r, http.StatusInternalServerError, err, true)
 		return
 	}
 
@@ -125,43 +115,64 @@ func HandleGetPetByID(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, http.StatusOK, p)
 }
 
+// getQueryParamInt returns the integer param name of r, as validated against
+// the route, or defaultVal if the request doesn't have it. An error means the
+// route doesn't declare it as an integer, which is a bug on our side.
 func getQueryParamInt(r *http.Request, name string, defaultVal int) (int, error) {
-	err := r.ParseForm()
-	if err != nil {
-		return defaultVal, err
+	v, exists := getParam(r, name)
+	if !exists {
+		return defaultVal, nil
+	}
+	val, ok := v.(int64)
+	if !ok {
+		return defaultVal, paramTypeError(name, v, "an int")
 	}
-	values, exist := r.Form[name]
-	clog.Debugf("URL values for %s: %+v", name, values)
-	if !exist {
+	return int(val), nil
+}
+
+// getQueryParamBool is getQueryParamInt for boolean params
+func getQueryParamBool(r *http.Request, name string, defaultVal bool) (bool, error) {
+	v, exists := getParam(r, name)
+	if !exists {
 		return defaultVal, nil
 	}
-	if len(values) > 1 {
-		return defaultVal, fmt.Errorf("multiple URL form values found for %s", name)
+	val, ok := v.(bool)
+	if !ok {
+		return defaultVal, paramTypeError(name, v, "a bool")
 	}
+	return val, nil
+}
 
-	val, err := strconv.Atoi(values[0])
-	if err != nil {
-		return defaultVal, fmt.Errorf("error parsing %s value to an int: %v", name, err)
+// getQueryParamString is getQueryParamInt for string params
+func getQueryParamString(r *http.Request, name string, defaultVal string) (string, error) {
+	v, exists := getParam(r, name)
+	if !exists {
+		return defaultVal, nil
+	}
+	val, ok := v.(string)
+	if !ok {
+		return defaultVal, paramTypeError(name, v, "a string")
 	}
 	return val, nil
 }
 
-// getMuxParamrInt extracts the userid param out of the request route
+// getMuxParamrInt returns the integer path param name of r, as validated
+// against the route. Path params are required, so an error means the route
+// doesn't declare it, or not as an integer.
 func getMuxParamrInt(r *http.Request, name string) (int64, error) {
-
-	var vars = mux.Vars(r)
-	clog.Debugf("MUX vars are: %+v", vars)
-	valStr := vars[name]
-	if strings.TrimSpace(valStr) == "" {
-		return -1, fmt.Errorf("could not find var %s in the route", name)
+	v, exists := getParam(r, name)
+	if !exists {
+		return -1, fmt.Errorf("the route does not declare the path param %s", name)
 	}
-
-	val, err := strconv.Atoi(valStr)
-	if err != nil {
-		return -1, fmt.Errorf("could not convert var %s to an int64: %v", name, err)
+	val, ok := v.(int64)
+	if !ok {
+		return -1, paramTypeError(name, v, "an int64")
 	}
+	return val, nil
+}
 
-	return int64(val), nil
+func paramTypeError(name string, v interface{}, expected string) error {
+	return fmt.Errorf("param %s is a %T, not %s: the route does not declare it as one", name, v, expected)
 }
 
 func writeResponse(w http.ResponseWriter, code int, v interface{}) {
@@ -174,28 +185,33 @@ func writeResponse(w http.ResponseWriter, code int, v interface{}) {
 	// Json marshal the resp
 	data, err := json.Marshal(v)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err, true)
+		writeError(w, nil, http.StatusInternalServerError, err, true)
 		return
 	}
 	// Write the response
 	_, err = w.Write(data)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err, true)
+		writeError(w, nil, http.StatusInternalServerError, err, true)
 		return
 	}
 }
 
-func writeError(w http.ResponseWriter, code int, err error, hide bool) {
-	errMessage := cleanErrMessage(err.Error())
-	clog.Error(errMessage)
-
-	if hide {
-		errMessage = apiErrMessageClean
-	}
+// WriteError is the exported wrapper for writeError()
+func WriteError(w http.ResponseWriter, r *http.Request, code int, err error, hide bool) {
+	writeError(w, r, code, err, hide)
+}
 
-	errE := NewError(code, errMessage)
+func writeError(w http.ResponseWriter, r *http.Request, code int, err error, hide bool) {
+	writeErrorResponse(w, newError(r, code, err, hide))
+}
 
-	w.WriteHeader(code)
+func writeErrorResponse(w http.ResponseWriter, errE Error) {
+	w.Header().Set("Content-Type", MediaTypeProblemJSON)
+	if errE.locale != "" {
+		w.Header().Set("Content-Language", errE.locale)
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.WriteHeader(errE.Status)
 	data, err := json.Marshal(errE)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to json.Unmarshal an error for http response: %v", err))
@@ -206,6 +222,39 @@ func writeError(w http.ResponseWriter, code int, err error, hide bool) {
 	}
 }
 
+// newError logs err and converts it into an Error for the HTTP response to r,
+// which may be nil if the error isn't for a whole request
+func newError(r *http.Request, code int, err error, hide bool) Error {
+	errE := newLocalizedError(getLocale(r), code, err, hide)
+	if r != nil {
+		errE.Instance = r.URL.Path
+	}
+	return errE
+}
+
+// newLocalizedError logs err and converts it into an Error with its messages
+// in locale
+func newLocalizedError(locale string, code int, err error, hide bool) Error {
+	errMessage := err.Error()
+	clog.Error(cleanErrMessage(errMessage))
+
+	if hide {
+		errMessage = apiErrMessageClean
+	}
+
+	errE := NewError(code, getErrorCode(code, err), errMessage)
+
+	// Validation errors list each of the fields that failed
+	var fieldErrs validation.Errors
+	if !hide && errors.As(err, &fieldErrs) {
+		errE.Errors = fieldErrs
+	}
+
+	errE.locale = locale
+	localizeError(&errE, err, hide)
+	return errE
+}
+
 func cleanErrMessage(msg string) string {
 	return fmt.Sprintf("There was an error processing the request: %v", msg)
 }
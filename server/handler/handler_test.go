@@ -9,7 +9,6 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/teejays/clog"
 
@@ -30,6 +29,7 @@ func TestHandleCreatePet(t *testing.T) {
 		expectedCode       int
 		isError            bool
 		expectedErrMessage string
+		expectedErrFields  []string
 		expectedResponse   string
 	}{
 		{
@@ -58,7 +58,8 @@ func TestHandleCreatePet(t *testing.T) {
 			content:            "{}",
 			expectedCode:       http.StatusBadRequest,
 			isError:            true,
-			expectedErrMessage: "invalid id: cannot be less than 1",
+			expectedErrMessage: "invalid id: cannot be less than 1; invalid name: cannot be empty",
+			expectedErrFields:  []string{"id", "name"},
 		},
 		{
 			name:               "passing a JSON Pet object without an id should return 400",
@@ -188,8 +189,15 @@ func TestHandleCreatePet(t *testing.T) {
 				if err != nil {
 					t.Error(err)
 				}
-				assert.Equal(t, test.expectedCode, int(errH.Code))
-				assert.Equal(t, cleanErrMessage(test.expectedErrMessage), errH.Message)
+				assert.Equal(t, test.expectedCode, errH.Status)
+				assert.Equal(t, test.expectedErrMessage, errH.Detail)
+				if test.expectedErrFields != nil {
+					var fields []string
+					for _, fe := range errH.Errors {
+						fields = append(fields, fe.Field)
+					}
+					assert.Equal(t, test.expectedErrFields, fields)
+				}
 			} else {
 				assert.Equal(t, test.expectedResponse, string(body))
 			}
@@ -209,8 +217,9 @@ func TestHandleListPets(t *testing.T) {
 	}()
 
 	type request struct {
-		query string
-		body  string
+		query  string
+		params map[string]interface{}
+		body   string
 	}
 
 	type response struct {
@@ -254,7 +263,8 @@ func TestHandleListPets(t *testing.T) {
 		{
 			name: "limit of more than 100 should error",
 			input: request{
-				query: "?limit=300",
+				query:  "?limit=300",
+				params: map[string]interface{}{"limit": int64(300)},
 			},
 			preProcessFunc: nil,
 			expected: response{
@@ -266,7 +276,8 @@ func TestHandleListPets(t *testing.T) {
 		{
 			name: "limit of less then num elements, and no page, should include next page in header",
 			input: request{
-				query: "?limit=1",
+				query:  "?limit=1",
+				params: map[string]interface{}{"limit": int64(1)},
 			},
 			preProcessFunc: func(r *http.Request) {
 				pet.PopulateMockPets()
@@ -281,7 +292,8 @@ func TestHandleListPets(t *testing.T) {
 		{
 			name: "limit of less then num elements, and explicit not last page, should include next page in header",
 			input: request{
-				query: "?limit=1&page=2",
+				query:  "?limit=1&page=2",
+				params: map[string]interface{}{"limit": int64(1), "page": int64(2)},
 			},
 			preProcessFunc: func(r *http.Request) {
 				pet.PopulateMockPets()
@@ -294,45 +306,23 @@ func TestHandleListPets(t *testing.T) {
 			},
 		},
 		{
-			name: "passing a string as limit should error",
-			input: request{
-				query: "?limit=abc&page=2",
-			},
-			preProcessFunc: nil,
-			expected: response{
-				statusCode: http.StatusBadRequest,
-				isError:    true,
-				errMessage: `error parsing limit value to an int: strconv.Atoi: parsing "abc": invalid syntax`,
-			},
-		},
-		{
-			name: "passing a string as page should error",
-			input: request{
-				query: "?limit=1&page=abc",
-			},
-			preProcessFunc: nil,
-			expected: response{
-				statusCode: http.StatusBadRequest,
-				isError:    true,
-				errMessage: `error parsing page value to an int: strconv.Atoi: parsing "abc": invalid syntax`,
-			},
-		},
-		{
-			name: "passing multiple values for limit should error",
+			name: "a limit the route doesn't declare as an integer should be a server error",
 			input: request{
-				query: "?limit=1&limit=2&page=2",
+				query:  "?limit=abc",
+				params: map[string]interface{}{"limit": "abc"},
 			},
 			preProcessFunc: nil,
 			expected: response{
-				statusCode: http.StatusBadRequest,
+				statusCode: http.StatusInternalServerError,
 				isError:    true,
-				errMessage: `multiple URL form values found for limit`,
+				errMessage: apiErrMessageClean,
 			},
 		},
 		{
 			name: "page num of more than available pages should error",
 			input: request{
-				query: "?limit=50&page=3",
+				query:  "?limit=50&page=3",
+				params: map[string]interface{}{"limit": int64(50), "page": int64(3)},
 			},
 			preProcessFunc: func(r *http.Request) {
 				pet.PopulateMockPets()
@@ -351,7 +341,7 @@ func TestHandleListPets(t *testing.T) {
 			// Create the fake HTTP request
 			var buff = bytes.NewBufferString(tt.input.body)
 			path := fmt.Sprintf("%s%s", "/v1/pets", tt.input.query)
-			var r = httptest.NewRequest(http.MethodGet, path, buff)
+			var r = WithParams(httptest.NewRequest(http.MethodGet, path, buff), tt.input.params)
 			var w = httptest.NewRecorder()
 
 			if tt.preProcessFunc != nil {
@@ -379,8 +369,8 @@ func TestHandleListPets(t *testing.T) {
 				if err != nil {
 					t.Error(err)
 				}
-				assert.Equal(t, tt.expected.statusCode, int(errH.Code))
-				assert.Equal(t, cleanErrMessage(tt.expected.errMessage), errH.Message)
+				assert.Equal(t, tt.expected.statusCode, errH.Status)
+				assert.Equal(t, tt.expected.errMessage, errH.Detail)
 			} else {
 				assert.Equal(t, tt.expected.body, string(body))
 			}
@@ -406,6 +396,7 @@ func TestHandleGetByPetID(t *testing.T) {
 
 	type request struct {
 		pathAppend string
+		params     map[string]interface{}
 		body       string
 	}
 
@@ -424,19 +415,20 @@ func TestHandleGetByPetID(t *testing.T) {
 		expected       response
 	}{
 		{
-			name:           "passing empty id should error",
+			name:           "a route that doesn't declare the id should be a server error",
 			input:          request{},
 			preProcessFunc: nil,
 			expected: response{
-				statusCode: http.StatusBadRequest,
+				statusCode: http.StatusInternalServerError,
 				isError:    true,
-				errMessage: "could not find var id in the route",
+				errMessage: apiErrMessageClean,
 			},
 		},
 		{
 			name: "passing negative id should error",
 			input: request{
 				pathAppend: "-1",
+				params:     map[string]interface{}{"id": int64(-1)},
 			},
 			preProcessFunc: nil,
 			expected: response{
@@ -446,21 +438,23 @@ func TestHandleGetByPetID(t *testing.T) {
 			},
 		},
 		{
-			name: "passing a string id should error",
+			name: "an id the route doesn't declare as an integer should be a server error",
 			input: request{
 				pathAppend: "abc",
+				params:     map[string]interface{}{"id": "abc"},
 			},
 			preProcessFunc: nil,
 			expected: response{
-				statusCode: http.StatusBadRequest,
+				statusCode: http.StatusInternalServerError,
 				isError:    true,
-				errMessage: "could not convert var id to an int64: strconv.Atoi: parsing \"abc\": invalid syntax",
+				errMessage: apiErrMessageClean,
 			},
 		},
 		{
 			name: "passing a int ID but with no data in system should give NotFound error",
 			input: request{
 				pathAppend: "1",
+				params:     map[string]interface{}{"id": int64(1)},
 			},
 			preProcessFunc: nil,
 			expected: response{
@@ -473,6 +467,7 @@ func TestHandleGetByPetID(t *testing.T) {
 			name: "passing a valid ID but should return the pet",
 			input: request{
 				pathAppend: "3",
+				params:     map[string]interface{}{"id": int64(3)},
 			},
 			preProcessFunc: func(r *http.Request) {
 				pet.PopulateMockPets()
@@ -492,7 +487,7 @@ func TestHandleGetByPetID(t *testing.T) {
 			var buff = bytes.NewBufferString(tt.input.body)
 			path := fmt.Sprintf("%s%s", "/v1/pets/", tt.input.pathAppend)
 			var r = httptest.NewRequest(http.MethodGet, path, buff)
-			r = mux.SetURLVars(r, map[string]string{"id": tt.input.pathAppend})
+			r = WithParams(r, tt.input.params)
 			var w = httptest.NewRecorder()
 
 			if tt.preProcessFunc != nil {
@@ -520,8 +515,8 @@ func TestHandleGetByPetID(t *testing.T) {
 				if err != nil {
 					t.Error(err)
 				}
-				assert.Equal(t, tt.expected.statusCode, int(errH.Code))
-				assert.Equal(t, cleanErrMessage(tt.expected.errMessage), errH.Message)
+				assert.Equal(t, tt.expected.statusCode, errH.Status)
+				assert.Equal(t, tt.expected.errMessage, errH.Detail)
 			} else {
 				assert.Equal(t, tt.expected.body, string(body))
 			}
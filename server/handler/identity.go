@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+)
+
+// ClientIdentity is who a client is, from the certificate it presented over
+// mutual TLS
+type ClientIdentity struct {
+	// Subject is the common name of the certificate
+	Subject string
+	// Organizations are the organizations of the certificate subject
+	Organizations []string
+	// DNSNames, EmailAddresses and URIs are the subject alternative names
+	// of the certificate, e.g. a SPIFFE ID in URIs
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	// Fingerprint is the hex encoded SHA-256 of the certificate
+	Fingerprint string
+}
+
+// NewClientIdentity returns the identity in a verified client certificate
+func NewClientIdentity(cert *x509.Certificate) ClientIdentity {
+	sum := sha256.Sum256(cert.Raw)
+	id := ClientIdentity{
+		Subject:        cert.Subject.CommonName,
+		Organizations:  cert.Subject.Organization,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Fingerprint:    hex.EncodeToString(sum[:]),
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
+
+// clientIdentityKey is the context key for the client identity of a request
+type clientIdentityKey struct{}
+
+// WithClientIdentity returns a copy of r that carries the identity of its
+// client
+func WithClientIdentity(r *http.Request, id ClientIdentity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+}
+
+// GetClientIdentity returns the identity of the client of r, if it presented
+// a verified certificate
+func GetClientIdentity(r *http.Request) (ClientIdentity, bool) {
+	id, ok := r.Context().Value(clientIdentityKey{}).(ClientIdentity)
+	return id, ok
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIdentity(t *testing.T) {
+
+	spiffe, _ := url.Parse("spiffe://pets.example/inventory")
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		Subject:        pkix.Name{CommonName: "inventory-service", Organization: []string{"Pet Shop"}},
+		DNSNames:       []string{"inventory.pets.example"},
+		EmailAddresses: []string{"ops@pets.example"},
+		URIs:           []*url.URL{spiffe},
+	}
+	sum := sha256.Sum256(cert.Raw)
+
+	var expected = ClientIdentity{
+		Subject:        "inventory-service",
+		Organizations:  []string{"Pet Shop"},
+		DNSNames:       []string{"inventory.pets.example"},
+		EmailAddresses: []string{"ops@pets.example"},
+		URIs:           []string{"spiffe://pets.example/inventory"},
+		Fingerprint:    hex.EncodeToString(sum[:]),
+	}
+	assert.Equal(t, expected, NewClientIdentity(cert))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/pets", nil)
+	_, ok := GetClientIdentity(r)
+	assert.False(t, ok)
+
+	id, ok := GetClientIdentity(WithClientIdentity(r, expected))
+	assert.True(t, ok)
+	assert.Equal(t, expected, id)
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"../../service/audit"
+	"../../service/job"
+	"../../service/pet"
+)
+
+// Formats supported for bulk import and export
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var contentTypeNDJSON = "application/x-ndjson"
+var contentTypeCSV = "text/csv"
+
+// formatsByMediaType maps the accepted media types to the import/export format
+var formatsByMediaType = map[string]string{
+	"application/x-ndjson": FormatNDJSON,
+	"application/ndjson":   FormatNDJSON,
+	"text/csv":             FormatCSV,
+}
+
+// csvHeader is the header row used for CSV export
+var csvHeader = []string{"id", "name", "tag"}
+
+// csvRequiredColumns are the columns a CSV import must have
+var csvRequiredColumns = []string{"id", "name"}
+
+// MaxImportLineBytes is the max size of a single NDJSON line in an import
+var MaxImportLineBytes = 1 << 20
+
+// MaxImportErrors is the max number of row errors reported back for an import
+var MaxImportErrors = 1000
+
+// ImportResult is the HTTP response body for a bulk import
+type ImportResult struct {
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Errors   []RowError  `json:"errors"`
+	Aborted  *AbortError `json:"aborted,omitempty"`
+}
+
+// RowError describes why a row in an import was not imported
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// AbortError describes why an import stopped before the end of the input
+type AbortError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// HandleImportPets streams NDJSON or CSV pets from the request body into the
+// store. Each row is saved on its own, and the rows that fail are reported
+// back without stopping the import. With async=true the import runs as a
+// background job instead.
+func HandleImportPets(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	async, err := getQueryParamBool(r, "async", false)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	format, err := getImportFormat(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnsupportedMediaType, err, false)
+		return
+	}
+
+	if async {
+		submitImportJob(w, r, r.Body, format)
+		return
+	}
+
+	result := ImportPets(r.Context(), GetTenant(r), getActor(r), r.Body, format, nil)
+
+	writeResponse(w, http.StatusOK, result)
+}
+
+// submitImportJob spools body to a temporary file, so that the job can read it
+// once the request is over, and starts the import job
+func submitImportJob(w http.ResponseWriter, r *http.Request, body io.Reader, format string) {
+	f, err := ioutil.TempFile("", "pets-import-")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	var cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	_, err = io.Copy(f, body)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		WriteBodyError(w, r, bodyError(err))
+		return
+	}
+
+	tenant, actor := GetTenant(r), getActor(r)
+	ok := submitJob(w, r, JobTypeImport, func(ctx context.Context, t *job.Tracker) (*job.Result, error) {
+		defer cleanup()
+
+		result := ImportPets(ctx, tenant, actor, f, format, t)
+		if result.Aborted != nil {
+			return nil, fmt.Errorf("import aborted on line %d: %s", result.Aborted.Line, result.Aborted.Message)
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		return &job.Result{ContentType: "application/json; charset=UTF-8", Data: data}, nil
+	})
+	if !ok {
+		cleanup()
+	}
+}
+
+// getImportFormat works out the import format from the request Content-Type
+func getImportFormat(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type '%s': should be %s or %s", contentType, contentTypeNDJSON, contentTypeCSV)
+	}
+	format, exists := formatsByMediaType[mediaType]
+	if !exists {
+		return "", fmt.Errorf("unsupported Content-Type '%s': should be %s or %s", mediaType, contentTypeNDJSON, contentTypeCSV)
+	}
+	return format, nil
+}
+
+// ImportPets reads pets from r in the given format and saves them one by one
+// for tenant, as actor. It stops early if ctx is done. Progress is reported to t, which
+// may be nil.
+func ImportPets(ctx context.Context, tenant string, actor audit.Actor, r io.Reader, format string, t *job.Tracker) ImportResult {
+	var result = ImportResult{Errors: []RowError{}}
+
+	var save = func(line int, p pet.Pet, err error) {
+		if err == nil {
+			err = pet.TransactAs(tenant, actor, func(tx *pet.Tx) error {
+				return tx.AddPet(p)
+			})
+		}
+		t.Advance(1)
+		if err != nil {
+			result.Failed++
+			if len(result.Errors) < MaxImportErrors {
+				result.Errors = append(result.Errors, RowError{Line: line, Message: err.Error()})
+			}
+			t.AddError(fmt.Errorf("line %d: %v", line, err))
+			return
+		}
+		result.Imported++
+	}
+
+	var line int
+	var err error
+	switch format {
+	case FormatNDJSON:
+		line, err = readNDJSON(ctx, r, save)
+	case FormatCSV:
+		line, err = readCSV(ctx, r, save)
+	default:
+		err = fmt.Errorf("unsupported format '%s'", format)
+	}
+	if err != nil {
+		result.Aborted = &AbortError{Line: line, Message: err.Error()}
+	}
+
+	return result
+}
+
+// readNDJSON calls fn for each line of r. A line that isn't a valid pet is
+// passed to fn as an error, so the rest of the input can still be read.
+func readNDJSON(ctx context.Context, r io.Reader, fn func(line int, p pet.Pet, err error)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxImportLineBytes)
+
+	var line int
+	for scanner.Scan() {
+		line++
+		if err := ctx.Err(); err != nil {
+			return line, err
+		}
+		b := scanner.Bytes()
+		if strings.TrimSpace(string(b)) == "" {
+			continue
+		}
+		var p pet.Pet
+		err := decodeJSON(b, &p)
+		fn(line, p, err)
+	}
+	if err := scanner.Err(); err != nil {
+		return line + 1, bodyError(err)
+	}
+	return line, nil
+}
+
+// readCSV calls fn for each record of r. The first record must be a header
+// naming the columns, in any order.
+func readCSV(ctx context.Context, r io.Reader, fn func(line int, p pet.Pet, err error)) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	columns, err := getCSVColumns(header)
+	if err != nil {
+		return 1, err
+	}
+	// the header slice is reused by the reader, so keep its length around
+	var numColumns = len(header)
+
+	var line = 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			return perr.StartLine, err
+		}
+		if err != nil {
+			return line + 1, err
+		}
+		line, _ = reader.FieldPos(0)
+		if err := ctx.Err(); err != nil {
+			return line, err
+		}
+		if len(record) != numColumns {
+			fn(line, pet.Pet{}, fmt.Errorf("wrong number of fields: expected %d, got %d", numColumns, len(record)))
+			continue
+		}
+		p, err := parseCSVRecord(record, columns)
+		fn(line, p, err)
+	}
+
+	return line, nil
+}
+
+// getCSVColumns maps each known column to its index in the header
+func getCSVColumns(header []string) (map[string]int, error) {
+	var columns = make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		columns[name] = i
+	}
+	for _, name := range csvRequiredColumns {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("csv header is missing the '%s' column", name)
+		}
+	}
+	return columns, nil
+}
+
+func parseCSVRecord(record []string, columns map[string]int) (pet.Pet, error) {
+	var p pet.Pet
+
+	id, err := strconv.ParseInt(strings.TrimSpace(record[columns["id"]]), 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("error parsing id value to an int: %v", err)
+	}
+	p.ID = id
+	p.Name = record[columns["name"]]
+	if i, exists := columns["tag"]; exists {
+		p.Tag = record[i]
+	}
+
+	return p, nil
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/audit"
+	"../../service/pet"
+)
+
+func TestHandleImportPets(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	tests := []struct {
+		name         string
+		contentType  string
+		content      string
+		expectedCode int
+		expectedBody string
+		expectedPets []pet.Pet
+	}{
+		{
+			name:         "an unsupported content type should return 415",
+			contentType:  "application/json",
+			content:      `{"id": 1, "name": "Tommy"}`,
+			expectedCode: http.StatusUnsupportedMediaType,
+			expectedBody: `{"type":"urn:petsapi:problem:UNSUPPORTED_MEDIA_TYPE","title":"Unsupported media type","status":415,"detail":"unsupported Content-Type 'application/json': should be application/x-ndjson or text/csv","instance":"/v1/pets:import","code":"UNSUPPORTED_MEDIA_TYPE"}`,
+			expectedPets: []pet.Pet{},
+		},
+		{
+			name:        "ndjson rows should be imported, reporting the bad ones",
+			contentType: "application/x-ndjson",
+			content: `{"id": 1, "name": "Tommy"}
+{"id": 2}
+
+{...}
+{"id": 3, "name": "Buddy", "tag": "dog"}
+`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"imported":2,"failed":2,"errors":[` +
+				`{"line":2,"message":"invalid name: cannot be empty"},` +
+				`{"line":4,"message":"invalid character '.' looking for beginning of object key string"}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 3, Name: "Buddy", Tag: "dog"}},
+		},
+		{
+			name:        "csv rows should be imported, reporting the bad ones",
+			contentType: "text/csv; charset=utf-8",
+			content: `name,id,tag
+Tiger,2,cat
+Kitty,abc,
+Coco,8
+Pebbles,13,"rock, pet"
+`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"imported":2,"failed":2,"errors":[` +
+				`{"line":3,"message":"error parsing id value to an int: strconv.ParseInt: parsing \"abc\": invalid syntax"},` +
+				`{"line":4,"message":"wrong number of fields: expected 3, got 2"}` +
+				`]}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 2, Name: "Tiger", Tag: "cat"}, {ID: 3, Name: "Buddy", Tag: "dog"}, {ID: 13, Name: "Pebbles", Tag: "rock, pet"}},
+		},
+		{
+			name:         "csv without the required columns should abort",
+			contentType:  "text/csv",
+			content:      "id,tag\n5,cat\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"imported":0,"failed":0,"errors":[],"aborted":{"line":1,"message":"csv header is missing the 'name' column"}}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 2, Name: "Tiger", Tag: "cat"}, {ID: 3, Name: "Buddy", Tag: "dog"}, {ID: 13, Name: "Pebbles", Tag: "rock, pet"}},
+		},
+		{
+			name:         "malformed csv should abort at the bad line",
+			contentType:  "text/csv",
+			content:      "id,name\n5,Kitty\n6,\"Bad\"quote\n7,Max\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"imported":1,"failed":0,"errors":[],"aborted":{"line":3,"message":"parse error on line 3, column 7: extraneous or missing \" in quoted-field"}}`,
+			expectedPets: []pet.Pet{{ID: 1, Name: "Tommy"}, {ID: 2, Name: "Tiger", Tag: "cat"}, {ID: 3, Name: "Buddy", Tag: "dog"}, {ID: 5, Name: "Kitty"}, {ID: 13, Name: "Pebbles", Tag: "rock, pet"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// Create the fake HTTP request
+			var buff = bytes.NewBufferString(tt.content)
+			var r = httptest.NewRequest(http.MethodPost, "/v1/pets:import", buff)
+			r.Header.Set("Content-Type", tt.contentType)
+			var w = httptest.NewRecorder()
+
+			// Call the handler
+			HandleImportPets(w, r)
+
+			// Verify the status code
+			assert.Equal(t, tt.expectedCode, w.Code)
+
+			// Verify the response
+			resp := w.Result()
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			assert.Equal(t, tt.expectedBody, string(body))
+
+			// Verify the store
+			pets, err := pet.ListPets(pet.DefaultTenant)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedPets, pets)
+		})
+	}
+}
+
+func TestImportPets_LineTooLong(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	long := `{"id": 2, "name": "` + strings.Repeat("a", MaxImportLineBytes) + `"}`
+	content := `{"id": 1, "name": "Tommy"}` + "\n" + long + "\n"
+
+	result := ImportPets(context.Background(), pet.DefaultTenant, audit.System, strings.NewReader(content), FormatNDJSON, nil)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, &AbortError{Line: 2, Message: "bufio.Scanner: token too long"}, result.Aborted)
+}
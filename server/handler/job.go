@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"../../service/job"
+	"../../service/pet"
+)
+
+// Types of the jobs started through the API
+const (
+	JobTypeImport  = "pets.import"
+	JobTypeExport  = "pets.export"
+	JobTypeReindex = "pets.reindex"
+)
+
+// HandleGetJob returns the job that has the provided ID
+func HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	j, err := getJob(r, id)
+	if err == job.ErrNotExist {
+		writeError(w, r, http.StatusNotFound, err, false)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	writeJob(w, r, http.StatusOK, j)
+}
+
+// HandleCancelJob cancels the job that has the provided ID
+func HandleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	j, err := getJob(r, id)
+	if err == nil {
+		j, err = job.DefaultRunner.Cancel(id)
+	}
+	if err == job.ErrNotExist {
+		writeError(w, r, http.StatusNotFound, err, false)
+		return
+	}
+	if err == job.ErrFinished {
+		writeError(w, r, http.StatusConflict, err, false)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	// A running job stops in the background
+	code := http.StatusOK
+	if !j.Status.Finished() {
+		code = http.StatusAccepted
+	}
+	writeJob(w, r, code, j)
+}
+
+// HandleGetJobResult returns the result of the job that has the provided ID
+func HandleGetJobResult(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var result *job.Result
+	_, err := getJob(r, id)
+	if err == nil {
+		result, err = job.DefaultRunner.GetResult(id)
+	}
+	if err == job.ErrNotExist || err == job.ErrNoResult {
+		writeError(w, r, http.StatusNotFound, err, false)
+		return
+	}
+	if err == job.ErrNotFinished {
+		writeError(w, r, http.StatusConflict, err, false)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.Data)
+}
+
+// HandleReindexPets starts a job that rebuilds the pet index of the tenant
+func HandleReindexPets(w http.ResponseWriter, r *http.Request) {
+	tenant := GetTenant(r)
+	submitJob(w, r, JobTypeReindex, func(ctx context.Context, t *job.Tracker) (*job.Result, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		n, err := pet.Reindex(tenant)
+		if err != nil {
+			return nil, err
+		}
+		t.SetTotal(n)
+		t.Advance(n)
+		return nil, nil
+	})
+}
+
+// submitJob starts fn as a background job for the tenant of r, and responds
+// with 202 and the job. It returns false if the job could not be started.
+func submitJob(w http.ResponseWriter, r *http.Request, jobType string, fn job.Func) bool {
+	j, err := job.DefaultRunner.SubmitFor(GetTenant(r), jobType, fn)
+	if err == job.ErrQueueFull || err == job.ErrShutdown {
+		w.Header().Set("Retry-After", "60")
+		writeError(w, r, http.StatusServiceUnavailable, err, false)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return false
+	}
+
+	w.Header().Set("Location", jobURL(r, j.ID))
+	writeJob(w, r, http.StatusAccepted, j)
+	return true
+}
+
+// getJob returns the job with id, if it was submitted for the tenant of r.
+// The jobs of other tenants don't exist as far as r is concerned.
+func getJob(r *http.Request, id string) (job.Job, error) {
+	j, err := job.DefaultRunner.Get(id)
+	if err != nil {
+		return job.Job{}, err
+	}
+	if j.Owner != GetTenant(r) {
+		return job.Job{}, job.ErrNotExist
+	}
+	return j, nil
+}
+
+func writeJob(w http.ResponseWriter, r *http.Request, code int, j job.Job) {
+	if j.HasResult {
+		j.ResultURL = jobURL(r, j.ID) + "/result"
+	}
+	writeResponse(w, code, j)
+}
+
+// jobURL returns the link to the job with id, under the prefix of r when the
+// API is mounted under one
+func jobURL(r *http.Request, id string) string {
+	return fmt.Sprintf("%s/v1/jobs/%s", GetPrefix(r), id)
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/job"
+	"../../service/pet"
+)
+
+// callJobHandler calls h for the job with the given ID
+func callJobHandler(h http.HandlerFunc, method, id string) *httptest.ResponseRecorder {
+	var r = httptest.NewRequest(method, "/v1/jobs/"+id, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": id})
+	var w = httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// waitForJob polls the job endpoint until the job has finished
+func waitForJob(t *testing.T, id string) job.Job {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		w := callJobHandler(HandleGetJob, http.MethodGet, id)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var j job.Job
+		err := json.Unmarshal(w.Body.Bytes(), &j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status.Finished() {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return job.Job{}
+}
+
+func TestHandleImportPets_Async(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	content := "{\"id\": 1, \"name\": \"Tommy\"}\n{\"id\": 2}\n"
+	var r = httptest.NewRequest(http.MethodPost, "/v1/pets:import?async=true", bytes.NewBufferString(content))
+	r = WithParams(r, map[string]interface{}{"async": true})
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	var w = httptest.NewRecorder()
+	HandleImportPets(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var j job.Job
+	err := json.Unmarshal(w.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, JobTypeImport, j.Type)
+	assert.Equal(t, "/v1/jobs/"+j.ID, w.Header().Get("Location"))
+
+	j = waitForJob(t, j.ID)
+	assert.Equal(t, job.StatusSucceeded, j.Status)
+	assert.Equal(t, job.Progress{Done: 2}, j.Progress)
+	assert.Equal(t, []string{"line 2: invalid name: cannot be empty"}, j.Errors)
+	assert.Equal(t, "/v1/jobs/"+j.ID+"/result", j.ResultURL)
+
+	w = callJobHandler(HandleGetJobResult, http.MethodGet, j.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"imported":1,"failed":1,"errors":[{"line":2,"message":"invalid name: cannot be empty"}]}`, w.Body.String())
+
+	// Canceling a finished job should conflict
+	w = callJobHandler(HandleCancelJob, http.MethodDelete, j.ID)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestHandleExportPetsJob(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy"})
+
+	var r = WithParams(httptest.NewRequest(http.MethodPost, "/v1/pets:export?format=csv", nil), map[string]interface{}{"format": FormatCSV})
+	var w = httptest.NewRecorder()
+	HandleExportPetsJob(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var j job.Job
+	err := json.Unmarshal(w.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j = waitForJob(t, j.ID)
+	assert.Equal(t, job.StatusSucceeded, j.Status)
+	assert.Equal(t, job.Progress{Done: 1, Total: 1}, j.Progress)
+
+	w = callJobHandler(HandleGetJobResult, http.MethodGet, j.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,name,tag\n1,Tommy,\n", w.Body.String())
+}
+
+func TestHandleReindexPets(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+	pet.PopulateMockPets()
+
+	var w = httptest.NewRecorder()
+	HandleReindexPets(w, httptest.NewRequest(http.MethodPost, "/v1/pets:reindex", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var j job.Job
+	err := json.Unmarshal(w.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j = waitForJob(t, j.ID)
+	assert.Equal(t, job.StatusSucceeded, j.Status)
+	assert.Equal(t, job.Progress{Done: 6, Total: 6}, j.Progress)
+	assert.Equal(t, "", j.ResultURL)
+
+	w = callJobHandler(HandleGetJobResult, http.MethodGet, j.ID)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleGetJob_NotExist(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+	}()
+
+	for _, h := range []http.HandlerFunc{HandleGetJob, HandleCancelJob, HandleGetJobResult} {
+		w := callJobHandler(h, http.MethodGet, "abc123")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, `{"type":"urn:petsapi:problem:JOB_NOT_FOUND","title":"Job not found","status":404,"detail":"job does not exist","instance":"/v1/jobs/abc123","code":"JOB_NOT_FOUND"}`, w.Body.String())
+	}
+}
+
+func TestJobURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		expected string
+	}{
+		{name: "links should be to version 1", expected: "/v1/jobs/abc"},
+		{name: "links should be under the prefix", prefix: "/pets-api", expected: "/pets-api/v1/jobs/abc"},
+		{name: "links should be under a prefix with a version in it", prefix: "/v1/pets-api", expected: "/v1/pets-api/v1/jobs/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.prefix+"/v1/pets:reindex", nil)
+			assert.Equal(t, tt.expected, jobURL(WithPrefix(r, tt.prefix), "abc"))
+		})
+	}
+}
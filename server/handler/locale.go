@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"../../service/validation"
+	"../i18n"
+)
+
+// getLocale picks the locale of the response to r from its Accept-Language
+// header. Errors that aren't for a request, when r is nil, are in English.
+func getLocale(r *http.Request) string {
+	if r == nil {
+		return i18n.DefaultLocale
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// localizeError translates the title, detail and field errors of errE, which
+// was made from err, into its locale. Messages without a translation are
+// left in English.
+func localizeError(errE *Error, err error, hide bool) {
+	if errE.locale == i18n.DefaultLocale {
+		return
+	}
+
+	if msg, ok := i18n.Message(errE.locale, "title."+errE.Code, nil); ok {
+		errE.Title = msg
+	}
+
+	var detailCode = getTypedErrorCode(err)
+	if hide {
+		detailCode = CodeInternal
+	}
+	if msg, ok := i18n.Message(errE.locale, "detail."+detailCode, nil); ok && detailCode != "" {
+		errE.Detail = msg
+	}
+
+	var fieldErrs validation.Errors
+	if !hide && errors.As(err, &fieldErrs) {
+		errE.Errors = localizeFieldErrors(errE.locale, fieldErrs)
+		var msgs = make([]string, len(errE.Errors))
+		for i, fe := range errE.Errors {
+			msgs[i] = fe.Message
+		}
+		errE.Detail = strings.Join(msgs, "; ")
+	}
+}
+
+// localizeFieldErrors returns a copy of errs with their messages in locale
+func localizeFieldErrors(locale string, errs []validation.FieldError) []validation.FieldError {
+	var localized = make([]validation.FieldError, len(errs))
+	for i, fe := range errs {
+		localized[i] = fe
+		if locale == i18n.DefaultLocale {
+			continue
+		}
+
+		// Typed errors have the same message wherever they are, the others
+		// are described by their code
+		var msg string
+		var ok bool
+		if code := getTypedErrorCode(fe.Err); code != "" {
+			msg, ok = i18n.Message(locale, "detail."+code, nil)
+		} else {
+			msg, ok = i18n.Message(locale, "field."+fe.Code, fe.Params)
+		}
+		if ok {
+			localized[i].Message = msg
+		}
+	}
+	return localized
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/validation"
+	"../i18n"
+)
+
+func TestCatalogsCoverErrorCodes(t *testing.T) {
+	for _, locale := range i18n.Locales()[1:] {
+		for code := range errorTitles {
+			_, ok := i18n.Message(locale, "title."+code, nil)
+			assert.True(t, ok, "%s should have a title for %s", locale, code)
+		}
+		for _, c := range errorCodes {
+			_, ok := i18n.Message(locale, "detail."+c.code, nil)
+			assert.True(t, ok, "%s should have a detail for %s", locale, c.code)
+		}
+		_, ok := i18n.Message(locale, "detail."+CodeInternal, nil)
+		assert.True(t, ok, "%s should have a detail for %s", locale, CodeInternal)
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	tests := []struct {
+		name             string
+		acceptLanguage   string
+		expectedLanguage string
+		expectedTitle    string
+		expectedDetail   string
+		expectedErrors   []validation.FieldError
+	}{
+		{
+			name:             "no Accept-Language should be in English",
+			expectedLanguage: "en",
+			expectedTitle:    "Request validation failed",
+			expectedDetail:   "invalid id: cannot be less than 1; invalid name: cannot be empty",
+			expectedErrors: []validation.FieldError{
+				{Field: "id", Code: validation.CodeMinimum, Message: "invalid id: cannot be less than 1"},
+				{Field: "name", Code: validation.CodeRequired, Message: "invalid name: cannot be empty"},
+			},
+		},
+		{
+			name:             "Spanish should be in Spanish",
+			acceptLanguage:   "es-ES,es;q=0.9,en;q=0.8",
+			expectedLanguage: "es",
+			expectedTitle:    "La validación de la solicitud ha fallado",
+			expectedDetail:   "id no válido: no puede ser menor que 1; nombre no válido: no puede estar vacío",
+			expectedErrors: []validation.FieldError{
+				{Field: "id", Code: validation.CodeMinimum, Message: "id no válido: no puede ser menor que 1"},
+				{Field: "name", Code: validation.CodeRequired, Message: "nombre no válido: no puede estar vacío"},
+			},
+		},
+		{
+			name:             "unsupported languages should fall back to English",
+			acceptLanguage:   "ja",
+			expectedLanguage: "en",
+			expectedTitle:    "Request validation failed",
+			expectedDetail:   "invalid id: cannot be less than 1; invalid name: cannot be empty",
+			expectedErrors: []validation.FieldError{
+				{Field: "id", Code: validation.CodeMinimum, Message: "invalid id: cannot be less than 1"},
+				{Field: "name", Code: validation.CodeRequired, Message: "invalid name: cannot be empty"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var r = httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(`{}`))
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			var w = httptest.NewRecorder()
+			HandleCreatePet(w, r)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.expectedLanguage, w.Header().Get("Content-Language"))
+			assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+
+			var errH Error
+			err := json.Unmarshal(w.Body.Bytes(), &errH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, CodeValidationFailed, errH.Code)
+			assert.Equal(t, tt.expectedTitle, errH.Title)
+			assert.Equal(t, tt.expectedDetail, errH.Detail)
+			assert.Equal(t, tt.expectedErrors, errH.Errors)
+		})
+	}
+}
+
+func TestLocalizeFieldErrors(t *testing.T) {
+
+	errs := []validation.FieldError{
+		{Field: "limit", In: "query", Code: validation.CodeMaximum, Message: "must be at most 100", Params: map[string]interface{}{"maximum": 100.0}},
+		{Field: "format", In: "query", Code: validation.CodeEnum, Message: "must be one of: ndjson, csv"},
+	}
+
+	localized := localizeFieldErrors("fr", errs)
+
+	assert.Equal(t, "doit être au plus 100", localized[0].Message)
+	// Without the values, the message can't be translated
+	assert.Equal(t, "must be one of: ndjson, csv", localized[1].Message)
+	// The original should be left as it was
+	assert.Equal(t, "must be at most 100", errs[0].Message)
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+// paramsKey is the context key for the params of a request
+type paramsKey struct{}
+
+// WithParams returns a copy of r that carries params, the query, path and
+// header params of the request that were already parsed and validated against
+// the route. Handlers use them instead of parsing the raw values again.
+func WithParams(r *http.Request, params map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+}
+
+// getParam returns the validated value of the param name, if the request
+// has one
+func getParam(r *http.Request, name string) (interface{}, bool) {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]interface{})
+	v, exists := params[name]
+	return v, exists
+}
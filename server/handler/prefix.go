@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+// prefixKey is the context key for the path the API is mounted under
+type prefixKey struct{}
+
+// WithPrefix returns a copy of r to the API mounted under prefix, e.g.
+// /pets-api
+func WithPrefix(r *http.Request, prefix string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), prefixKey{}, prefix))
+}
+
+// GetPrefix returns the path the API is mounted under, or an empty string if
+// it isn't mounted under one
+func GetPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix
+}
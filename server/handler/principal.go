@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Kinds of principal, by how they authenticated
+const (
+	PrincipalAPIKey = "api_key"
+	PrincipalJWT    = "jwt"
+)
+
+// Principal is who made a request, once it is authenticated
+type Principal struct {
+	// Kind is how the principal authenticated, e.g. PrincipalAPIKey
+	Kind string
+	// ID identifies the principal among those of its kind, e.g. the ID of
+	// its API key or the subject of its token
+	ID string
+	// Name is a human readable name for the principal, e.g. the label of
+	// its API key
+	Name string
+	// Roles grant the principal permissions, see the rbac package
+	Roles []string
+	// Tenant is the only tenant the principal can act for, any of them if
+	// it is empty
+	Tenant string
+	// Claims are the claims of the token the principal authenticated with,
+	// for PrincipalJWT
+	Claims map[string]interface{}
+}
+
+// principalKey is the context key for the principal of a request
+type principalKey struct{}
+
+// WithPrincipal returns a copy of r made by p
+func WithPrincipal(r *http.Request, p Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// GetPrincipal returns who made r, if it was authenticated
+func GetPrincipal(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// ErrUnauthenticated is returned for requests to a route that needs a
+// principal, that don't say who they are
+var ErrUnauthenticated = errors.New("authentication is required")
+
+// ErrForbidden is returned for requests by a principal that isn't allowed to
+// make them
+var ErrForbidden = errors.New("you are not allowed to make this request")
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request, from clients that set one and
+// in every response
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key for the ID of a request
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of r identified by id
+func WithRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// GetRequestID returns the ID of r, or an empty string if it has none
+func GetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"../../service/pet"
+)
+
+// tenantKey is the context key for the tenant of a request
+type tenantKey struct{}
+
+// WithTenant returns a copy of r that is scoped to the pets of tenant
+func WithTenant(r *http.Request, tenant string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+}
+
+// GetTenant returns the tenant whose pets r is scoped to, pet.DefaultTenant
+// if it isn't scoped to one
+func GetTenant(r *http.Request) string {
+	if tenant, ok := r.Context().Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return pet.DefaultTenant
+}
+
+// tenantPattern is what tenant IDs look like, so they are safe to log and to
+// use in URLs
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ErrInvalidTenant is returned for tenant IDs that don't match tenantPattern
+var ErrInvalidTenant = errors.New("invalid tenant: must be up to 63 lowercase letters, digits, '-' or '_'")
+
+// ErrTenantRequired is returned for requests that aren't scoped to a tenant,
+// when the API is shared between tenants
+var ErrTenantRequired = errors.New("the tenant of the request is required")
+
+// CheckTenant returns ErrInvalidTenant if tenant isn't a valid tenant ID
+func CheckTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"../../service/pet"
+	"../../service/validation"
+)
+
+// PetV2 is how version 2 of the API represents a pet. It is adapted from
+// pet.Pet: the tags are kept in the pet's tag, separated by commas, so that
+// version 1 clients still see them.
+type PetV2 struct {
+	ID   int64    `json:"id" schema:"minimum=1"`
+	Name string   `json:"name" schema:"pattern=\\S"`
+	Tags []string `json:"tags,omitempty"`
+	// Links are only set in responses
+	Links *PetLinksV2 `json:"links,omitempty"`
+}
+
+// PetLinksV2 links to the pet itself
+type PetLinksV2 struct {
+	Self string `json:"self"`
+}
+
+// PetEnvelopeV2 is the response body of the version 2 routes for a single pet
+type PetEnvelopeV2 struct {
+	Data PetV2 `json:"data"`
+}
+
+// PetListEnvelopeV2 is the response body of the version 2 route for listing pets
+type PetListEnvelopeV2 struct {
+	Data  []PetV2   `json:"data"`
+	Meta  ListMeta  `json:"meta"`
+	Links ListLinks `json:"links"`
+}
+
+// ListMeta describes a page of a list
+type ListMeta struct {
+	Page  int `json:"page"`
+	Limit int `json:"limit"`
+	Total int `json:"total"`
+}
+
+// ListLinks links to the page of a list, and the next one if there is one
+type ListLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+}
+
+var errInvalidTag = errors.New("invalid tag: cannot contain a comma")
+
+// tagSeparator separates the tags of a PetV2 in pet.Pet.Tag
+var tagSeparator = ","
+
+// newPetV2 adapts p to version 2 of the API. link is the path of the pet.
+func newPetV2(p pet.Pet, link string) PetV2 {
+	var tags []string
+	for _, tag := range strings.Split(p.Tag, tagSeparator) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return PetV2{
+		ID:    p.ID,
+		Name:  p.Name,
+		Tags:  tags,
+		Links: &PetLinksV2{Self: link},
+	}
+}
+
+// toPet adapts the version 2 pet to the service, returning the same
+// validation errors as pet.Pet.Validate
+func (p PetV2) toPet() (pet.Pet, error) {
+	var v validation.Validator
+	for i, tag := range p.Tags {
+		v.Check(!strings.Contains(tag, tagSeparator), fmt.Sprintf("tags[%d]", i), validation.CodeInvalid, errInvalidTag)
+	}
+
+	var pp = pet.Pet{
+		ID:   p.ID,
+		Name: p.Name,
+		Tag:  strings.Join(p.Tags, tagSeparator),
+	}
+	v.Nested("", pp.Validate())
+	if err := v.Err(); err != nil {
+		return pet.Pet{}, err
+	}
+	return pp, nil
+}
+
+// getPetV2Link returns the path of the pet with id, under the path of the
+// pets collection
+func getPetV2Link(collection string, id int64) string {
+	return fmt.Sprintf("%s/%d", strings.TrimSuffix(collection, "/"), id)
+}
+
+// HandleListPetsV2 returns a page of the pets, with what is needed to get the
+// next one in the body
+func HandleListPetsV2(w http.ResponseWriter, r *http.Request) {
+	limit, err := getQueryParamInt(r, "limit", 100)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	page, err := getQueryParamInt(r, "page", 1)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	pets, err := pet.ListPets(GetTenant(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+	var total = len(pets)
+
+	var nextPage int
+	pets, nextPage, err = pet.Paginate(pets, limit, page)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err, false)
+		return
+	}
+
+	var resp = PetListEnvelopeV2{
+		Data:  make([]PetV2, len(pets)),
+		Meta:  ListMeta{Page: page, Limit: limit, Total: total},
+		Links: ListLinks{Self: fmt.Sprintf("%s?limit=%d&page=%d", r.URL.Path, limit, page)},
+	}
+	for i, p := range pets {
+		resp.Data[i] = newPetV2(p, getPetV2Link(r.URL.Path, p.ID))
+	}
+	if nextPage > 0 {
+		resp.Links.Next = fmt.Sprintf("%s?limit=%d&page=%d", r.URL.Path, limit, nextPage)
+	}
+
+	writeResponse(w, http.StatusOK, resp)
+}
+
+// HandleCreatePetV2 creates a new pet, or replaces the one with the same ID,
+// and responds with it
+func HandleCreatePetV2(w http.ResponseWriter, r *http.Request) {
+	var in PetV2
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	p, err := in.toPet()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err, false)
+		return
+	}
+
+	err = pet.TransactAs(GetTenant(r), getActor(r), func(tx *pet.Tx) error {
+		return tx.AddPet(p)
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	var link = getPetV2Link(r.URL.Path, p.ID)
+	w.Header().Set("Location", link)
+	writeResponse(w, http.StatusCreated, PetEnvelopeV2{Data: newPetV2(p, link)})
+}
+
+// HandleGetPetByIDV2 fetches the pet that has the provided ID
+func HandleGetPetByIDV2(w http.ResponseWriter, r *http.Request) {
+	id, err := getMuxParamrInt(r, "id")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	p, err := pet.GetPetByID(GetTenant(r), id)
+	if err == pet.ErrNotExist {
+		writeError(w, r, http.StatusNotFound, err, false)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err, true)
+		return
+	}
+
+	writeResponse(w, http.StatusOK, PetEnvelopeV2{Data: newPetV2(*p, r.URL.Path)})
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../../service/pet"
+)
+
+func TestHandleCreatePetV2(t *testing.T) {
+
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	tests := []struct {
+		name             string
+		content          string
+		expectedCode     int
+		expectedLocation string
+		expectedBody     string
+		expectedPet      pet.Pet
+	}{
+		{
+			name:             "a valid pet should be saved with its tags joined",
+			content:          `{"id": 1, "name": "Tommy", "tags": ["dog", "brown"]}`,
+			expectedCode:     http.StatusCreated,
+			expectedLocation: "/v2/pets/1",
+			expectedBody:     `{"data":{"id":1,"name":"Tommy","tags":["dog","brown"],"links":{"self":"/v2/pets/1"}}}`,
+			expectedPet:      pet.Pet{ID: 1, Name: "Tommy", Tag: "dog,brown"},
+		},
+		{
+			name:             "a pet without tags should be saved",
+			content:          `{"id": 2, "name": "Tiger"}`,
+			expectedCode:     http.StatusCreated,
+			expectedLocation: "/v2/pets/2",
+			expectedBody:     `{"data":{"id":2,"name":"Tiger","links":{"self":"/v2/pets/2"}}}`,
+			expectedPet:      pet.Pet{ID: 2, Name: "Tiger"},
+		},
+		{
+			name:         "tags with commas and invalid pets should list every error",
+			content:      `{"id": 0, "name": "Tommy", "tags": ["dog", "a,b"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"type": "urn:petsapi:problem:VALIDATION_FAILED",
+				"title": "Request validation failed",
+				"status": 400,
+				"detail": "invalid tag: cannot contain a comma; invalid id: cannot be less than 1",
+				"instance": "/v2/pets",
+				"code": "VALIDATION_FAILED",
+				"errors": [
+					{"field": "tags[1]", "code": "invalid", "message": "invalid tag: cannot contain a comma"},
+					{"field": "id", "code": "minimum", "message": "invalid id: cannot be less than 1"}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodPost, "/v2/pets", bytes.NewBufferString(tt.content))
+			var w = httptest.NewRecorder()
+			HandleCreatePetV2(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			assert.Equal(t, tt.expectedLocation, w.Header().Get("Location"))
+			if tt.expectedCode == http.StatusCreated {
+				p, err := pet.GetPetByID(pet.DefaultTenant, tt.expectedPet.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedPet, *p)
+			}
+		})
+	}
+}
+
+func TestHandleListPetsV2(t *testing.T) {
+
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy", Tag: "dog, brown"})
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 2, Name: "Tiger"})
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 3, Name: "Buddy", Tag: "cat"})
+
+	tests := []struct {
+		name         string
+		query        string
+		params       map[string]interface{}
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "the first page should link to the next",
+			query:        "?limit=2",
+			params:       map[string]interface{}{"limit": int64(2)},
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"data": [
+					{"id": 1, "name": "Tommy", "tags": ["dog", "brown"], "links": {"self": "/v2/pets/1"}},
+					{"id": 2, "name": "Tiger", "links": {"self": "/v2/pets/2"}}
+				],
+				"meta": {"page": 1, "limit": 2, "total": 3},
+				"links": {"self": "/v2/pets?limit=2&page=1", "next": "/v2/pets?limit=2&page=2"}
+			}`,
+		},
+		{
+			name:         "the last page should not link to a next one",
+			query:        "?limit=2&page=2",
+			params:       map[string]interface{}{"limit": int64(2), "page": int64(2)},
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"data": [{"id": 3, "name": "Buddy", "tags": ["cat"], "links": {"self": "/v2/pets/3"}}],
+				"meta": {"page": 2, "limit": 2, "total": 3},
+				"links": {"self": "/v2/pets?limit=2&page=2"}
+			}`,
+		},
+		{
+			name:         "pages past the end should be an error",
+			query:        "?limit=2&page=3",
+			params:       map[string]interface{}{"limit": int64(2), "page": int64(3)},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{
+				"type": "urn:petsapi:problem:INVALID_REQUEST",
+				"title": "Invalid request",
+				"status": 400,
+				"detail": "invalid page number: max of 2 page(s), got 3",
+				"instance": "/v2/pets",
+				"code": "INVALID_REQUEST"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = WithParams(httptest.NewRequest(http.MethodGet, "/v2/pets"+tt.query, nil), tt.params)
+			var w = httptest.NewRecorder()
+			HandleListPetsV2(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandleGetPetByIDV2(t *testing.T) {
+
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy", Tag: "dog"})
+
+	tests := []struct {
+		name         string
+		id           int64
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "an existing pet should be in an envelope",
+			id:           1,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"data": {"id": 1, "name": "Tommy", "tags": ["dog"], "links": {"self": "/v2/pets/1"}}}`,
+		},
+		{
+			name:         "a missing pet should be not found",
+			id:           2,
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{
+				"type": "urn:petsapi:problem:PET_NOT_FOUND",
+				"title": "Pet not found",
+				"status": 404,
+				"detail": "entity does not exist",
+				"instance": "/v2/pets/2",
+				"code": "PET_NOT_FOUND"
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v2/pets/%d", tt.id), nil)
+			r = WithParams(r, map[string]interface{}{"id": tt.id})
+			var w = httptest.NewRecorder()
+			HandleGetPetByIDV2(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale of the messages in the code, used when the
+// client doesn't ask for a locale that has a catalog
+var DefaultLocale = "en"
+
+// Catalog holds the messages of a locale, by key. Messages can have {name}
+// placeholders, which are filled in from the params.
+type Catalog map[string]string
+
+//go:embed locales/*.json
+var files embed.FS
+
+// catalogs holds the catalog of each supported locale
+var catalogs = loadCatalogs()
+
+var placeholderRegex = regexp.MustCompile(`\{([a-zA-Z]+)\}`)
+
+func loadCatalogs() map[string]Catalog {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to read the message catalogs: %v", err))
+	}
+	var catalogs = make(map[string]Catalog)
+	for _, e := range entries {
+		data, err := files.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to read the message catalog %s: %v", e.Name(), err))
+		}
+		var c Catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("Failed to parse the message catalog %s: %v", e.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(e.Name(), ".json")] = c
+	}
+	return catalogs
+}
+
+// Locales returns the supported locales, including the default one
+func Locales() []string {
+	var locales = []string{DefaultLocale}
+	for locale := range catalogs {
+		if locale != DefaultLocale {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+// Negotiate picks the supported locale that best matches an Accept-Language
+// header, falling back to DefaultLocale
+func Negotiate(acceptLanguage string) string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+	var langs []langQ
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	for _, l := range langs {
+		if l.tag == "*" || l.tag == DefaultLocale {
+			return DefaultLocale
+		}
+		if _, exists := catalogs[l.tag]; exists {
+			return l.tag
+		}
+		// es-MX falls back to es
+		if i := strings.Index(l.tag, "-"); i > 0 {
+			if _, exists := catalogs[l.tag[:i]]; exists {
+				return l.tag[:i]
+			}
+			if l.tag[:i] == DefaultLocale {
+				return DefaultLocale
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// Message returns the message for key in locale, with its placeholders filled
+// in from params. It returns false if the locale has no such message, or the
+// params don't have a value for each placeholder, in which case the caller
+// should use the message in the default locale.
+func Message(locale, key string, params map[string]interface{}) (string, bool) {
+	msg, exists := catalogs[locale][key]
+	if !exists {
+		return "", false
+	}
+
+	var ok = true
+	msg = placeholderRegex.ReplaceAllStringFunc(msg, func(p string) string {
+		v, exists := params[p[1:len(p)-1]]
+		if !exists {
+			ok = false
+			return p
+		}
+		return fmt.Sprint(v)
+	})
+	return msg, ok
+}
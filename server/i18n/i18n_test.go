@@ -0,0 +1,65 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expected       string
+	}{
+		{"no header should be the default", "", "en"},
+		{"a supported locale should be picked", "es", "es"},
+		{"regional variants should fall back to the language", "fr-CA", "fr"},
+		{"tags should be case insensitive", "DE-de", "de"},
+		{"the highest quality should win", "es;q=0.5, fr;q=0.9", "fr"},
+		{"unsupported locales should be skipped", "ja, de;q=0.8", "de"},
+		{"English should not fall through to other locales", "en-GB, es;q=0.5", "en"},
+		{"q=0 should never be picked", "es;q=0", "en"},
+		{"wildcards should be the default", "ja, *;q=0.5, es;q=0.1", "en"},
+		{"nothing supported should be the default", "ja, zh", "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Negotiate(tt.acceptLanguage))
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+
+	msg, ok := Message("es", "field.minimum", map[string]interface{}{"minimum": 1})
+	assert.True(t, ok)
+	assert.Equal(t, "debe ser como mínimo 1", msg)
+
+	// Missing params can't be filled in
+	_, ok = Message("es", "field.minimum", nil)
+	assert.False(t, ok)
+
+	// Neither can missing keys or locales
+	_, ok = Message("es", "field.unknown", nil)
+	assert.False(t, ok)
+	_, ok = Message("xx", "field.minimum", nil)
+	assert.False(t, ok)
+}
+
+func TestCatalogs(t *testing.T) {
+
+	assert.Equal(t, []string{"en", "de", "es", "fr"}, Locales())
+
+	// Every catalog should have the same keys
+	for locale, c := range catalogs {
+		for other, oc := range catalogs {
+			for key := range c {
+				_, exists := oc[key]
+				assert.True(t, exists, "%s has %s, but %s doesn't", locale, key, other)
+			}
+		}
+	}
+}
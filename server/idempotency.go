@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/teejays/clog"
+
+	"../service/idempotency"
+	apihandler "./handler"
+)
+
+// IdempotencyWindow is how long the response for an Idempotency-Key is kept
+// around for replay
+var IdempotencyWindow = 24 * time.Hour
+
+var idempotencyKeyHeader = "Idempotency-Key"
+var idempotencyReplayedHeader = "Idempotent-Replayed"
+var maxIdempotencyKeyLength = 255
+
+// idempotencyMiddleware returns a middleware that replays the stored response
+// when a request is retried with the same Idempotency-Key. Requests without
+// the header are passed through untouched.
+func idempotencyMiddleware(store *idempotency.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				apihandler.WriteError(w, r, http.StatusBadRequest, fmt.Errorf("%s cannot be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength), false)
+				return
+			}
+
+			// Read the body so we can fingerprint the request, and put it
+			// back for the handler
+			body, err := apihandler.ReadBody(r)
+			if err != nil {
+				apihandler.WriteBodyError(w, r, err)
+				return
+			}
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			// Tenants, and the principals in them, may pick the same keys,
+			// without seeing each other's responses
+			var principal string
+			if p, ok := apihandler.GetPrincipal(r); ok {
+				principal = p.Kind + ":" + p.ID
+			}
+			storeKey := apihandler.GetTenant(r) + "\x00" + principal + "\x00" + key
+			rec, err := store.Begin(storeKey, fingerprintRequest(r, body))
+			if err == idempotency.ErrFingerprintMismatch {
+				apihandler.WriteError(w, r, http.StatusUnprocessableEntity, err, false)
+				return
+			}
+			if err == idempotency.ErrInProgress {
+				apihandler.WriteError(w, r, http.StatusConflict, err, false)
+				return
+			}
+			if err != nil {
+				apihandler.WriteError(w, r, http.StatusInternalServerError, err, true)
+				return
+			}
+
+			// Replay the stored response
+			if rec != nil {
+				clog.Debugf("Server: replaying response for %s %s", idempotencyKeyHeader, key)
+				for k, v := range rec.Response.Header {
+					w.Header()[k] = v
+				}
+				w.Header().Set(idempotencyReplayedHeader, "true")
+				w.WriteHeader(rec.Response.StatusCode)
+				w.Write(rec.Response.Body)
+				return
+			}
+
+			// Release the key if the handler panics, so that the request
+			// can be retried
+			var done bool
+			defer func() {
+				if !done {
+					store.Release(storeKey)
+				}
+			}()
+
+			rw := &recordingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK, before: w.Header().Clone()}
+			next.ServeHTTP(rw, r)
+			done = true
+
+			// Server errors are not stored, so that the client can retry them
+			if rw.statusCode >= http.StatusInternalServerError {
+				store.Release(storeKey)
+				return
+			}
+			store.Complete(storeKey, idempotency.Response{
+				StatusCode: rw.statusCode,
+				Header:     rw.header,
+				Body:       rw.body.Bytes(),
+			})
+		})
+	}
+}
+
+// fingerprintRequest identifies a request by its method, path, query and
+// body. The query is sorted by name, so the order of the params doesn't
+// matter.
+func fingerprintRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingResponseWriter is a http.ResponseWriter that keeps a copy of the
+// response written through it. Only the headers that changed from before are
+// kept, as the ones set by outer middlewares, e.g. the rate limit, are set
+// again for each request.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	before      http.Header
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.statusCode = code
+	rw.header = changedHeader(rw.before, rw.ResponseWriter.Header())
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// changedHeader returns the headers of after that aren't the same in before
+func changedHeader(before, after http.Header) http.Header {
+	var changed = make(http.Header)
+	for k, v := range after {
+		if !equalValues(before[k], v) {
+			changed[k] = append([]string(nil), v...)
+		}
+	}
+	return changed
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/apikey"
+	"../service/idempotency"
+	"../service/pet"
+	"../service/ratelimit"
+	apihandler "./handler"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	h := newHandler(Options{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name             string
+		key              string
+		body             string
+		expectedCode     int
+		expectedReplayed string
+		expectedErr      string
+	}{
+		{
+			name:         "first request with a key should be processed",
+			key:          "key-1",
+			body:         `{"id": 1, "name": "Tommy"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:             "retry with the same key and body should be replayed",
+			key:              "key-1",
+			body:             `{"id": 1, "name": "Tommy"}`,
+			expectedCode:     http.StatusCreated,
+			expectedReplayed: "true",
+		},
+		{
+			name:         "retry with the same key and a different body should return 422",
+			key:          "key-1",
+			body:         `{"id": 1, "name": "Tiger"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedErr:  "idempotency key has already been used for a different request",
+		},
+		{
+			name:         "requests that fail validation should not be processed",
+			key:          "key-2",
+			body:         `{"id": 2}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "request validation failed",
+		},
+		{
+			name:         "requests that fail validation should not use up the key",
+			key:          "key-2",
+			body:         `{"id": 2, "name": "Buddy"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "requests without a key should not be affected",
+			body:         `{"id": 1, "name": "Tiger"}`,
+			expectedCode: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/pets", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedReplayed, resp.Header.Get("Idempotent-Replayed"))
+			if tt.expectedErr == "" {
+				assert.Equal(t, "application/json; charset=UTF-8", resp.Header.Get("Content-Type"))
+			}
+			if tt.expectedErr != "" {
+				assert.Equal(t, apihandler.MediaTypeProblemJSON, resp.Header.Get("Content-Type"))
+				var errH apihandler.Error
+				err = json.Unmarshal(body, &errH)
+				if err != nil {
+					t.Error(err)
+				}
+				assert.Equal(t, tt.expectedErr, errH.Detail)
+			}
+		})
+	}
+
+	// The pet should only have been created once, and the 422 not applied
+	p, err := pet.GetPetByID(pet.DefaultTenant, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tiger", p.Name)
+	assert.Equal(t, 3, len(pet.PendingEvents()))
+}
+
+func TestIdempotencyMiddleware_Query(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	h := newHandler(Options{})
+	do := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets:batch"+query, bytes.NewBufferString(`{"operations": [{"op": "create", "pet": {"id": 1, "name": "Tommy"}}]}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("?atomic=true&x=1").Code)
+
+	// The order of the params doesn't matter
+	w := do("?x=1&atomic=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	// but their values do
+	assert.Equal(t, http.StatusUnprocessableEntity, do("?atomic=false&x=1").Code)
+}
+
+func TestIdempotencyMiddleware_Principals(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+		pet.ResetData()
+	}(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("one", "One", "pk_one", []string{"editor"})
+	apikey.DefaultStore.AddStatic("two", "Two", "pk_two", []string{"editor"})
+
+	h := newHandler(Options{Auth: &AuthOptions{APIKeys: true}})
+	do := func(apiKey, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-API-Key", apiKey)
+		r.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, do("pk_one", `{"id": 1, "name": "Tommy"}`).Code)
+
+	// Another key may pick the same Idempotency-Key, without getting the
+	// response of the first one
+	w := do("pk_two", `{"id": 2, "name": "Tiger"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	_, err := pet.GetPetByID(pet.DefaultTenant, 2)
+	assert.NoError(t, err)
+
+	// while each of them still gets their own replayed
+	w = do("pk_one", `{"id": 1, "name": "Tommy"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotencyMiddleware_Panic(t *testing.T) {
+	store := idempotency.NewStore(time.Hour)
+	var panics = true
+	h := idempotencyMiddleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(`{}`))
+		r.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	func() {
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+		do()
+	}()
+
+	// The key isn't left in progress, so the request can be retried
+	panics = false
+	assert.Equal(t, http.StatusCreated, do().Code)
+}
+
+func TestIdempotencyMiddleware_Headers(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(quotas *ratelimit.Quotas) {
+		clog.LogLevel = 0
+		ratelimit.DefaultQuotas = quotas
+		pet.ResetData()
+	}(ratelimit.DefaultQuotas)
+	ratelimit.DefaultQuotas = ratelimit.NewQuotas()
+
+	h := newHandler(Options{RateLimit: &RateLimitOptions{Requests: 10, Period: time.Minute, KeyBy: RateLimitByIP}})
+	do := func(requestID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v2/pets", bytes.NewBufferString(`{"id": 1, "name": "Tommy"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "key-1")
+		r.Header.Set(apihandler.RequestIDHeader, requestID)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("first")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "9", w.Header().Get("RateLimit-Remaining"))
+	location := w.Header().Get("Location")
+	assert.NotEmpty(t, location)
+
+	// The replay has the headers the handler set, and the ones of its own
+	w = do("second")
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, location, w.Header().Get("Location"))
+	assert.Equal(t, "8", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "second", w.Header().Get(apihandler.RequestIDHeader))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"./network"
+)
+
+// Networks the server can listen on, see the network package
+const (
+	NetworkTCP     = network.TCP
+	NetworkUnix    = network.Unix
+	NetworkFD      = network.FD
+	NetworkSystemd = network.Systemd
+)
+
+// Networks lists the networks the server can listen on
+var Networks = network.Names
+
+// DefaultSocketMode is the permissions of Unix sockets when
+// ListenOptions.SocketMode isn't set
+var DefaultSocketMode os.FileMode = 0660
+
+// sdListenFDsStart is the first file descriptor passed by systemd
+const sdListenFDsStart = 3
+
+// ListenOptions is where the server listens
+type ListenOptions struct {
+	// Network is one of Networks, NetworkTCP if empty
+	Network string
+	// Addr and Port are for NetworkTCP. Port 0 picks a free port.
+	Addr string
+	Port int
+	// SocketPath and SocketMode are for NetworkUnix. The socket is removed
+	// when the server shuts down.
+	SocketPath string
+	SocketMode os.FileMode
+	// FD is for NetworkFD
+	FD int
+}
+
+// Listen returns a listener for opts
+func Listen(opts ListenOptions) (net.Listener, error) {
+	switch opts.Network {
+	case "", NetworkTCP:
+		return net.Listen("tcp", fmt.Sprintf("%s:%d", opts.Addr, opts.Port))
+	case NetworkUnix:
+		return listenUnix(opts.SocketPath, opts.SocketMode)
+	case NetworkFD:
+		return listenFD(opts.FD)
+	case NetworkSystemd:
+		return listenSystemd()
+	}
+	return nil, fmt.Errorf("unsupported network %q", opts.Network)
+}
+
+// listenUnix creates a Unix domain socket at path, with the permissions in
+// mode. A socket left behind at path by a server that didn't shut down
+// cleanly is replaced, but not one that is still being listened on.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already being listened on", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenFD returns a listener on the socket with the file descriptor fd
+func listenFD(fd int) (net.Listener, error) {
+	f := os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd))
+	if f == nil {
+		return nil, fmt.Errorf("file descriptor %d is not valid", fd)
+	}
+	// The listener has its own copy of the file descriptor
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("file descriptor %d is not a listening socket: %w", fd, err)
+	}
+	return l, nil
+}
+
+// listenSystemd returns a listener on the first socket passed by systemd,
+// following sd_listen_fds(3). The variables are unset so that they aren't
+// passed on to child processes.
+func listenSystemd() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets were passed by systemd, LISTEN_PID is not set to this process")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets were passed by systemd, LISTEN_FDS is %q", os.Getenv("LISTEN_FDS"))
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	return listenFD(sdListenFDsStart)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+)
+
+// unixClient returns an HTTP client that connects to the socket at path
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func TestStart_Unix(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "pets.sock")
+
+	s, err := Start(ListenOptions{Network: NetworkUnix, SocketPath: path, SocketMode: 0600}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	resp, err := unixClient(path).Get("http://pets/v1/pets")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// The socket is removed on shutdown
+	assert.NoError(t, s.Shutdown(context.Background()))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListen_Unix(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket left behind is replaced
+	var stale = filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = Listen(ListenOptions{Network: NetworkUnix, SocketPath: stale})
+	if assert.NoError(t, err) {
+		defer l.Close()
+		info, err := os.Stat(stale)
+		if assert.NoError(t, err) {
+			assert.Equal(t, DefaultSocketMode, info.Mode().Perm())
+		}
+
+		// But not while it is being listened on
+		_, err = Listen(ListenOptions{Network: NetworkUnix, SocketPath: stale})
+		assert.EqualError(t, err, stale+" is already being listened on")
+	}
+
+	// Other files are left alone
+	var file = filepath.Join(dir, "pets.json")
+	if err := ioutil.WriteFile(file, []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Listen(ListenOptions{Network: NetworkUnix, SocketPath: file})
+	assert.EqualError(t, err, file+" exists and is not a socket")
+}
+
+func TestListen_FD(t *testing.T) {
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	l, err := Listen(ListenOptions{Network: NetworkFD, FD: int(f.Fd())})
+	if assert.NoError(t, err) {
+		assert.Equal(t, tcp.Addr().String(), l.Addr().String())
+		l.Close()
+	}
+
+	// Files that aren't sockets can't be listened on
+	_, err = Listen(ListenOptions{Network: NetworkFD, FD: int(os.Stdin.Fd())})
+	assert.Error(t, err)
+}
+
+func TestListen_Systemd(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		pid      string
+		fds      string
+		expected string
+	}{
+		{
+			name:     "sockets for another process should be ignored",
+			pid:      "1",
+			fds:      "1",
+			expected: "no sockets were passed by systemd, LISTEN_PID is not set to this process",
+		},
+		{
+			name:     "no sockets should be an error",
+			pid:      strconv.Itoa(os.Getpid()),
+			fds:      "0",
+			expected: "no sockets were passed by systemd, LISTEN_FDS is \"0\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+
+			_, err := Listen(ListenOptions{Network: NetworkSystemd})
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+
+	_, err := Listen(ListenOptions{Network: "carrier-pigeon"})
+	assert.EqualError(t, err, "unsupported network \"carrier-pigeon\"")
+}
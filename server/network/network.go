@@ -0,0 +1,19 @@
+// Package network names what the server can listen on, so that the server
+// and its configuration agree on them.
+package network
+
+// What the server can listen on
+const (
+	// TCP listens on an address and port
+	TCP = "tcp"
+	// Unix listens on a Unix domain socket, created at a path
+	Unix = "unix"
+	// FD listens on a socket inherited as an open file descriptor
+	FD = "fd"
+	// Systemd listens on the first socket passed by systemd socket
+	// activation
+	Systemd = "systemd"
+)
+
+// Names lists what the server can listen on
+var Names = []string{TCP, Unix, FD, Systemd}
@@ -0,0 +1,329 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"../handler"
+	"../route"
+	"../schema"
+)
+
+// Version of the OpenAPI specification the generated documents follow
+var Version = "3.1.0"
+
+// Info describes the API in the generated document
+var Info = DocumentInfo{
+	Title:   "Pets API",
+	Version: "2.0.0",
+}
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       DocumentInfo        `json:"info"`
+	Servers    []Server            `json:"servers,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	// Security is how operations are authenticated, unless they say
+	// otherwise
+	Security []SecurityRequirement `json:"security,omitempty"`
+}
+
+// DocumentInfo is the metadata about the API
+type DocumentInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Server is where the paths of the document are served from
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem holds the operations on a path, keyed by lowercase HTTP method
+type PathItem map[string]*Operation
+
+// Operation describes a single route
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	// Security is set to an empty list for operations that don't need to
+	// be authenticated
+	Security *[]SecurityRequirement `json:"security,omitempty"`
+	// Permissions are the permissions the principal needs to call the
+	// operation
+	Permissions []string `json:"x-permissions,omitempty"`
+	// RateLimitCost is how much of the rate limit a request takes, when it
+	// is more than 1
+	RateLimitCost int `json:"x-rate-limit-cost,omitempty"`
+}
+
+// Parameter describes a query, path or header param of an operation
+type Parameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *schema.Schema `json:"schema"`
+}
+
+// RequestBody describes the request body of an operation
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body for a media type
+type MediaType struct {
+	Schema *schema.Schema `json:"schema,omitempty"`
+}
+
+// Components holds the named schemas and security schemes referred to in
+// the document
+type Components struct {
+	Schemas         map[string]*schema.Schema `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a way that requests are authenticated
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// SecurityRequirement is the security schemes, by name, that are all needed
+// to authenticate a request
+type SecurityRequirement map[string][]string
+
+// SecuritySchemes are the ways requests can be authenticated, when the
+// server requires it
+var SecuritySchemes = map[string]SecurityScheme{
+	"apiKey": {
+		Type:        "apiKey",
+		Description: "An API key, which can also be given as a bearer token",
+		Name:        "X-API-Key",
+		In:          "header",
+	},
+	"bearer": {
+		Type:         "http",
+		Description:  "A JWT from the configured issuer, or an API key",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+	},
+}
+
+// Path is where the generated document is served
+var Path = "openapi.json"
+
+// muxVarRegex matches a gorilla/mux route variable, with an optional pattern
+var muxVarRegex = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]+))?\}`)
+
+// Generate builds the OpenAPI document for routes
+func Generate(routes []route.Route) (*Document, error) {
+	var reg = schema.NewRegistry()
+	var doc = Document{
+		OpenAPI:    Version,
+		Info:       Info,
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: reg.Schemas, SecuritySchemes: SecuritySchemes},
+	}
+	for name := range SecuritySchemes {
+		doc.Security = append(doc.Security, SecurityRequirement{name: {}})
+	}
+	sort.Slice(doc.Security, func(i, j int) bool {
+		return getSchemeName(doc.Security[i]) < getSchemeName(doc.Security[j])
+	})
+
+	var errorResponse = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{handler.MediaTypeProblemJSON: {Schema: reg.Of(handler.Error{})}},
+	}
+
+	var operationIDs = make(map[string]bool)
+	for _, r := range routes {
+		if r.Name == "" {
+			return nil, fmt.Errorf("route %s %s has no name", r.Method, r.GetPattern())
+		}
+		if operationIDs[r.Name] {
+			return nil, fmt.Errorf("route name %s is used more than once", r.Name)
+		}
+		operationIDs[r.Name] = true
+
+		path, pathParams := convertPattern(r.GetPattern())
+		op := &Operation{
+			OperationID: r.Name,
+			Summary:     r.Summary,
+			Tags:        []string{getTag(r.Path)},
+			Parameters:  getParameters(r.Params, pathParams),
+			Responses:   map[string]Response{"default": errorResponse},
+			Deprecated:  r.Deprecation != nil,
+			Permissions: r.Permissions,
+		}
+		if cost := r.GetCost(); cost > 1 {
+			op.RateLimitCost = cost
+		}
+		if r.Public {
+			op.Security = &[]SecurityRequirement{}
+		}
+		if r.Request != nil {
+			op.RequestBody = &RequestBody{
+				Description: r.Request.Description,
+				Required:    true,
+				Content:     getContent(reg, *r.Request),
+			}
+		}
+		for code, body := range r.Responses {
+			op.Responses[strconv.Itoa(code)] = getResponse(reg, code, body)
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(PathItem)
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	return &doc, nil
+}
+
+// NewRoute returns the route that serves the OpenAPI document for routes,
+// which includes the document route itself. serverURL is where the routes are
+// served from, if not the root of the host, e.g. when the API is mounted
+// under a prefix.
+func NewRoute(routes []route.Route, serverURL string) (route.Route, error) {
+	r := route.Route{
+		Method:  http.MethodGet,
+		Version: 1,
+		Path:    Path,
+		Name:    "getOpenAPI",
+		Summary: "Get the OpenAPI document for this API",
+		Public:  true,
+		Responses: map[int]route.Body{
+			http.StatusOK: {Description: "The OpenAPI document", Schema: &schema.Schema{Type: schema.TypeObject}},
+		},
+	}
+
+	var all = append(append([]route.Route{}, routes...), r)
+	doc, err := Generate(all)
+	if err != nil {
+		return r, err
+	}
+	if serverURL != "" {
+		doc.Servers = []Server{{URL: serverURL}}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return r, err
+	}
+
+	r.HandlerFunc = func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+	return r, nil
+}
+
+// getSchemeName returns the name of the scheme in a requirement with one
+func getSchemeName(req SecurityRequirement) string {
+	for name := range req {
+		return name
+	}
+	return ""
+}
+
+// convertPattern turns a gorilla/mux route pattern into an OpenAPI path, and
+// returns the path params in it along with their patterns
+func convertPattern(pattern string) (string, map[string]string) {
+	var params = make(map[string]string)
+	path := muxVarRegex.ReplaceAllStringFunc(pattern, func(v string) string {
+		m := muxVarRegex.FindStringSubmatch(v)
+		params[m[1]] = m[2]
+		return "{" + m[1] + "}"
+	})
+	return path, params
+}
+
+// getTag groups operations by the resource at the start of their path
+func getTag(path string) string {
+	return strings.FieldsFunc(path, func(c rune) bool { return c == '/' || c == ':' })[0]
+}
+
+// getParameters converts the route params, adding any path params that the
+// route didn't describe
+func getParameters(params []route.Param, pathParams map[string]string) []Parameter {
+	var parameters []Parameter
+	var described = make(map[string]bool)
+	for _, p := range params {
+		parameters = append(parameters, Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == route.InPath,
+			Schema:      p.Schema,
+		})
+		if p.In == route.InPath {
+			described[p.Name] = true
+		}
+	}
+
+	var names []string
+	for name := range pathParams {
+		if !described[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := schema.String()
+		if pathParams[name] != "" {
+			s.Pattern = "^" + pathParams[name] + "$"
+		}
+		parameters = append(parameters, Parameter{Name: name, In: route.InPath, Required: true, Schema: s})
+	}
+
+	return parameters
+}
+
+func getResponse(reg *schema.Registry, code int, body route.Body) Response {
+	resp := Response{Description: body.Description}
+	if resp.Description == "" {
+		resp.Description = http.StatusText(code)
+	}
+	if body.Type != nil || body.Schema != nil {
+		resp.Content = getContent(reg, body)
+	}
+	return resp
+}
+
+func getContent(reg *schema.Registry, body route.Body) map[string]MediaType {
+	s := body.Schema
+	if s == nil {
+		s = reg.Of(body.Type)
+	}
+	var content = make(map[string]MediaType)
+	for _, mediaType := range body.GetMediaTypes() {
+		content[mediaType] = MediaType{Schema: s}
+	}
+	return content
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"../../service/rbac"
+	"../handler"
+	"../route"
+	"../schema"
+)
+
+// TestGenerate_AllRoutesDocumented makes sure every registered route shows up
+// in the document with enough detail for client generators
+func TestGenerate_AllRoutesDocumented(t *testing.T) {
+
+	routes := route.GetRoutes()
+	doc, err := Generate(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range routes {
+		t.Run(r.Method+" "+r.GetPattern(), func(t *testing.T) {
+			assert.NotEqual(t, "", r.Name, "route should have a name")
+			assert.NotEqual(t, "", r.Summary, "route should have a summary")
+			assert.NotEqual(t, 0, len(r.Responses), "route should document its responses")
+
+			path, pathParams := convertPattern(r.GetPattern())
+			op := doc.Paths[path][strings.ToLower(r.Method)]
+			if op == nil {
+				t.Fatalf("route is missing from the document")
+			}
+			assert.Equal(t, r.Name, op.OperationID)
+
+			// Each path param should be documented by the route itself
+			for name := range pathParams {
+				var found bool
+				for _, p := range r.Params {
+					found = found || (p.In == route.InPath && p.Name == name)
+				}
+				assert.True(t, found, "path param %s should be described", name)
+			}
+
+			// JSON bodies should refer to a schema
+			for code, body := range r.Responses {
+				resp := op.Responses[strconv.Itoa(code)]
+				assert.NotEqual(t, "", resp.Description)
+				for mediaType, content := range resp.Content {
+					assert.NotNil(t, content.Schema, "%d %s should have a schema", code, mediaType)
+				}
+				if body.Type != nil {
+					assert.NotNil(t, resp.Content[route.MediaTypeJSON].Schema)
+				}
+			}
+
+			// Errors should be documented as problem details
+			assert.NotNil(t, op.Responses["default"].Content[handler.MediaTypeProblemJSON].Schema)
+		})
+	}
+
+	// Every $ref should point at a registered schema
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name := ref[:strings.Index(ref, `"`)]
+		assert.NotNil(t, doc.Components.Schemas[name], "schema %s should be registered", name)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		routes  []route.Route
+		isError bool
+	}{
+		{
+			name: "routes without a name should error",
+			routes: []route.Route{
+				{Method: http.MethodGet, Version: 1, Path: "things"},
+			},
+			isError: true,
+		},
+		{
+			name: "routes with the same name should error",
+			routes: []route.Route{
+				{Method: http.MethodGet, Version: 1, Path: "things", Name: "things"},
+				{Method: http.MethodPost, Version: 1, Path: "things", Name: "things"},
+			},
+			isError: true,
+		},
+		{
+			name: "routes with unique names should be OK",
+			routes: []route.Route{
+				{Method: http.MethodGet, Version: 1, Path: "things", Name: "listThings"},
+				{Method: http.MethodPost, Version: 1, Path: "things", Name: "createThing"},
+			},
+			isError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(tt.routes)
+			assert.Equal(t, tt.isError, err != nil)
+		})
+	}
+}
+
+func TestGetParameters(t *testing.T) {
+
+	_, pathParams := convertPattern("/v1/owners/{owner:[0-9]+}/pets/{id}")
+	params := getParameters([]route.Param{
+		{Name: "id", In: route.InPath, Schema: schema.Integer()},
+		{Name: "limit", In: route.InQuery, Schema: schema.Integer()},
+	}, pathParams)
+
+	assert.Equal(t, []Parameter{
+		{Name: "id", In: route.InPath, Required: true, Schema: schema.Integer()},
+		{Name: "limit", In: route.InQuery, Schema: schema.Integer()},
+		{Name: "owner", In: route.InPath, Required: true, Schema: &schema.Schema{Type: schema.TypeString, Pattern: "^[0-9]+$"}},
+	}, params)
+}
+
+func TestNewRoute(t *testing.T) {
+
+	r, err := NewRoute(route.GetRoutes(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/v1/openapi.json", r.GetPattern())
+
+	var w = httptest.NewRecorder()
+	r.HandlerFunc(w, httptest.NewRequest(http.MethodGet, r.GetPattern(), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var doc Document
+	err = json.Unmarshal(w.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	// The document route should document itself
+	assert.Equal(t, "getOpenAPI", doc.Paths["/v1/openapi.json"]["get"].OperationID)
+	assert.Equal(t, len(route.GetRoutes())+1, countOperations(doc))
+
+	// Routes with a replacement in version 2 should be deprecated
+	assert.True(t, doc.Paths["/v1/pets"]["get"].Deprecated)
+	assert.False(t, doc.Paths["/v2/pets"]["get"].Deprecated)
+	assert.False(t, doc.Paths["/v1/openapi.json"]["get"].Deprecated)
+
+	// Routes need to be authenticated, apart from the public ones
+	assert.Equal(t, []SecurityRequirement{{"apiKey": {}}, {"bearer": {}}}, doc.Security)
+	assert.Nil(t, doc.Paths["/v1/pets"]["get"].Security)
+	assert.Equal(t, &[]SecurityRequirement{}, doc.Paths["/v1/openapi.json"]["get"].Security)
+	assert.Equal(t, []string{rbac.PermissionPetsRead}, doc.Paths["/v1/pets"]["get"].Permissions)
+	assert.Empty(t, doc.Paths["/v1/openapi.json"]["get"].Permissions)
+	assert.Equal(t, 10, doc.Paths["/v1/pets:import"]["post"].RateLimitCost)
+	assert.Equal(t, 0, doc.Paths["/v1/pets"]["get"].RateLimitCost)
+
+	// Routes are served from the root, unless there is a server URL
+	assert.Empty(t, doc.Servers)
+	r, err = NewRoute(route.GetRoutes(), "/pets-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	r.HandlerFunc(w, httptest.NewRequest(http.MethodGet, r.GetPattern(), nil))
+	err = json.Unmarshal(w.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Server{{URL: "/pets-api"}}, doc.Servers)
+}
+
+func countOperations(doc Document) int {
+	var n int
+	for _, item := range doc.Paths {
+		n += len(item)
+	}
+	return n
+}
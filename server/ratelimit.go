@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"../service/ratelimit"
+	apihandler "./handler"
+	"./route"
+)
+
+// What clients are told apart by for rate limiting, see the ratelimit
+// package
+const (
+	RateLimitByPrincipal = ratelimit.KeyByPrincipal
+	RateLimitByTenant    = ratelimit.KeyByTenant
+	RateLimitByIP        = ratelimit.KeyByIP
+)
+
+// RateLimitKeys lists what clients can be told apart by
+var RateLimitKeys = ratelimit.Keys
+
+// RateLimitOptions limit how often, and how much, each client can call the
+// API. Each request takes the cost of its route from both limits.
+type RateLimitOptions struct {
+	// Requests can be made every Period by each client, all at once or
+	// spread out
+	Requests int
+	Period   time.Duration
+	// DailyQuota is how many requests each client can make in a day, in
+	// UTC, kept in ratelimit.DefaultQuotas. There is no quota if it is 0.
+	DailyQuota int
+	// KeyBy is what clients are told apart by, RateLimitByPrincipal if it
+	// is empty
+	KeyBy string
+	// FailedAuths is how many failed authentications each IP address can
+	// make every Period, before its requests are refused without being
+	// authenticated. There is no such limit if it is 0.
+	FailedAuths int
+	// TrustedProxies are the networks of the proxies in front of the
+	// server, e.g. a gateway. Requests from them are told apart by the
+	// address in X-Forwarded-For they were sent on for, not their own.
+	TrustedProxies []*net.IPNet
+}
+
+var (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+	rateLimitPolicyHeader    = "RateLimit-Policy"
+)
+
+// rateLimitMiddleware returns a middleware that takes the cost of rt from the
+// limits of the client of each request, and refuses it with 429 once either
+// of them is used up. The RateLimit headers describe the limit closest to
+// being used up.
+func rateLimitMiddleware(rt route.Route, limiter *ratelimit.Limiter, opts RateLimitOptions) func(http.Handler) http.Handler {
+	policy := fmt.Sprintf("%d;w=%d", limiter.Limit(), seconds(limiter.Period()))
+	if opts.DailyQuota > 0 {
+		policy += fmt.Sprintf(", %d;w=%d", opts.DailyQuota, seconds(24*time.Hour))
+	}
+	cost := rt.GetCost()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(r, opts)
+
+			d := limiter.Take(key, cost)
+			var err error
+			if !d.Allowed {
+				err = ratelimit.ErrRateLimited
+			} else if opts.DailyQuota > 0 {
+				if q := ratelimit.DefaultQuotas.Take(key, cost, opts.DailyQuota); !q.Allowed {
+					d, err = q, ratelimit.ErrQuotaExceeded
+				} else if q.Remaining < d.Remaining {
+					d = q
+				}
+			}
+
+			h := w.Header()
+			h.Set(rateLimitPolicyHeader, policy)
+			h.Set(rateLimitLimitHeader, strconv.Itoa(d.Limit))
+			h.Set(rateLimitRemainingHeader, strconv.Itoa(d.Remaining))
+			h.Set(rateLimitResetHeader, strconv.Itoa(seconds(d.Reset)))
+			if err != nil {
+				h.Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+				apihandler.WriteError(w, r, http.StatusTooManyRequests, err, false)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey returns the key of the client of r, for opts.KeyBy. Requests
+// without a principal, to public routes or with auth turned off, are told
+// apart by their IP address.
+func rateLimitKey(r *http.Request, opts RateLimitOptions) string {
+	keyBy := opts.KeyBy
+	if keyBy == RateLimitByTenant {
+		if tenant := apihandler.GetTenant(r); tenant != "" {
+			return "tenant:" + tenant
+		}
+	}
+	if keyBy != RateLimitByIP {
+		if p, ok := apihandler.GetPrincipal(r); ok {
+			return p.Kind + ":" + p.ID
+		}
+	}
+	if ip, ok := clientIP(r, opts.TrustedProxies); ok {
+		return "ip:" + ip
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// clientIP returns the IP address of the client that sent r. Requests from
+// trustedProxies are taken to be from the last address in X-Forwarded-For
+// that isn't one of them. It returns false if the client can't be told apart
+// from others, e.g. on a unix socket, or through a proxy that didn't say who
+// for.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+	if !containsIP(trustedProxies, ip) {
+		return ip.String(), true
+	}
+
+	// Each proxy appends the address it got the request from, so the
+	// client is the last one that isn't a trusted proxy
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return "", false
+		}
+		if !containsIP(trustedProxies, ip) {
+			return ip.String(), true
+		}
+	}
+	return "", false
+}
+
+// containsIP returns true if ip is in any of networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds returns d in whole seconds, rounded up so that clients never retry
+// too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/apikey"
+	"../service/ratelimit"
+	apihandler "./handler"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(quotas *ratelimit.Quotas) {
+		clog.LogLevel = 0
+		ratelimit.DefaultQuotas = quotas
+	}(ratelimit.DefaultQuotas)
+	ratelimit.DefaultQuotas = ratelimit.NewQuotas()
+
+	h := newHandler(Options{RateLimit: &RateLimitOptions{Requests: 12, Period: time.Minute, DailyQuota: 20, KeyBy: RateLimitByIP}})
+	do := func(ip, method, route string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, route, nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name              string
+		ip                string
+		method            string
+		route             string
+		expectedCode      int
+		expectedRemaining string
+		expectedReset     string
+		expectedError     string
+	}{
+		{
+			name:              "requests within the limit should be served",
+			ip:                "192.0.2.1",
+			method:            http.MethodGet,
+			route:             "/v1/pets",
+			expectedCode:      http.StatusOK,
+			expectedRemaining: "11",
+			expectedReset:     "5",
+		},
+		{
+			name:              "routes that do a lot of work should cost more",
+			ip:                "192.0.2.1",
+			method:            http.MethodGet,
+			route:             "/v1/pets:export",
+			expectedCode:      http.StatusOK,
+			expectedRemaining: "1",
+			expectedReset:     "55",
+		},
+		{
+			name:              "requests past the limit should be refused",
+			ip:                "192.0.2.1",
+			method:            http.MethodGet,
+			route:             "/v1/pets:export",
+			expectedCode:      http.StatusTooManyRequests,
+			expectedRemaining: "1",
+			expectedReset:     "55",
+			expectedError:     apihandler.CodeRateLimited,
+		},
+		{
+			name:              "other clients should have limits of their own",
+			ip:                "192.0.2.2",
+			method:            http.MethodGet,
+			route:             "/v1/pets:export",
+			expectedCode:      http.StatusOK,
+			expectedRemaining: "2",
+			expectedReset:     "50",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.ip, tt.method, tt.route)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, "12", w.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, tt.expectedRemaining, w.Header().Get("RateLimit-Remaining"))
+			assert.Equal(t, tt.expectedReset, w.Header().Get("RateLimit-Reset"))
+			assert.Equal(t, "12;w=60, 20;w=86400", w.Header().Get("RateLimit-Policy"))
+			if tt.expectedError != "" {
+				assert.Equal(t, "45", w.Header().Get("Retry-After"))
+				var errE apihandler.Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedError, errE.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimit_Quota(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(quotas *ratelimit.Quotas, store *apikey.Store) {
+		clog.LogLevel = 0
+		ratelimit.DefaultQuotas = quotas
+		apikey.DefaultStore = store
+	}(ratelimit.DefaultQuotas, apikey.DefaultStore)
+	ratelimit.DefaultQuotas = ratelimit.NewQuotas()
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("one", "One", "pk_one", []string{"reader"})
+	apikey.DefaultStore.AddStatic("two", "Two", "pk_two", []string{"reader"})
+
+	h := newHandler(Options{
+		Auth:      &AuthOptions{APIKeys: true},
+		RateLimit: &RateLimitOptions{Requests: 100, Period: time.Second, DailyQuota: 3},
+	})
+	do := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/pets", nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// The headers describe the quota once it is closer to being used up
+	for i := 2; i >= 0; i-- {
+		w := do("pk_one")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(i), w.Header().Get("RateLimit-Remaining"))
+	}
+
+	w := do("pk_one")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	var errE apihandler.Error
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+	assert.Equal(t, apihandler.CodeQuotaExceeded, errE.Code)
+
+	// Each API key has a quota of its own
+	assert.Equal(t, http.StatusOK, do("pk_two").Code)
+}
+
+func TestRateLimit_FailedAuth(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+	}(apikey.DefaultStore)
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("one", "One", "pk_one", []string{"reader"})
+
+	_, gateway, _ := net.ParseCIDR("10.0.0.0/8")
+	h := newHandler(Options{
+		Auth: &AuthOptions{APIKeys: true},
+		RateLimit: &RateLimitOptions{
+			Requests:       5,
+			Period:         time.Minute,
+			FailedAuths:    3,
+			TrustedProxies: []*net.IPNet{gateway},
+		},
+	})
+	do := func(addr, forwardedFor, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/pets", nil)
+		r.RemoteAddr = addr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Keys can be guessed as often as failed authentications are allowed
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, do("192.0.2.1:1234", "", "pk_guess").Code)
+	}
+
+	// and no more, not even with the right key, until the limit comes back
+	for _, key := range []string{"pk_guess", "pk_one"} {
+		w := do("192.0.2.1:1234", "", key)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "20", w.Header().Get("Retry-After"))
+		var errE apihandler.Error
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+		assert.Equal(t, apihandler.CodeRateLimited, errE.Code)
+	}
+
+	// Other IP addresses have limits of their own
+	w := do("192.0.2.2:1234", "", "pk_one")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "4", w.Header().Get("RateLimit-Remaining"))
+
+	// Clients behind a trusted proxy are told apart by the address it
+	// forwarded for, which clients can't hide by sending one of their own
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1:1234", "192.0.2.3", "pk_guess").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1234", "192.0.2.3", "pk_guess").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1234", "198.51.100.1, 192.0.2.3", "pk_guess").Code)
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1234", "192.0.2.4, 10.0.0.2", "pk_one").Code)
+
+	// Clients that can't be told apart, through a proxy that didn't say who
+	// for or on a unix socket, don't lock each other out
+	for _, addr := range []string{"10.0.0.1:1234", "@"} {
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusUnauthorized, do(addr, "", "pk_guess").Code)
+		}
+		assert.Equal(t, http.StatusOK, do(addr, "", "pk_one").Code)
+	}
+}
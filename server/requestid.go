@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	apihandler "./handler"
+)
+
+// requestIDPattern is what request IDs set by clients must look like to be
+// kept, so they are safe to log and to echo back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware identifies each request by the ID its client set in
+// the X-Request-ID header, or a new random one if it didn't set a valid one.
+// The ID is sent back in the response, and recorded in the audit log.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(apihandler.RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			var err error
+			if id, err = newRequestID(); err != nil {
+				apihandler.WriteError(w, r, http.StatusInternalServerError, err, true)
+				return
+			}
+		}
+		w.Header().Set(apihandler.RequestIDHeader, id)
+		next.ServeHTTP(w, apihandler.WithRequestID(r, id))
+	})
+}
+
+// newRequestID returns a random 128 bit ID, hex encoded
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate request id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/audit"
+	"../service/pet"
+	apihandler "./handler"
+)
+
+func TestRequestID(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(log *audit.Log) {
+		clog.LogLevel = 0
+		audit.DefaultLog = log
+		pet.ResetData()
+	}(audit.DefaultLog)
+	audit.DefaultLog = audit.NewLog()
+
+	h := newHandler(Options{})
+	do := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(`{"id": 1, "name": "Rex"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if id != "" {
+			r.Header.Set(apihandler.RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// IDs set by clients are kept
+	w := do("checkout-42")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "checkout-42", w.Header().Get(apihandler.RequestIDHeader))
+
+	// Requests without one, or with one that isn't safe to keep, get a new one
+	w = do("")
+	assert.Len(t, w.Header().Get(apihandler.RequestIDHeader), 32)
+	generated := w.Header().Get(apihandler.RequestIDHeader)
+	w = do("not\tsafe")
+	assert.Len(t, w.Header().Get(apihandler.RequestIDHeader), 32)
+	assert.NotEqual(t, generated, w.Header().Get(apihandler.RequestIDHeader))
+
+	// and the changes they make are recorded with it
+	entries := audit.DefaultLog.Query(audit.Filter{})
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "checkout-42", entries[0].RequestID)
+	assert.Equal(t, generated, entries[1].RequestID)
+	assert.Equal(t, apihandler.AnonymousActor, entries[0].Actor)
+}
@@ -3,8 +3,16 @@ package route
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
+	"../../service/apikey"
+	"../../service/audit"
+	"../../service/job"
+	"../../service/pet"
+	"../../service/rbac"
 	"../handler"
+	"../schema"
 )
 
 // Route represents a standard route object
@@ -13,35 +21,548 @@ type Route struct {
 	Version     int
 	Path        string
 	HandlerFunc http.HandlerFunc
+	// Idempotent routes honour the Idempotency-Key header, replaying the
+	// first response for retries of the same request
+	Idempotent bool
+
+	// Name uniquely identifies the route, and is used as the OpenAPI operationId
+	Name string
+	// Summary is a one line description of what the route does
+	Summary string
+	// Params are the query, path and header params the route takes
+	Params []Param
+	// Request is the request body the route takes, if any
+	Request *Body
+	// Responses are the bodies the route responds with, by status code.
+	// Error responses are documented for every route, so they are left out.
+	Responses map[int]Body
+
+	// Deprecation is set for routes that are going away
+	Deprecation *Deprecation
+
+	// Public routes can be called without authenticating
+	Public bool
+	// Permissions are all needed by the principal of a request to call the
+	// route, see the rbac package
+	Permissions []string
+	// Global routes aren't scoped to a tenant, e.g. the ones that manage the
+	// API itself
+	Global bool
+	// Cost is how much of the rate limit and the daily quota of the client a
+	// request takes, 1 if it is 0. Routes that do a lot of work cost more.
+	Cost int
+	// MaxBodyBytes is the largest request body the route takes, the default
+	// of the server if it is 0
+	MaxBodyBytes int64
+	// Timeout is how long reading the request, and writing the response,
+	// of the route can each take, instead of the ReadTimeout and
+	// WriteTimeout of the server, if it isn't 0. Routes that stream many
+	// pets take longer than the others.
+	Timeout time.Duration
+}
+
+// GetCost returns how much of the rate limit a request to the route takes
+func (r Route) GetCost() int {
+	if r.Cost < 1 {
+		return 1
+	}
+	return r.Cost
+}
+
+// bulkCost is the cost of routes that work on many pets at once
+const bulkCost = 10
+
+// Body limits of the routes that take many pets at once
+const (
+	batchMaxBodyBytes  = 16 << 20
+	importMaxBodyBytes = 1 << 30
+)
+
+// streamTimeout is the timeout of the routes that stream many pets in or
+// out, long enough for importMaxBodyBytes at about 1 MB/s
+const streamTimeout = 20 * time.Minute
+
+// Deprecation describes when a route was deprecated, and what replaces it
+type Deprecation struct {
+	// Date is when the route was deprecated
+	Date time.Time
+	// Sunset is when the route will stop working, if known
+	Sunset time.Time
+	// Successor is the version of the API with the route that replaces it
+	Successor int
+}
+
+// Where a Param can be found in the request
+const (
+	InQuery  = "query"
+	InPath   = "path"
+	InHeader = "header"
+)
+
+// Param describes a query, path or header param of a route
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      *schema.Schema
+}
+
+// Body describes a request or response body of a route
+type Body struct {
+	Description string
+	// MediaTypes defaults to application/json
+	MediaTypes []string
+	// Type is a value of the Go type that the body is encoded from
+	Type interface{}
+	// Schema is used instead of Type for bodies that aren't JSON
+	Schema *schema.Schema
 }
 
+// MediaTypeJSON is the default media type for request and response bodies
+var MediaTypeJSON = "application/json"
+
 // GetPattern returns the url match pattern for the route
 func (r Route) GetPattern() string {
 	return fmt.Sprintf("/v%d/%s", r.Version, r.Path)
 }
 
+// GetMediaTypes returns the media types of the body
+func (b Body) GetMediaTypes() []string {
+	if len(b.MediaTypes) == 0 {
+		return []string{MediaTypeJSON}
+	}
+	return b.MediaTypes
+}
+
+// v1Deprecation is for version 1 routes that have a version 2 replacement
+var v1Deprecation = &Deprecation{
+	Date:      time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+	Sunset:    time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+	Successor: 2,
+}
+
+var petIDParam = Param{
+	Name:        "id",
+	In:          InPath,
+	Description: "ID of the pet",
+	Required:    true,
+	Schema:      &schema.Schema{Type: schema.TypeInteger, Format: "int64"},
+}
+
+var jobIDParam = Param{
+	Name:        "id",
+	In:          InPath,
+	Description: "ID of the job",
+	Required:    true,
+	Schema:      schema.String().WithPattern("^[0-9a-f]+$"),
+}
+
+var keyIDParam = Param{
+	Name:        "id",
+	In:          InPath,
+	Description: "ID of the API key",
+	Required:    true,
+	Schema:      schema.String().WithPattern("^[0-9a-z-]+$"),
+}
+
+var limitParam = Param{
+	Name:        "limit",
+	In:          InQuery,
+	Description: "How many pets to return per page",
+	Schema:      schema.Integer().WithMinimum(1).WithMaximum(100).WithDefault(100),
+}
+
+var idempotencyKeyParam = Param{
+	Name:        "Idempotency-Key",
+	In:          InHeader,
+	Description: "Unique key for the request, retries with the same key replay the first response",
+	Schema:      schema.String(),
+}
+
+var exportFormatParam = Param{
+	Name:        "format",
+	In:          InQuery,
+	Description: "Format of the export",
+	Schema:      schema.String().WithEnum(handler.FormatNDJSON, handler.FormatCSV).WithDefault(handler.FormatNDJSON),
+}
+
+var streamBody = Body{
+	MediaTypes: []string{"application/x-ndjson", "text/csv"},
+	Schema:     schema.String(),
+}
+
 var routes = []Route{
 	{
 		Method:      http.MethodGet,
 		Version:     1,
 		Path:        "pets",
 		HandlerFunc: handler.HandleListPets,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "listPets",
+		Summary:     "List all pets, sorted by ID",
+		Params: []Param{
+			limitParam,
+			{
+				Name:        "page",
+				In:          InQuery,
+				Description: "Page number, the x-next response header links to the next page",
+				Schema:      schema.Integer().WithMinimum(1).WithDefault(1),
+			},
+		},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "A page of pets", Type: []pet.Pet{}},
+		},
+		Deprecation: v1Deprecation,
 	},
 	{
 		Method:      http.MethodPost,
 		Version:     1,
 		Path:        "pets",
 		HandlerFunc: handler.HandleCreatePet,
+		Permissions: []string{rbac.PermissionPetsWrite},
+		Idempotent:  true,
+		Name:        "createPet",
+		Summary:     "Create a pet, or replace the pet with the same ID",
+		Params:      []Param{idempotencyKeyParam},
+		Request:     &Body{Type: pet.Pet{}},
+		Responses: map[int]Body{
+			http.StatusCreated: {Description: "The pet was saved"},
+		},
+		Deprecation: v1Deprecation,
 	},
 	{
 		Method:      http.MethodGet,
 		Version:     1,
 		Path:        "pets/{id:[0-9]+}",
 		HandlerFunc: handler.HandleGetPetByID,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "getPetByID",
+		Summary:     "Get a pet by its ID",
+		Params:      []Param{petIDParam},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The pet", Type: pet.Pet{}},
+		},
+		Deprecation: v1Deprecation,
+	},
+	{
+		Method:       http.MethodPost,
+		Version:      1,
+		Path:         "pets:batch",
+		HandlerFunc:  handler.HandleBatchPets,
+		Permissions:  []string{rbac.PermissionPetsWrite},
+		Idempotent:   true,
+		Name:         "batchPets",
+		Cost:         bulkCost,
+		MaxBodyBytes: batchMaxBodyBytes,
+		Summary:      "Create, update and delete pets in bulk",
+		Params: []Param{
+			{
+				Name:        "atomic",
+				In:          InQuery,
+				Description: "Apply all of the operations, or none of them",
+				Schema:      schema.Boolean().WithDefault(false),
+			},
+			idempotencyKeyParam,
+		},
+		Request: &Body{Type: handler.BatchRequest{}},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The result of each operation", Type: handler.BatchResponse{}},
+		},
+	},
+	{
+		Method:       http.MethodPost,
+		Version:      1,
+		Path:         "pets:import",
+		HandlerFunc:  handler.HandleImportPets,
+		Permissions:  []string{rbac.PermissionPetsWrite},
+		Name:         "importPets",
+		Cost:         bulkCost,
+		MaxBodyBytes: importMaxBodyBytes,
+		Timeout:      streamTimeout,
+		Summary:      "Import pets from NDJSON or CSV",
+		Params: []Param{
+			{
+				Name:        "async",
+				In:          InQuery,
+				Description: "Run the import as a background job",
+				Schema:      schema.Boolean().WithDefault(false),
+			},
+		},
+		Request: &streamBody,
+		Responses: map[int]Body{
+			http.StatusOK:       {Description: "The result of the import", Type: handler.ImportResult{}},
+			http.StatusAccepted: {Description: "The import job was started", Type: job.Job{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Version:     1,
+		Path:        "pets:export",
+		HandlerFunc: handler.HandleExportPets,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "exportPets",
+		Cost:        bulkCost,
+		Timeout:     streamTimeout,
+		Summary:     "Export all pets as NDJSON or CSV",
+		Params:      []Param{exportFormatParam},
+		Responses: map[int]Body{
+			http.StatusOK: streamBody,
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Version:     1,
+		Path:        "pets:export",
+		HandlerFunc: handler.HandleExportPetsJob,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "exportPetsJob",
+		Cost:        bulkCost,
+		Summary:     "Start a job that exports all pets as NDJSON or CSV",
+		Params:      []Param{exportFormatParam},
+		Responses: map[int]Body{
+			http.StatusAccepted: {Description: "The export job was started", Type: job.Job{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Version:     1,
+		Path:        "pets:reindex",
+		HandlerFunc: handler.HandleReindexPets,
+		Permissions: []string{rbac.PermissionPetsWrite},
+		Name:        "reindexPets",
+		Cost:        bulkCost,
+		Summary:     "Start a job that rebuilds the pet index",
+		Responses: map[int]Body{
+			http.StatusAccepted: {Description: "The reindex job was started", Type: job.Job{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Version:     1,
+		Path:        "jobs/{id:[0-9a-f]+}",
+		HandlerFunc: handler.HandleGetJob,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "getJob",
+		Summary:     "Get the status of a background job",
+		Params:      []Param{jobIDParam},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The job", Type: job.Job{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Version:     1,
+		Path:        "jobs/{id:[0-9a-f]+}",
+		HandlerFunc: handler.HandleCancelJob,
+		Permissions: []string{rbac.PermissionPetsWrite},
+		Name:        "cancelJob",
+		Summary:     "Cancel a background job",
+		Params:      []Param{jobIDParam},
+		Responses: map[int]Body{
+			http.StatusOK:       {Description: "The job was canceled", Type: job.Job{}},
+			http.StatusAccepted: {Description: "The job is being canceled", Type: job.Job{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Version:     1,
+		Path:        "jobs/{id:[0-9a-f]+}/result",
+		HandlerFunc: handler.HandleGetJobResult,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "getJobResult",
+		Timeout:     streamTimeout,
+		Summary:     "Download the result of a finished background job",
+		Params:      []Param{jobIDParam},
+		Responses: map[int]Body{
+			http.StatusOK: {
+				Description: "The job result",
+				MediaTypes:  []string{"application/octet-stream"},
+				Schema:      schema.Binary(),
+			},
+		},
+	},
+}
+
+// routesV2 are the routes of version 2 of the API. They respond with the
+// richer handler.PetV2, wrapped in an envelope.
+var routesV2 = []Route{
+	{
+		Method:      http.MethodGet,
+		Version:     2,
+		Path:        "pets",
+		HandlerFunc: handler.HandleListPetsV2,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "listPetsV2",
+		Summary:     "List all pets, sorted by ID",
+		Params: []Param{
+			limitParam,
+			{
+				Name:        "page",
+				In:          InQuery,
+				Description: "Page number, links.next in the response links to the next page",
+				Schema:      schema.Integer().WithMinimum(1).WithDefault(1),
+			},
+		},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "A page of pets", Type: handler.PetListEnvelopeV2{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Version:     2,
+		Path:        "pets",
+		HandlerFunc: handler.HandleCreatePetV2,
+		Permissions: []string{rbac.PermissionPetsWrite},
+		Idempotent:  true,
+		Name:        "createPetV2",
+		Summary:     "Create a pet, or replace the pet with the same ID",
+		Params:      []Param{idempotencyKeyParam},
+		Request:     &Body{Type: handler.PetV2{}},
+		Responses: map[int]Body{
+			http.StatusCreated: {Description: "The saved pet", Type: handler.PetEnvelopeV2{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Version:     2,
+		Path:        "pets/{id:[0-9]+}",
+		HandlerFunc: handler.HandleGetPetByIDV2,
+		Permissions: []string{rbac.PermissionPetsRead},
+		Name:        "getPetByIDV2",
+		Summary:     "Get a pet by its ID",
+		Params:      []Param{petIDParam},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The pet", Type: handler.PetEnvelopeV2{}},
+		},
+	},
+}
+
+// adminRoutes manage the API itself, rather than the pets
+var adminRoutes = []Route{
+	{
+		Method:      http.MethodGet,
+		Version:     1,
+		Path:        "admin/keys",
+		HandlerFunc: handler.HandleListAPIKeys,
+		Permissions: []string{rbac.PermissionAdmin},
+		Global:      true,
+		Name:        "listAPIKeys",
+		Summary:     "List the API keys, without their secrets",
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The API keys", Type: []apikey.Key{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Version:     1,
+		Path:        "admin/keys",
+		HandlerFunc: handler.HandleCreateAPIKey,
+		Permissions: []string{rbac.PermissionAdmin},
+		Global:      true,
+		Name:        "createAPIKey",
+		Summary:     "Create an API key",
+		Request:     &Body{Type: handler.CreateAPIKeyRequest{}},
+		Responses: map[int]Body{
+			http.StatusCreated: {Description: "The API key, with its secret", Type: handler.APIKeySecret{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Version:     1,
+		Path:        "admin/keys/{id:[0-9a-z-]+}:rotate",
+		HandlerFunc: handler.HandleRotateAPIKey,
+		Permissions: []string{rbac.PermissionAdmin},
+		Global:      true,
+		Name:        "rotateAPIKey",
+		Summary:     "Give an API key a new secret, the old one stops working",
+		Params:      []Param{keyIDParam},
+		Request:     &Body{Type: handler.RotateAPIKeyRequest{}},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The API key, with its new secret", Type: handler.APIKeySecret{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Version:     1,
+		Path:        "admin/keys/{id:[0-9a-z-]+}",
+		HandlerFunc: handler.HandleRevokeAPIKey,
+		Permissions: []string{rbac.PermissionAdmin},
+		Global:      true,
+		Name:        "revokeAPIKey",
+		Summary:     "Revoke an API key",
+		Params:      []Param{keyIDParam},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "The revoked API key", Type: apikey.Key{}},
+		},
+	},
+}
+
+// auditRoutes read the audit log of the changes made to the pets
+var auditRoutes = []Route{
+	{
+		Method:      http.MethodGet,
+		Version:     1,
+		Path:        "audit",
+		HandlerFunc: handler.HandleListAudit,
+		Permissions: []string{rbac.PermissionAuditRead},
+		Name:        "listAudit",
+		Summary:     "List the changes made to the pets, oldest first",
+		Params: []Param{
+			{
+				Name:        "pet_id",
+				In:          InQuery,
+				Description: "Only list the changes made to the pet with this ID",
+				Schema:      schema.Integer().WithMinimum(1),
+			},
+			{
+				Name:        "actor",
+				In:          InQuery,
+				Description: "Only list the changes made by this actor, e.g. api_key:ops",
+				Schema:      schema.String(),
+			},
+			{
+				Name:        "since",
+				In:          InQuery,
+				Description: "Only list the changes made at or after this time, in RFC 3339",
+				Schema:      &schema.Schema{Type: schema.TypeString, Format: "date-time"},
+			},
+			{
+				Name:        "after",
+				In:          InQuery,
+				Description: "Only list the changes after the one with this seq, the x-next response header links to the next page",
+				Schema:      schema.Integer().WithMinimum(0),
+			},
+			{
+				Name:        "limit",
+				In:          InQuery,
+				Description: "How many changes to return per page",
+				Schema:      schema.Integer().WithMinimum(1).WithMaximum(1000).WithDefault(100),
+			},
+		},
+		Responses: map[int]Body{
+			http.StatusOK: {Description: "A page of audit entries", Type: []audit.Entry{}},
+		},
 	},
 }
 
 // GetRoutes provides all the routes for this server
 func GetRoutes() []Route {
-	return routes
+	var all = append(append([]Route{}, routes...), routesV2...)
+	all = append(all, auditRoutes...)
+	return append(all, adminRoutes...)
+}
+
+// GetVersions returns the versions of the API that routes are in, in order
+func GetVersions(routes []Route) []int {
+	var versions []int
+	var seen = make(map[int]bool)
+	for _, r := range routes {
+		if !seen[r.Version] {
+			seen[r.Version] = true
+			versions = append(versions, r.Version)
+		}
+	}
+	sort.Ints(versions)
+	return versions
 }
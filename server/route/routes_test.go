@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"../../service/rbac"
 )
 
 func TestGetRoutes(t *testing.T) {
@@ -14,7 +16,7 @@ func TestGetRoutes(t *testing.T) {
 	}{
 		{
 			name: "should return all the routes",
-			want: routes,
+			want: append(append(append(append([]Route{}, routes...), routesV2...), auditRoutes...), adminRoutes...),
 		},
 	}
 	for _, tt := range tests {
@@ -25,6 +27,28 @@ func TestGetRoutes(t *testing.T) {
 	}
 }
 
+func TestGetRoutes_Permissions(t *testing.T) {
+	// Routes that need authentication must say what they need, so that a
+	// new route can't be called by every principal by mistake
+	for _, r := range GetRoutes() {
+		if !r.Public {
+			assert.NotEmpty(t, r.Permissions, "route %s needs permissions", r.Name)
+		}
+		for _, perm := range r.Permissions {
+			assert.Contains(t, rbac.Permissions, perm, "route %s", r.Name)
+		}
+	}
+}
+
+func TestGetRoutes_Global(t *testing.T) {
+	// Only routes for admins may reach past the tenant of a request
+	for _, r := range GetRoutes() {
+		if r.Global {
+			assert.Contains(t, r.Permissions, rbac.PermissionAdmin, "route %s", r.Name)
+		}
+	}
+}
+
 func TestRoute_GetPattern(t *testing.T) {
 	type fields struct {
 		Method      string
@@ -61,3 +85,37 @@ func TestRoute_GetPattern(t *testing.T) {
 		})
 	}
 }
+
+func TestGetVersions(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+		want   []int
+	}{
+		{"no routes should have no versions", nil, nil},
+		{"versions should be sorted and unique", []Route{{Version: 2}, {Version: 1}, {Version: 2}}, []int{1, 2}},
+		{"all the routes should be in versions 1 and 2", GetRoutes(), []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GetVersions(tt.routes))
+		})
+	}
+}
+
+func TestRoute_GetCost(t *testing.T) {
+	tests := []struct {
+		name string
+		cost int
+		want int
+	}{
+		{name: "routes should cost 1 by default", cost: 0, want: 1},
+		{name: "routes should cost what they are set to", cost: 10, want: 10},
+		{name: "negative costs should be 1", cost: -5, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Route{Cost: tt.cost}.GetCost())
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema used to describe the API
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// JSON Schema types
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// refPrefix is where named schemas live in the OpenAPI document
+var refPrefix = "#/components/schemas/"
+
+// Integer returns a new integer schema
+func Integer() *Schema {
+	return &Schema{Type: TypeInteger}
+}
+
+// String returns a new string schema
+func String() *Schema {
+	return &Schema{Type: TypeString}
+}
+
+// Boolean returns a new boolean schema
+func Boolean() *Schema {
+	return &Schema{Type: TypeBoolean}
+}
+
+// Binary returns a new schema for raw bytes
+func Binary() *Schema {
+	return &Schema{Type: TypeString, Format: "binary"}
+}
+
+// WithMinimum sets the minimum allowed value
+func (s *Schema) WithMinimum(min float64) *Schema {
+	s.Minimum = &min
+	return s
+}
+
+// WithMaximum sets the maximum allowed value
+func (s *Schema) WithMaximum(max float64) *Schema {
+	s.Maximum = &max
+	return s
+}
+
+// WithDefault sets the value used when none is given
+func (s *Schema) WithDefault(v interface{}) *Schema {
+	s.Default = v
+	return s
+}
+
+// WithEnum sets the allowed values
+func (s *Schema) WithEnum(values ...interface{}) *Schema {
+	s.Enum = values
+	return s
+}
+
+// WithPattern sets the regular expression a string should match
+func (s *Schema) WithPattern(pattern string) *Schema {
+	s.Pattern = pattern
+	return s
+}
+
+// Registry builds schemas for Go types. Named struct types are stored once in
+// the registry and referred to with a $ref.
+type Registry struct {
+	Schemas map[string]*Schema
+	types   map[reflect.Type]string
+}
+
+// NewRegistry creates a new, empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		Schemas: make(map[string]*Schema),
+		types:   make(map[reflect.Type]string),
+	}
+}
+
+// Of returns the schema for the type of v
+func (reg *Registry) Of(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	return reg.ofType(reflect.TypeOf(v))
+}
+
+// Resolve follows a $ref to the schema it refers to
+func (reg *Registry) Resolve(s *Schema) *Schema {
+	if s == nil || s.Ref == "" {
+		return s
+	}
+	return reg.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// rawMessageType holds JSON as it is, so it could be any value
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func (reg *Registry) ofType(t reflect.Type) *Schema {
+	t = indirect(t)
+
+	if t == timeType {
+		return &Schema{Type: TypeString, Format: "date-time"}
+	}
+	if t == rawMessageType {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return Integer()
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: TypeInteger, Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: TypeInteger, Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeNumber}
+	case reflect.String:
+		return String()
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: TypeString, Format: "byte"}
+		}
+		return &Schema{Type: TypeArray, Items: reg.ofType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: TypeObject, AdditionalProperties: reg.ofType(t.Elem())}
+	case reflect.Struct:
+		return reg.ofStruct(t)
+	}
+
+	// Anything else (interfaces, funcs...) can't be described any further
+	return &Schema{}
+}
+
+func (reg *Registry) ofStruct(t reflect.Type) *Schema {
+	// Anonymous structs are described inline
+	if t.Name() == "" {
+		return reg.buildStruct(t)
+	}
+
+	name, exists := reg.types[t]
+	if !exists {
+		name = reg.nameFor(t)
+		reg.types[t] = name
+		// Register before building, so that recursive types terminate
+		reg.Schemas[name] = &Schema{}
+		*reg.Schemas[name] = *reg.buildStruct(t)
+	}
+	return &Schema{Ref: refPrefix + name}
+}
+
+// nameFor picks a unique component name for t, qualifying it with its
+// package name if another type already took the plain name
+func (reg *Registry) nameFor(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := reg.Schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+func (reg *Registry) buildStruct(t reflect.Type) *Schema {
+	s := &Schema{Type: TypeObject, Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// Unexported fields are skipped, apart from embedded structs whose
+		// fields are promoted just like encoding/json does
+		if f.PkgPath != "" && !(f.Anonymous && indirect(f.Type).Kind() == reflect.Struct) {
+			continue
+		}
+
+		name, omitempty, skip := parseJSONTag(f)
+		if skip {
+			continue
+		}
+
+		// Embedded structs without a name have their fields promoted
+		if f.Anonymous && name == "" {
+			embedded := reg.Resolve(reg.ofType(f.Type))
+			for k, v := range embedded.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := reg.ofType(f.Type)
+		if err := applyTag(fs, f.Tag.Get("schema")); err != nil {
+			panic(fmt.Sprintf("invalid schema tag on %s.%s: %v", t.Name(), f.Name, err))
+		}
+		s.Properties[name] = fs
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func parseJSONTag(f reflect.StructField) (name string, omitempty bool, skip bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return parts[0], omitempty, false
+}
+
+// applyTag applies the constraints in a `schema:"..."` struct tag, which is a
+// comma separated list of key=value pairs, to s
+func applyTag(s *Schema, tag string) error {
+	if tag == "" {
+		return nil
+	}
+	for _, part := range strings.Split(tag, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected key=value, got '%s'", part)
+		}
+		key, val := kv[0], kv[1]
+		switch key {
+		case "minimum", "maximum":
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			if key == "minimum" {
+				s.Minimum = &f
+			} else {
+				s.Maximum = &f
+			}
+		case "minLength", "maxLength", "minItems", "maxItems":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			switch key {
+			case "minLength":
+				s.MinLength = &n
+			case "maxLength":
+				s.MaxLength = &n
+			case "minItems":
+				s.MinItems = &n
+			case "maxItems":
+				s.MaxItems = &n
+			}
+		case "pattern":
+			s.Pattern = val
+		case "enum":
+			for _, v := range strings.Split(val, "|") {
+				s.Enum = append(s.Enum, v)
+			}
+		case "description":
+			s.Description = val
+		default:
+			return fmt.Errorf("unknown key '%s'", key)
+		}
+	}
+	return nil
+}
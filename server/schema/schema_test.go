@@ -0,0 +1,129 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPet struct {
+	ID       int64             `json:"id" schema:"minimum=1"`
+	Name     string            `json:"name" schema:"pattern=\\S,maxLength=10"`
+	Tag      string            `json:"tag,omitempty"`
+	Kind     string            `json:"kind,omitempty" schema:"enum=cat|dog"`
+	Born     time.Time         `json:"born"`
+	Friends  []*testPet        `json:"friends,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Internal string            `json:"-"`
+	hidden   string
+}
+
+type testEmbedding struct {
+	testPet
+	Extra bool `json:"extra"`
+}
+
+func TestRegistry_Of(t *testing.T) {
+
+	reg := NewRegistry()
+
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected *Schema
+	}{
+		{
+			name:     "nil should have no schema",
+			input:    nil,
+			expected: nil,
+		},
+		{
+			name:     "int64 should be an int64 integer",
+			input:    int64(1),
+			expected: &Schema{Type: TypeInteger, Format: "int64"},
+		},
+		{
+			name:     "slices should be arrays",
+			input:    []string{},
+			expected: &Schema{Type: TypeArray, Items: String()},
+		},
+		{
+			name:     "raw JSON could be any value",
+			input:    json.RawMessage(`{}`),
+			expected: &Schema{},
+		},
+		{
+			name:     "named structs should be refs",
+			input:    testPet{},
+			expected: &Schema{Ref: "#/components/schemas/testPet"},
+		},
+		{
+			name:     "pointers to structs should be refs",
+			input:    &testPet{},
+			expected: &Schema{Ref: "#/components/schemas/testPet"},
+		},
+		{
+			name:  "anonymous structs should be inline",
+			input: struct{ A bool }{},
+			expected: &Schema{
+				Type:       TypeObject,
+				Properties: map[string]*Schema{"A": Boolean()},
+				Required:   []string{"A"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reg.Of(tt.input)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+
+	// The struct should have been registered once, with its constraints
+	var one = 1.0
+	var ten = 10
+	assert.Equal(t, &Schema{
+		Type: TypeObject,
+		Properties: map[string]*Schema{
+			"id":      {Type: TypeInteger, Format: "int64", Minimum: &one},
+			"name":    {Type: TypeString, Pattern: `\S`, MaxLength: &ten},
+			"tag":     String(),
+			"kind":    {Type: TypeString, Enum: []interface{}{"cat", "dog"}},
+			"born":    {Type: TypeString, Format: "date-time"},
+			"friends": {Type: TypeArray, Items: &Schema{Ref: "#/components/schemas/testPet"}},
+			"labels":  {Type: TypeObject, AdditionalProperties: String()},
+		},
+		Required: []string{"id", "name", "born"},
+	}, reg.Schemas["testPet"])
+	assert.Equal(t, reg.Schemas["testPet"], reg.Resolve(reg.Of(testPet{})))
+
+	// Embedded fields should be promoted
+	embedded := reg.Resolve(reg.Of(testEmbedding{}))
+	assert.Equal(t, 8, len(embedded.Properties))
+	assert.Equal(t, []string{"id", "name", "born", "extra"}, embedded.Required)
+}
+
+func TestApplyTag(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		tag     string
+		isError bool
+	}{
+		{"an empty tag should be OK", "", false},
+		{"known keys should be OK", "minimum=1,maximum=5,minItems=0,description=some text", false},
+		{"a part without a value should error", "minimum", true},
+		{"an unknown key should error", "color=blue", true},
+		{"a bad number should error", "minLength=abc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyTag(&Schema{}, tt.tag)
+			assert.Equal(t, tt.isError, err != nil)
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"../../service/validation"
+)
+
+// FieldError describes why a field of a request failed validation
+type FieldError = validation.FieldError
+
+// Codes for the ways a field can fail validation
+const (
+	CodeRequired    = validation.CodeRequired
+	CodeInvalidType = validation.CodeInvalidType
+	CodeInvalidJSON = validation.CodeInvalidJSON
+	CodeMultiple    = validation.CodeMultiple
+	CodeMinimum     = validation.CodeMinimum
+	CodeMaximum     = validation.CodeMaximum
+	CodeMinLength   = validation.CodeMinLength
+	CodeMaxLength   = validation.CodeMaxLength
+	CodePattern     = validation.CodePattern
+	CodeEnum        = validation.CodeEnum
+	CodeMinItems    = validation.CodeMinItems
+	CodeMaxItems    = validation.CodeMaxItems
+)
+
+// paramNames are the names of the params in the messages for each code
+var paramNames = map[string]string{
+	CodeInvalidType: "type",
+	CodeMinimum:     "minimum",
+	CodeMaximum:     "maximum",
+	CodeMinLength:   "minLength",
+	CodeMaxLength:   "maxLength",
+	CodePattern:     "pattern",
+	CodeEnum:        "enum",
+	CodeMinItems:    "minItems",
+	CodeMaxItems:    "maxItems",
+}
+
+// InBody is where the fields of a request body are
+const InBody = "body"
+
+// ParseParam converts the raw string value of a param into the type its
+// schema asks for, and validates it
+func (reg *Registry) ParseParam(s *Schema, name, in, raw string) (interface{}, []FieldError) {
+	var v interface{}
+	var err error
+
+	s = reg.Resolve(s)
+	switch s.Type {
+	case TypeInteger:
+		v, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("must be an integer")
+		}
+	case TypeNumber:
+		v, err = strconv.ParseFloat(raw, 64)
+		if err != nil {
+			err = fmt.Errorf("must be a number")
+		}
+	case TypeBoolean:
+		v, err = strconv.ParseBool(raw)
+		if err != nil {
+			err = fmt.Errorf("must be a boolean")
+		}
+	default:
+		v = raw
+	}
+	if err != nil {
+		return nil, []FieldError{{
+			Field:   name,
+			In:      in,
+			Code:    CodeInvalidType,
+			Message: err.Error(),
+			Params:  map[string]interface{}{paramNames[CodeInvalidType]: s.Type},
+		}}
+	}
+
+	errs := reg.Validate(s, v, name)
+	for i := range errs {
+		errs[i].In = in
+	}
+	return v, errs
+}
+
+// ValidateJSON decodes data and validates it against s
+func (reg *Registry) ValidateJSON(s *Schema, data []byte) []FieldError {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return []FieldError{{In: InBody, Code: CodeInvalidJSON, Message: err.Error()}}
+	}
+
+	errs := reg.Validate(s, v, "")
+	for i := range errs {
+		errs[i].In = InBody
+	}
+	return errs
+}
+
+// Validate checks v, a value decoded from JSON with UseNumber or parsed by
+// ParseParam, against s. Field is the path to v, used in the errors.
+func (reg *Registry) Validate(s *Schema, v interface{}, field string) []FieldError {
+	s = reg.Resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	var errs []FieldError
+	// fail adds an error, with the constraint it broke as its param
+	var fail = func(code string, param interface{}, format string, args ...interface{}) []FieldError {
+		fe := FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)}
+		if param != nil {
+			fe.Params = map[string]interface{}{paramNames[code]: param}
+		}
+		return append(errs, fe)
+	}
+
+	if v == nil {
+		if s.Type == "" {
+			return nil
+		}
+		return fail(CodeInvalidType, s.Type, "must be %s %s", article(s.Type), s.Type)
+	}
+
+	switch s.Type {
+	case TypeObject:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fail(CodeInvalidType, s.Type, "must be an object")
+		}
+		errs = append(errs, reg.validateObject(s, obj, field)...)
+
+	case TypeArray:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fail(CodeInvalidType, s.Type, "must be an array")
+		}
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			errs = fail(CodeMinItems, *s.MinItems, "must have at least %d item(s)", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			errs = fail(CodeMaxItems, *s.MaxItems, "must have at most %d item(s)", *s.MaxItems)
+		}
+		for i, item := range arr {
+			errs = append(errs, reg.Validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+
+	case TypeString:
+		str, ok := v.(string)
+		if !ok {
+			return fail(CodeInvalidType, s.Type, "must be a string")
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			errs = fail(CodeMinLength, *s.MinLength, "must be at least %d character(s) long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			errs = fail(CodeMaxLength, *s.MaxLength, "must be at most %d character(s) long", *s.MaxLength)
+		}
+		if s.Pattern != "" && !matchPattern(s.Pattern, str) {
+			errs = fail(CodePattern, s.Pattern, "must match the pattern %s", s.Pattern)
+		}
+
+	case TypeInteger, TypeNumber:
+		f, ok := toFloat(v, s.Type == TypeInteger)
+		if !ok {
+			return fail(CodeInvalidType, s.Type, "must be %s %s", article(s.Type), s.Type)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			errs = fail(CodeMinimum, *s.Minimum, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			errs = fail(CodeMaximum, *s.Maximum, "must be at most %v", *s.Maximum)
+		}
+
+	case TypeBoolean:
+		if _, ok := v.(bool); !ok {
+			return fail(CodeInvalidType, s.Type, "must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		var values []string
+		for _, e := range s.Enum {
+			values = append(values, fmt.Sprint(e))
+		}
+		errs = fail(CodeEnum, strings.Join(values, ", "), "must be one of: %s", strings.Join(values, ", "))
+	}
+
+	return errs
+}
+
+func (reg *Registry) validateObject(s *Schema, obj map[string]interface{}, field string) []FieldError {
+	var errs []FieldError
+	for _, name := range s.Required {
+		if _, exists := obj[name]; !exists {
+			errs = append(errs, FieldError{Field: validation.Join(field, name), Code: CodeRequired, Message: "is required"})
+		}
+	}
+
+	// Go through the properties in order, so the errors are too
+	var names []string
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ps, exists := s.Properties[name]; exists {
+			errs = append(errs, reg.Validate(ps, obj[name], validation.Join(field, name))...)
+			continue
+		}
+		if s.AdditionalProperties != nil {
+			errs = append(errs, reg.Validate(s.AdditionalProperties, obj[name], validation.Join(field, name))...)
+		}
+	}
+	return errs
+}
+
+// toFloat converts a number, as decoded by ValidateJSON or parsed by
+// ParseParam, to a float64. If integer is true it also makes sure that the
+// number has no fractional part.
+func toFloat(v interface{}, integer bool) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		if integer {
+			i, err := n.Int64()
+			return float64(i), err == nil
+		}
+		f, err := n.Float64()
+		return f, err == nil
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, !integer || n == math.Trunc(n)
+	}
+	return 0, false
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func article(word string) string {
+	if strings.ContainsAny(word[:1], "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+// patterns caches the compiled regular expressions for schema patterns
+var patterns sync.Map
+
+func matchPattern(pattern, s string) bool {
+	re, ok := patterns.Load(pattern)
+	if !ok {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			// A bad pattern is a bug in the route definitions, not the request
+			panic(fmt.Sprintf("invalid schema pattern %s: %v", pattern, err))
+		}
+		re, _ = patterns.LoadOrStore(pattern, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(s)
+}
@@ -0,0 +1,130 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type params = map[string]interface{}
+
+func TestRegistry_ValidateJSON(t *testing.T) {
+
+	reg := NewRegistry()
+	s := reg.Of(testPet{})
+
+	tests := []struct {
+		name     string
+		body     string
+		expected []FieldError
+	}{
+		{
+			name: "valid body should have no errors",
+			body: `{"id": 1, "name": "Tommy", "born": "2020-01-01T00:00:00Z", "kind": "dog"}`,
+		},
+		{
+			name:     "malformed JSON should be an error",
+			body:     `{"id": 1`,
+			expected: []FieldError{{In: InBody, Code: CodeInvalidJSON, Message: "unexpected EOF"}},
+		},
+		{
+			name:     "wrong top level type should be an error",
+			body:     `[]`,
+			expected: []FieldError{{In: InBody, Code: CodeInvalidType, Message: "must be an object", Params: params{"type": "object"}}},
+		},
+		{
+			name: "all the invalid fields should be reported",
+			body: `{"id": 0, "name": " ", "kind": "fish"}`,
+			expected: []FieldError{
+				{Field: "born", In: InBody, Code: CodeRequired, Message: "is required"},
+				{Field: "id", In: InBody, Code: CodeMinimum, Message: "must be at least 1", Params: params{"minimum": 1.0}},
+				{Field: "kind", In: InBody, Code: CodeEnum, Message: "must be one of: cat, dog", Params: params{"enum": "cat, dog"}},
+				{Field: "name", In: InBody, Code: CodePattern, Message: "must match the pattern \\S", Params: params{"pattern": "\\S"}},
+			},
+		},
+		{
+			name: "fractional and string ids should not be integers",
+			body: `{"id": 1.5, "name": "Tommy", "born": "2020-01-01T00:00:00Z", "friends": [{"id": "2", "name": "Tiger", "born": "2020-01-01T00:00:00Z"}]}`,
+			expected: []FieldError{
+				{Field: "friends[0].id", In: InBody, Code: CodeInvalidType, Message: "must be an integer", Params: params{"type": "integer"}},
+				{Field: "id", In: InBody, Code: CodeInvalidType, Message: "must be an integer", Params: params{"type": "integer"}},
+			},
+		},
+		{
+			name:     "strings should be checked for length",
+			body:     `{"id": 1, "name": "Tommy Tommy Tommy", "born": "2020-01-01T00:00:00Z"}`,
+			expected: []FieldError{{Field: "name", In: InBody, Code: CodeMaxLength, Message: "must be at most 10 character(s) long", Params: params{"maxLength": 10}}},
+		},
+		{
+			name:     "map values should be checked",
+			body:     `{"id": 1, "name": "Tommy", "born": "2020-01-01T00:00:00Z", "labels": {"a": 1}}`,
+			expected: []FieldError{{Field: "labels.a", In: InBody, Code: CodeInvalidType, Message: "must be a string", Params: params{"type": "string"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, reg.ValidateJSON(s, []byte(tt.body)))
+		})
+	}
+}
+
+func TestRegistry_ParseParam(t *testing.T) {
+
+	reg := NewRegistry()
+
+	tests := []struct {
+		name          string
+		schema        *Schema
+		raw           string
+		expectedValue interface{}
+		expectedErrs  []FieldError
+	}{
+		{
+			name:          "integers should be parsed",
+			schema:        Integer().WithMinimum(1).WithMaximum(100),
+			raw:           "10",
+			expectedValue: int64(10),
+		},
+		{
+			name:         "integers out of range should be an error",
+			schema:       Integer().WithMinimum(1).WithMaximum(100),
+			raw:          "101",
+			expectedErrs: []FieldError{{Field: "limit", In: "query", Code: CodeMaximum, Message: "must be at most 100", Params: params{"maximum": 100.0}}},
+		},
+		{
+			name:         "non integers should be an error",
+			schema:       Integer(),
+			raw:          "abc",
+			expectedErrs: []FieldError{{Field: "limit", In: "query", Code: CodeInvalidType, Message: "must be an integer", Params: params{"type": "integer"}}},
+		},
+		{
+			name:          "booleans should be parsed",
+			schema:        Boolean(),
+			raw:           "true",
+			expectedValue: true,
+		},
+		{
+			name:          "strings in the enum should be allowed",
+			schema:        String().WithEnum("ndjson", "csv"),
+			raw:           "csv",
+			expectedValue: "csv",
+		},
+		{
+			name:         "strings not in the enum should be an error",
+			schema:       String().WithEnum("ndjson", "csv"),
+			raw:          "xml",
+			expectedErrs: []FieldError{{Field: "limit", In: "query", Code: CodeEnum, Message: "must be one of: ndjson, csv", Params: params{"enum": "ndjson, csv"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, errs := reg.ParseParam(tt.schema, "limit", "query", tt.raw)
+			assert.Equal(t, tt.expectedErrs, errs)
+			if tt.expectedErrs == nil {
+				assert.Equal(t, tt.expectedValue, v)
+			}
+		})
+	}
+}
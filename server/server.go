@@ -1,57 +1,494 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/teejays/clog"
 
+	"../service/idempotency"
+	"../service/job"
+	"../service/pet"
+	"../service/ratelimit"
+	"./docs"
+	apihandler "./handler"
+	"./openapi"
 	"./route"
+	"./schema"
 )
 
-// StartServer initializes and runs the HTTP server
-func StartServer(addr string, port int) error {
+var eventDispatchInterval = time.Second
 
-	h := handler()
-	http.Handle("/", h)
+// ShutdownTimeout is how long in-flight requests and background jobs are
+// given to finish once the server is asked to stop
+var ShutdownTimeout = 30 * time.Second
 
-	// Start the server
-	clog.Infof("Listenining on: %s:%d", addr, port)
-	return http.ListenAndServe(fmt.Sprintf("%s:%d", addr, port), nil)
+// StorageFlushInterval is how often changes to the pets are written to
+// storage, for backends that write them
+var StorageFlushInterval = 5 * time.Second
 
+// MaxBodyBytes is the largest request body routes take, unless they set
+// their own limit
+var MaxBodyBytes int64 = 1 << 20
+
+// Timeouts of the http.Server, see its fields of the same names
+var (
+	ReadHeaderTimeout = 10 * time.Second
+	ReadTimeout       = time.Minute
+	WriteTimeout      = 2 * time.Minute
+	IdleTimeout       = 2 * time.Minute
+)
+
+// Options configure a Server. Zero values use the package defaults, e.g.
+// ReadTimeout.
+type Options struct {
+	// Prefix is the path the API is mounted under, e.g. /pets-api, when it
+	// is embedded in another app. The routes, the documents and the links in
+	// responses are all under it.
+	Prefix string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// IdempotencyWindow is how long responses are kept for replay
+	IdempotencyWindow time.Duration
+	// StorageFlushInterval is how often changes to the pets are written to
+	// storage
+	StorageFlushInterval time.Duration
+
+	// TLS is set to serve HTTPS
+	TLS *TLSOptions
+	// H2C serves HTTP/2 without TLS as well as HTTP/1, to clients that know
+	// the server supports it, e.g. other services in the same network
+	H2C bool
+	// CompressionMinSize is the smallest response, in bytes, that is
+	// compressed
+	CompressionMinSize int
+	// MaxBodyBytes is the largest request body routes take, unless they
+	// set their own limit. RouteMaxBodyBytes overrides the limit of routes
+	// by their name.
+	MaxBodyBytes      int64
+	RouteMaxBodyBytes map[string]int64
+
+	// Auth is set to only let authenticated requests through, apart from
+	// public routes
+	Auth *AuthOptions
+	// Tenancy is set to scope every request to a tenant, apart from public
+	// and global routes
+	Tenancy *TenancyOptions
+	// RateLimit is set to limit how often, and how much, each client can
+	// call the API
+	RateLimit *RateLimitOptions
+}
+
+// withDefaults returns opts with its zero values set to the package defaults
+func (opts Options) withDefaults() Options {
+	opts.Prefix = strings.TrimSuffix(opts.Prefix, "/")
+	if opts.Prefix != "" && !strings.HasPrefix(opts.Prefix, "/") {
+		opts.Prefix = "/" + opts.Prefix
+	}
+	setDefault(&opts.ReadHeaderTimeout, ReadHeaderTimeout)
+	setDefault(&opts.ReadTimeout, ReadTimeout)
+	setDefault(&opts.WriteTimeout, WriteTimeout)
+	setDefault(&opts.IdleTimeout, IdleTimeout)
+	setDefault(&opts.IdempotencyWindow, IdempotencyWindow)
+	setDefault(&opts.StorageFlushInterval, StorageFlushInterval)
+	if opts.CompressionMinSize == 0 {
+		opts.CompressionMinSize = CompressionMinSize
+	}
+	if opts.MaxBodyBytes == 0 {
+		opts.MaxBodyBytes = MaxBodyBytes
+	}
+	return opts
+}
+
+func setDefault(d *time.Duration, def time.Duration) {
+	if *d == 0 {
+		*d = def
+	}
+}
+
+// Server is an instance of the API. It can serve requests itself with Serve,
+// or be mounted in another app with Handler. Either way, Shutdown must be
+// called once it is no longer needed.
+//
+// The pets, their events and background jobs are shared by every Server in
+// the process. The work done in the background for them starts with the first
+// Server, and stops when the last one is shut down.
+type Server struct {
+	opts    Options
+	handler http.Handler
+	srv     *http.Server
+
+	lock     sync.Mutex
+	listener net.Listener
+	// served gets the result of Serve, for servers started with Start
+	served chan error
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// New creates a Server with opts
+func New(opts Options) *Server {
+	opts = opts.withDefaults()
+	s := &Server{
+		opts:    opts,
+		handler: newHandler(opts),
+	}
+	s.srv = &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		Protocols:         new(http.Protocols),
+	}
+	s.srv.Protocols.SetHTTP1(true)
+	s.srv.Protocols.SetHTTP2(true)
+	s.srv.Protocols.SetUnencryptedHTTP2(opts.H2C)
+	startBackground(opts)
+	return s
+}
+
+// Handler returns the handler that serves the API, for mounting it in
+// another app. Requests must keep the prefix in their path.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Serve accepts connections on l and serves the API on them, until Shutdown
+// is called, in which case it returns nil. With TLS options, it serves HTTPS,
+// and fails straight away if the certificates can't be loaded.
+func (s *Server) Serve(l net.Listener) error {
+	var reloader *certReloader
+	if s.opts.TLS != nil {
+		var err error
+		reloader, err = newCertReloader(*s.opts.TLS)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	s.listener = l
+	s.lock.Unlock()
+
+	var err error
+	if reloader != nil {
+		clog.Infof("Listenining on: https://%s%s", l.Addr(), s.opts.Prefix)
+		s.srv.TLSConfig = reloader.config()
+		err = s.srv.ServeTLS(l, "", "")
+	} else {
+		clog.Infof("Listenining on: %s%s", l.Addr(), s.opts.Prefix)
+		err = s.srv.Serve(l)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Addr returns the address the server is listening on, or nil if it is
+// not serving
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server gracefully. It stops accepting requests and
+// waits for the in-flight ones. If it is the last Server, it then waits for
+// background jobs, before delivering the pending events and writing the pets
+// to storage. Jobs still running when ctx is done are canceled. It is safe to
+// call more than once.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		var errs []error
+		if err := s.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("requests did not finish in time: %w", err))
+		}
+		if err := stopBackground(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		s.shutdownErr = errors.Join(errs...)
+	})
+	return s.shutdownErr
+}
+
+// StartServer runs the HTTP server until it fails, or the process is
+// interrupted or terminated, in which case it shuts down gracefully
+func StartServer(listen ListenOptions, opts Options) error {
+	s, err := Start(listen, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var serveErr error
+	select {
+	case serveErr = <-s.served:
+	case <-ctx.Done():
+		clog.Infof("Server: shutting down, waiting up to %s for requests and jobs to finish", ShutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return serveErr
+}
+
+// Start listens as set in listen, and serves the API with opts in the
+// background until Shutdown is called, see Listen
+func Start(listen ListenOptions, opts Options) (*Server, error) {
+	// Fail before listening if the certificates can't be loaded
+	if opts.TLS != nil {
+		if _, err := newCertReloader(*opts.TLS); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := Listen(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	s := New(opts)
+	s.listener = l
+	s.served = make(chan error, 1)
+	go func() {
+		s.served <- s.Serve(l)
+	}()
+	return s, nil
+}
+
+// background is the work done for all the Servers in the process
+var background struct {
+	sync.Mutex
+	servers      int
+	dispatcher   *pet.Dispatcher
+	stopFlushing func()
+}
+
+// startBackground starts the background work for a new Server, if it isn't
+// running already
+func startBackground(opts Options) {
+	background.Lock()
+	defer background.Unlock()
+
+	background.servers++
+	if background.servers > 1 {
+		return
+	}
+
+	// The runner is stopped along with the last Server, so a new one is
+	// needed if there was one before
+	if job.DefaultRunner.IsShutdown() {
+		job.DefaultRunner = job.NewRunner(job.DefaultWorkers, job.DefaultQueueSize)
+	}
+
+	// Drain pet change events from the outbox. Nothing consumes them yet,
+	// so they are only logged.
+	background.dispatcher = pet.NewDispatcher(logEvent, eventDispatchInterval)
+	background.dispatcher.Start()
+
+	// Write changes to the pets to storage
+	background.stopFlushing = startFlushing(opts.StorageFlushInterval)
+}
+
+// stopBackground stops the background work once the last Server is shut down
+func stopBackground(ctx context.Context) error {
+	background.Lock()
+	defer background.Unlock()
+
+	background.servers--
+	if background.servers > 0 {
+		return nil
+	}
+
+	// Jobs change pets, so they finish before the changes are saved
+	var err error
+	if jobErr := job.DefaultRunner.Shutdown(ctx); jobErr != nil {
+		err = fmt.Errorf("background jobs did not finish in time: %w", jobErr)
+	}
+	background.dispatcher.Stop()
+	background.stopFlushing()
+	return err
 }
 
-func handler() http.Handler {
-	// Get all the routes
+// newHandler builds the handler that serves the API
+func newHandler(opts Options) http.Handler {
+	opts = opts.withDefaults()
+
+	// Get all the routes, along with the one that documents them
 	routes := route.GetRoutes()
+	docRoute, err := openapi.NewRoute(routes, opts.Prefix)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to generate the OpenAPI document: %v", err))
+	}
+	routes = append(routes, docRoute)
+
+	// The API explorer reads the OpenAPI document
+	docsHandler, err := docs.Handler(opts.Prefix + docRoute.GetPattern())
+	if err != nil {
+		panic(fmt.Sprintf("Failed to set up the API explorer: %v", err))
+	}
 
 	// Start the router
 	m := mux.NewRouter()
+	m.NotFoundHandler = http.HandlerFunc(apihandler.HandleNotFound)
+	m.MethodNotAllowedHandler = http.HandlerFunc(apihandler.HandleMethodNotAllowed)
 
 	// Set up middlewares
+	m.Use(requestIDMiddleware)
+	m.Use(prefixMiddleware(opts.Prefix))
+	m.Use(clientIdentityMiddleware)
+	m.Use(compressMiddleware(opts.CompressionMinSize))
 	m.Use(loggerMiddleware)
 	m.Use(setHeaderMiddleware)
 
+	// Responses to idempotent routes are kept here for replay
+	idempotencyStore := idempotency.NewStore(opts.IdempotencyWindow)
+
+	// Schemas of the request bodies, for validation
+	reg := schema.NewRegistry()
+
+	// Every route takes from the same limits of a client. Requests that fail
+	// to authenticate have no principal to be limited by, so they are
+	// limited by IP address, with a limit of their own.
+	var limiter, failedAuths *ratelimit.Limiter
+	var trustedProxies []*net.IPNet
+	if opts.RateLimit != nil {
+		limiter = ratelimit.NewLimiter(opts.RateLimit.Requests, opts.RateLimit.Period)
+		if opts.RateLimit.FailedAuths > 0 {
+			failedAuths = ratelimit.NewLimiter(opts.RateLimit.FailedAuths, opts.RateLimit.Period)
+		}
+		trustedProxies = opts.RateLimit.TrustedProxies
+	}
+
 	// Range over routes and set them up
 	for _, r := range routes {
-		m.HandleFunc(r.GetPattern(), r.HandlerFunc).
+		var h http.Handler = r.HandlerFunc
+		if r.Idempotent {
+			h = idempotencyMiddleware(idempotencyStore)(h)
+		}
+		// Validate first, so that invalid requests don't take up idempotency keys
+		h = validationMiddleware(r, reg)(h)
+		if r.Deprecation != nil {
+			h = deprecationMiddleware(r)(h)
+		}
+		h = bodyLimitMiddleware(getMaxBodyBytes(r, opts))(h)
+		// Limit once the client is known, before any work is done
+		if limiter != nil {
+			h = rateLimitMiddleware(r, limiter, *opts.RateLimit)(h)
+		}
+		// The tenant is resolved once the principal is known
+		if opts.Tenancy != nil {
+			h = tenantMiddleware(r, *opts.Tenancy)(h)
+		}
+		// Authenticate first, so that anonymous requests learn nothing
+		if opts.Auth != nil {
+			h = authMiddleware(r, *opts.Auth, failedAuths, trustedProxies)(h)
+		}
+		// Routes that stream take longer than the server gives others
+		if r.Timeout > 0 {
+			h = timeoutMiddleware(r.Timeout)(h)
+		}
+		m.Handle(opts.Prefix+r.GetPattern(), h).
 			Methods(r.Method)
 	}
+	m.Handle(opts.Prefix+docs.Path, docsHandler).
+		Methods(http.MethodGet)
+
+	// Requests can pick the version with their Accept header instead of
+	// the path, so the version is worked out before routing
+	return negotiateVersion(m, opts.Prefix, route.GetVersions(routes))
+}
+
+// startFlushing flushes the pets to storage, along with the quotas, every
+// interval, until the returned func is called, which flushes them one last
+// time
+func startFlushing(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-stop:
+				flush()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
 
-	return m
+func flush() {
+	if err := pet.Flush(); err != nil {
+		clog.Errorf("Server: could not write the pets to storage: %v", err)
+	}
+	if err := ratelimit.DefaultQuotas.Save(); err != nil {
+		clog.Errorf("Server: could not save the quotas: %v", err)
+	}
+}
+
+// logEvent is a pet.PublishFunc that logs the event
+func logEvent(e pet.Event) error {
+	clog.Debugf("Server: pet event %s (%s) for pet %d", e.ID, e.Type, e.PetID)
+	return nil
 }
 
 // loggerMiddleware is a http.Handler middleware function that logs any request received
 func loggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Log the request
-		clog.Debugf("Server: HTTP request received for %s %s", r.Method, r.URL.Path)
+		if id, ok := apihandler.GetClientIdentity(r); ok {
+			clog.Debugf("Server: HTTP request %s received for %s %s from %s", apihandler.GetRequestID(r), r.Method, r.URL.Path, id.Subject)
+		} else {
+			clog.Debugf("Server: HTTP request %s received for %s %s", apihandler.GetRequestID(r), r.Method, r.URL.Path)
+		}
 		// Call the next handler
 		next.ServeHTTP(w, r)
 	})
 }
 
+// prefixMiddleware returns a middleware that lets handlers know the path the
+// API is mounted under, for the links in their responses
+func prefixMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, apihandler.WithPrefix(r, prefix))
+		})
+	}
+}
+
 // setHeaderMiddleware sets the header for the response
 func setHeaderMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -2,15 +2,24 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/teejays/clog"
 
+	"../service/job"
 	"../service/pet"
 )
 
@@ -23,7 +32,7 @@ func TestRouting(t *testing.T) {
 		pet.ResetData()
 	}()
 
-	h := handler()
+	h := newHandler(Options{})
 	srv := httptest.NewServer(h)
 	defer srv.Close()
 
@@ -67,6 +76,26 @@ func TestRouting(t *testing.T) {
 			func() { pet.PopulateMockPets() },
 			func() { pet.ResetData() },
 		},
+		{
+			"unknown route",
+			http.MethodGet,
+			"/v1/cats",
+			``,
+			http.StatusNotFound,
+			`{"type":"urn:petsapi:problem:NOT_FOUND","title":"Not found","status":404,"detail":"no route matches the request path","instance":"/v1/cats","code":"NOT_FOUND"}`,
+			nil,
+			nil,
+		},
+		{
+			"batch pets",
+			http.MethodPost,
+			"/v1/pets:batch",
+			`{"operations": [{"op": "delete", "id": 1}]}`,
+			http.StatusOK,
+			`{"results":[{"index":0,"status":204}]}`,
+			func() { pet.PopulateMockPets() },
+			func() { pet.ResetData() },
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -106,3 +135,346 @@ func TestRouting(t *testing.T) {
 	}
 
 }
+
+func TestOpenAPIDocument(t *testing.T) {
+
+	h := newHandler(Options{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json; charset=UTF-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "3.1.0", doc.OpenAPI)
+	assert.NotNil(t, doc.Paths["/v1/pets"])
+	assert.NotNil(t, doc.Paths["/v2/pets"])
+}
+
+func TestDocs(t *testing.T) {
+
+	h := newHandler(Options{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=UTF-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "/v1/openapi.json")
+}
+
+// signalingBody is a request body that closes read once it is first read
+type signalingBody struct {
+	io.ReadCloser
+	read chan struct{}
+	once sync.Once
+}
+
+func (b *signalingBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.read) })
+	return b.ReadCloser.Read(p)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		clog.LogLevel = 0
+		pet.CloseFile()
+		pet.ResetData()
+		os.RemoveAll(dir)
+	}()
+
+	var path = filepath.Join(dir, "pets.json")
+	if err := pet.OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Know when the handler starts reading the body of a request, and when
+	// shutting down has started
+	s := New(Options{})
+	reading := make(chan struct{})
+	handler := s.srv.Handler
+	s.srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = &signalingBody{ReadCloser: r.Body, read: reading}
+		handler.ServeHTTP(w, r)
+	})
+	shuttingDown := make(chan struct{})
+	s.srv.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	l, err := Listen(ListenOptions{Addr: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	var url = fmt.Sprintf("http://%s/v1/pets", l.Addr())
+
+	// Start a request, but hold back the end of its body
+	body, bodyWriter := io.Pipe()
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(url, "application/json", body)
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- resp
+	}()
+	bodyWriter.Write([]byte(`{"id": 1, `))
+	<-reading
+
+	// Shutting down waits for the request in flight
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	<-shuttingDown
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown should wait for the request in flight")
+	default:
+	}
+
+	bodyWriter.Write([]byte(`"name": "Tommy"}`))
+	bodyWriter.Close()
+
+	resp := <-responses
+	if resp != nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	assert.NoError(t, <-shutdown)
+
+	// New requests are refused
+	_, err = http.Get(url)
+	assert.Error(t, err)
+
+	// The pet saved by the request is written to storage, once its event
+	// was delivered
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"pets": [{"id": 1, "name": "Tommy"}], "outbox": []}`, string(data))
+
+	// Shutting down again does nothing
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
+
+func TestStart_AddressInUse(t *testing.T) {
+
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	var addr = srv.Listener.Addr().(*net.TCPAddr)
+	_, err := Start(ListenOptions{Addr: "127.0.0.1", Port: addr.Port}, Options{})
+	assert.Error(t, err)
+}
+
+func TestNew_Embedded(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy"})
+
+	s := New(Options{Prefix: "/pets-api/"})
+	defer s.Shutdown(context.Background())
+
+	// A gateway with the pets API mounted under a prefix
+	gateway := http.NewServeMux()
+	gateway.Handle("/pets-api/", s.Handler())
+	gateway.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		accept       string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "routes should be under the prefix",
+			path:         "/pets-api/v1/pets/1",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"name":"Tommy"}`,
+		},
+		{
+			name:         "links should include the prefix",
+			path:         "/pets-api/v2/pets/1",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"data":{"id":1,"name":"Tommy","links":{"self":"/pets-api/v2/pets/1"}}}`,
+		},
+		{
+			name:         "the version should be negotiated under the prefix",
+			path:         "/pets-api/pets/1",
+			accept:       "application/json; version=2",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"data":{"id":1,"name":"Tommy","links":{"self":"/pets-api/v2/pets/1"}}}`,
+		},
+		{
+			name:         "unknown routes under the prefix should be not found",
+			path:         "/pets-api/v1/cats",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"type":"urn:petsapi:problem:NOT_FOUND","title":"Not found","status":404,"detail":"no route matches the request path","instance":"/pets-api/v1/cats","code":"NOT_FOUND"}`,
+		},
+		{
+			name:         "paths outside the prefix should be left to the gateway",
+			path:         "/v1/pets/1",
+			expectedCode: http.StatusTeapot,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, string(body))
+			}
+		})
+	}
+
+	// The documents know about the prefix too
+	resp, err := http.Get(srv.URL + "/pets-api/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "/pets-api/v1/openapi.json")
+
+	resp, err = http.Get(srv.URL + "/pets-api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	}
+	json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	assert.Len(t, doc.Servers, 1)
+	assert.Equal(t, "/pets-api", doc.Servers[0].URL)
+
+	// and so do the links to jobs
+	resp, err = http.Post(srv.URL+"/pets-api/v1/pets:reindex", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	assert.Contains(t, location, "/pets-api/v1/jobs/")
+	resp, err = http.Get(srv.URL + location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServer_Serve(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	// More than one server can run at once
+	var servers = []*Server{New(Options{}), New(Options{Prefix: "/other"})}
+	var served = make(chan error, len(servers))
+	for _, s := range servers {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(s *Server) {
+			served <- s.Serve(l)
+		}(s)
+	}
+
+	// Wait for both to be listening
+	for _, s := range servers {
+		for s.Addr() == nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/v1/pets", servers[0].Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/other/v1/pets", servers[1].Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Jobs keep running until the last server is shut down
+	assert.NoError(t, servers[0].Shutdown(context.Background()))
+	assert.NoError(t, <-served)
+	_, err = job.DefaultRunner.Submit("test", func(ctx context.Context, t *job.Tracker) (*job.Result, error) { return nil, nil })
+	assert.NoError(t, err)
+
+	assert.NoError(t, servers[1].Shutdown(context.Background()))
+	assert.NoError(t, <-served)
+	_, err = job.DefaultRunner.Submit("test", func(ctx context.Context, t *job.Tracker) (*job.Result, error) { return nil, nil })
+	assert.Equal(t, job.ErrShutdown, err)
+
+	// The next server gets a new runner
+	s := New(Options{})
+	defer s.Shutdown(context.Background())
+	_, err = job.DefaultRunner.Submit("test", func(ctx context.Context, t *job.Tracker) (*job.Result, error) { return nil, nil })
+	assert.NoError(t, err)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"../service/rbac"
+	apihandler "./handler"
+	"./route"
+)
+
+// TenancyOptions share the API between tenants, that each have their own
+// pets, jobs and idempotency keys
+type TenancyOptions struct {
+	// Header is the request header with the tenant, DefaultTenantHeader if
+	// it is empty. Principals bound to a tenant don't need it.
+	Header string
+}
+
+// DefaultTenantHeader is the request header the tenant is taken from by
+// default
+var DefaultTenantHeader = "X-Tenant-ID"
+
+// tenantMiddleware returns a middleware that scopes requests to rt to their
+// tenant. The tenant of the principal that made a request wins; principals
+// that aren't bound to one, or requests when authentication is off, name it
+// in the tenant header. Only admins may use the header when authentication
+// is on, so that no other principal can reach across tenants. Routes that
+// are public or global are left alone.
+func tenantMiddleware(rt route.Route, opts TenancyOptions) func(http.Handler) http.Handler {
+	header := opts.Header
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+	return func(next http.Handler) http.Handler {
+		if rt.Public || rt.Global {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant := r.Header.Get(header)
+			if p, ok := apihandler.GetPrincipal(r); ok {
+				switch {
+				case p.Tenant != "" && tenant != "" && tenant != p.Tenant:
+					err := fmt.Errorf("%w: you can only act for tenant %s", apihandler.ErrForbidden, p.Tenant)
+					apihandler.WriteError(w, r, http.StatusForbidden, err, false)
+					return
+				case p.Tenant != "":
+					tenant = p.Tenant
+				case tenant != "" && !rbac.DefaultPolicy.Allows(p.Roles, rbac.PermissionAdmin):
+					err := fmt.Errorf("%w: the %s permission is needed to pick the tenant", apihandler.ErrForbidden, rbac.PermissionAdmin)
+					apihandler.WriteError(w, r, http.StatusForbidden, err, false)
+					return
+				}
+			}
+
+			if tenant == "" {
+				apihandler.WriteError(w, r, http.StatusBadRequest, apihandler.ErrTenantRequired, false)
+				return
+			}
+			if err := apihandler.CheckTenant(tenant); err != nil {
+				apihandler.WriteError(w, r, http.StatusBadRequest, err, false)
+				return
+			}
+			next.ServeHTTP(w, apihandler.WithTenant(r, tenant))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/apikey"
+	"../service/job"
+	"../service/pet"
+	apihandler "./handler"
+)
+
+func TestTenancy(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(store *apikey.Store) {
+		clog.LogLevel = 0
+		apikey.DefaultStore = store
+		pet.ResetData()
+	}(apikey.DefaultStore)
+
+	apikey.DefaultStore = apikey.NewStore()
+	apikey.DefaultStore.AddStatic("admin", "Admin", "admin-secret", []string{"admin"})
+	var keys = make(map[string]string)
+	for _, k := range []struct{ label, tenant string }{{"acme", "acme"}, {"globex", "globex"}, {"anyone", ""}} {
+		_, secret, err := apikey.DefaultStore.Create(k.label, k.tenant, []string{"editor"}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[k.label] = secret
+	}
+	keys["admin"] = "admin-secret"
+
+	h := newHandler(Options{Auth: &AuthOptions{APIKeys: true}, Tenancy: &TenancyOptions{}})
+	do := func(key, tenant, method, route, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, route, bytes.NewBufferString(body))
+		r.Header.Set("X-API-Key", keys[key])
+		r.Header.Set("Content-Type", "application/json")
+		if tenant != "" {
+			r.Header.Set("X-Tenant-ID", tenant)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Both tenants can have a pet with the same ID
+	assert.Equal(t, http.StatusCreated, do("acme", "", http.MethodPost, "/v1/pets", `{"id": 1, "name": "Rex"}`).Code)
+	assert.Equal(t, http.StatusCreated, do("globex", "", http.MethodPost, "/v1/pets", `{"id": 1, "name": "Tom"}`).Code)
+	assert.Equal(t, http.StatusCreated, do("globex", "", http.MethodPost, "/v1/pets", `{"id": 2, "name": "Felix"}`).Code)
+
+	tests := []struct {
+		name          string
+		key           string
+		tenant        string
+		route         string
+		expectedCode  int
+		expectedNames []string
+		expectedError string
+	}{
+		{
+			name:          "tenants should only list their own pets",
+			key:           "acme",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"Rex"},
+		},
+		{
+			name:          "tenants should get their own pet for an ID that both use",
+			key:           "globex",
+			route:         "/v1/pets/1",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"Tom"},
+		},
+		{
+			name:          "tenants should not get the pets of others",
+			key:           "acme",
+			route:         "/v1/pets/2",
+			expectedCode:  http.StatusNotFound,
+			expectedError: apihandler.CodePetNotFound,
+		},
+		{
+			name:          "tenants should not reach other tenants with the header",
+			key:           "acme",
+			tenant:        "globex",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:          "tenants may name their own tenant in the header",
+			key:           "acme",
+			tenant:        "acme",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"Rex"},
+		},
+		{
+			name:          "admins should pick the tenant with the header",
+			key:           "admin",
+			tenant:        "globex",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"Tom", "Felix"},
+		},
+		{
+			name:          "tenants without pets should have none",
+			key:           "admin",
+			tenant:        "initech",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{},
+		},
+		{
+			name:          "requests without a tenant should be refused",
+			key:           "admin",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: apihandler.CodeTenantRequired,
+		},
+		{
+			name:          "invalid tenants should be refused",
+			key:           "admin",
+			tenant:        "Globex Corp",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusBadRequest,
+			expectedError: apihandler.CodeInvalidTenant,
+		},
+		{
+			name:          "principals that aren't admins should not pick the tenant",
+			key:           "anyone",
+			tenant:        "acme",
+			route:         "/v1/pets",
+			expectedCode:  http.StatusForbidden,
+			expectedError: apihandler.CodeForbidden,
+		},
+		{
+			name:         "global routes should not need a tenant",
+			key:          "admin",
+			route:        "/v1/admin/keys",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.key, tt.tenant, http.MethodGet, tt.route, "")
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedError != "" {
+				var errE apihandler.Error
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errE))
+				assert.Equal(t, tt.expectedError, errE.Code)
+			}
+			if tt.expectedNames != nil {
+				var pets []pet.Pet
+				if w.Body.Bytes()[0] != '[' {
+					pets = append(pets, pet.Pet{})
+					assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pets[0]))
+				} else {
+					assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pets))
+				}
+				var names = []string{}
+				for _, p := range pets {
+					names = append(names, p.Name)
+				}
+				assert.ElementsMatch(t, tt.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestTenancy_Jobs(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func(runner *job.Runner) {
+		clog.LogLevel = 0
+		job.DefaultRunner.Shutdown(context.Background())
+		job.DefaultRunner = runner
+		pet.ResetData()
+	}(job.DefaultRunner)
+	job.DefaultRunner = job.NewRunner(1, 10)
+
+	h := newHandler(Options{Tenancy: &TenancyOptions{}})
+	do := func(tenant, method, route string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, route, nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("acme", http.MethodPost, "/v1/pets:reindex")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	location := w.Header().Get("Location")
+
+	// Only the tenant that submitted a job can see or cancel it
+	assert.Equal(t, http.StatusOK, do("acme", http.MethodGet, location).Code)
+	assert.Equal(t, http.StatusNotFound, do("globex", http.MethodGet, location).Code)
+	assert.Equal(t, http.StatusNotFound, do("globex", http.MethodDelete, location).Code)
+}
+
+func TestTenancy_Idempotency(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	h := newHandler(Options{Tenancy: &TenancyOptions{Header: "X-Org"}})
+	do := func(tenant, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/pets", bytes.NewBufferString(body))
+		r.Header.Set("X-Org", tenant)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Tenants can use the same key for different requests, without getting
+	// the response of the other
+	w := do("acme", `{"id": 1, "name": "Rex"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = do("globex", `{"id": 1, "name": "Tom"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(idempotencyReplayedHeader))
+
+	p, err := pet.GetPetByID("globex", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Tom", p.Name)
+	p, err = pet.GetPetByID("acme", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Rex", p.Name)
+}
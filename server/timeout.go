@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/teejays/clog"
+)
+
+// timeoutMiddleware returns a middleware that gives requests timeout to be
+// read, and as long again for their responses to be written, instead of the
+// ReadTimeout and WriteTimeout of the server. The deadlines are set on the
+// connection, through any ResponseWriter that wraps it and can be unwrapped.
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(timeout)
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(deadline); err != nil {
+				clog.Warningf("Could not extend the read deadline of %s: %v", r.URL.Path, err)
+			}
+			if err := rc.SetWriteDeadline(deadline.Add(timeout)); err != nil {
+				clog.Warningf("Could not extend the write deadline of %s: %v", r.URL.Path, err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/pet"
+)
+
+func TestTimeout(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	s, err := Start(ListenOptions{Addr: "127.0.0.1"}, Options{ReadTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	// post sends lines as the body of a request to path, one every 50ms,
+	// so that it takes longer than the ReadTimeout to send
+	post := func(path, contentType string, lines ...string) (*http.Response, error) {
+		body, bodyWriter := io.Pipe()
+		go func() {
+			for _, line := range lines {
+				time.Sleep(50 * time.Millisecond)
+				bodyWriter.Write([]byte(line))
+			}
+			bodyWriter.Close()
+		}()
+		return http.Post(fmt.Sprintf("http://%s%s", s.Addr(), path), contentType, body)
+	}
+
+	// Routes that stream have longer to read the body
+	resp, err := post("/v1/pets:import", "application/x-ndjson",
+		`{"id": 1, "name": "Tommy"}`+"\n", `{"id": 2, "name": "Tiger"}`+"\n", `{"id": 3, "name": "Buddy"}`+"\n", `{"id": 5, "name": "Kitty"}`+"\n")
+	if assert.NoError(t, err) {
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"imported": 4, "failed": 0, "errors": []}`, string(data))
+	}
+
+	// while the others are still held to the ReadTimeout
+	resp, err = post("/v1/pets", "application/json", `{"id": 8, `, ` "name": `, `"Coco"`, `}`)
+	if err == nil {
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusCreated, resp.StatusCode)
+	}
+	_, err = pet.GetPetByID(pet.DefaultTenant, 8)
+	assert.Equal(t, pet.ErrNotExist, err)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+
+	apihandler "./handler"
+)
+
+// TLSOptions configure serving HTTPS
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate, along with any
+	// intermediates, and private key of the server
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of the CAs that client certificates are
+	// verified against. Setting it turns on mutual TLS: clients must present
+	// a certificate signed by one of them.
+	ClientCAFile string
+	// ReloadInterval is how often the files are checked for changes, so
+	// that renewed certificates are picked up without a restart
+	ReloadInterval time.Duration
+}
+
+// DefaultTLSReloadInterval is used when TLSOptions.ReloadInterval isn't set
+var DefaultTLSReloadInterval = time.Minute
+
+// certReloader holds the certificate and client CAs loaded from the files in
+// its TLSOptions, and loads them again when the files change
+type certReloader struct {
+	opts TLSOptions
+	now  func() time.Time
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	checked   time.Time
+}
+
+// newCertReloader loads the files in opts, failing if they aren't valid
+func newCertReloader(opts TLSOptions) (*certReloader, error) {
+	if opts.ReloadInterval == 0 {
+		opts.ReloadInterval = DefaultTLSReloadInterval
+	}
+	c := &certReloader{opts: opts, now: time.Now}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// files returns the files the reloader loads
+func (c *certReloader) files() []string {
+	var files = []string{c.opts.CertFile, c.opts.KeyFile}
+	if c.opts.ClientCAFile != "" {
+		files = append(files, c.opts.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the files, replacing the certificate and client CAs only if
+// all of them are valid
+func (c *certReloader) load() error {
+	var modTimes = make(map[string]time.Time)
+	for _, f := range c.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in the client CA file %s", c.opts.ClientCAFile)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.modTimes = modTimes
+	c.checked = c.now()
+	return nil
+}
+
+// changed returns true if any of the files changed since they were loaded
+func (c *certReloader) changed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for f, modTime := range c.modTimes {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadIfChanged loads the files again if they changed, checking them at
+// most once per ReloadInterval. A failed reload keeps the files loaded last,
+// as they may be halfway through being replaced.
+func (c *certReloader) reloadIfChanged() {
+	c.lock.Lock()
+	due := c.now().Sub(c.checked) >= c.opts.ReloadInterval
+	if due {
+		c.checked = c.now()
+	}
+	c.lock.Unlock()
+
+	if !due || !c.changed() {
+		return
+	}
+	if err := c.load(); err != nil {
+		clog.Errorf("Server: could not reload the TLS certificates, still using the old ones: %v", err)
+		return
+	}
+	clog.Infof("Server: reloaded the TLS certificates")
+}
+
+// config returns the tls.Config for the server. It is built for each
+// connection, from the files loaded at the time.
+func (c *certReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.reloadIfChanged()
+
+			c.lock.RLock()
+			defer c.lock.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if c.clientCAs != nil {
+				cfg.ClientCAs = c.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientIdentityMiddleware makes the identity from the verified client
+// certificate of the request, if it has one, available to handlers
+func clientIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			r = apihandler.WithClientIdentity(r, apihandler.NewClientIdentity(r.TLS.VerifiedChains[0][0]))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
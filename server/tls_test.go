@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	apihandler "./handler"
+)
+
+// testCert is a certificate and its key, generated for a test
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert generates a certificate for name, signed by parent, or
+// self-signed as a CA if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Pet Shop"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// certPEM and keyPEM encode the certificate and key as files would have them
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testPKI is a CA with a server and a client certificate, written to files
+type testPKI struct {
+	ca, server, client *testCert
+	opts               TLSOptions
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ca := newTestCert(t, "Pet Shop CA", nil, 0)
+	p := &testPKI{
+		ca:     ca,
+		server: newTestCert(t, "127.0.0.1", ca, x509.ExtKeyUsageServerAuth),
+		client: newTestCert(t, "inventory-service", ca, x509.ExtKeyUsageClientAuth),
+		opts: TLSOptions{
+			CertFile:     filepath.Join(dir, "server.pem"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.pem"),
+		},
+	}
+	p.writeServerCert(t, p.server)
+	writeTestFile(t, p.opts.ClientCAFile, ca.certPEM())
+	return p
+}
+
+func (p *testPKI) writeServerCert(t *testing.T, c *testCert) {
+	writeTestFile(t, p.opts.CertFile, c.certPEM())
+	writeTestFile(t, p.opts.KeyFile, c.keyPEM(t))
+}
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// httpClient returns an HTTPS client that trusts the CA, presenting certs
+func (p *testPKI) httpClient(certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(p.ca.cert)
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	p := newTestPKI(t)
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		clientCerts  []tls.Certificate
+		expectedErr  bool
+	}{
+		{
+			name: "HTTPS should be served without client certificates",
+		},
+		{
+			name:         "mutual TLS should refuse clients without a certificate",
+			clientCAFile: p.opts.ClientCAFile,
+			expectedErr:  true,
+		},
+		{
+			name:         "mutual TLS should refuse certificates from another CA",
+			clientCAFile: p.opts.ClientCAFile,
+			clientCerts:  []tls.Certificate{newTestCert(t, "intruder", newTestCert(t, "Other CA", nil, 0), x509.ExtKeyUsageClientAuth).tlsCertificate(t)},
+			expectedErr:  true,
+		},
+		{
+			name:         "mutual TLS should accept certificates from the CA",
+			clientCAFile: p.opts.ClientCAFile,
+			clientCerts:  []tls.Certificate{p.client.tlsCertificate(t)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := p.opts
+			opts.ClientCAFile = tt.clientCAFile
+
+			s, err := Start(ListenOptions{Addr: "127.0.0.1"}, Options{TLS: &opts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			resp, err := p.httpClient(tt.clientCerts...).Get(fmt.Sprintf("https://%s/v1/pets", s.Addr()))
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestStart_InvalidTLS(t *testing.T) {
+
+	p := newTestPKI(t)
+	opts := p.opts
+	opts.KeyFile = opts.ClientCAFile
+
+	_, err := Start(ListenOptions{Addr: "127.0.0.1"}, Options{TLS: &opts})
+	assert.Error(t, err)
+}
+
+func TestCertReloader(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	p := newTestPKI(t)
+	opts := p.opts
+	opts.ReloadInterval = time.Minute
+
+	c, err := newCertReloader(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var now = time.Now()
+	c.now = func() time.Time { return now }
+
+	serving := func() *x509.Certificate {
+		cfg, err := c.config().GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	touch := func(offset time.Duration) {
+		for _, f := range c.files() {
+			os.Chtimes(f, now.Add(offset), now.Add(offset))
+		}
+	}
+	assert.Equal(t, p.server.cert.SerialNumber, serving().SerialNumber)
+
+	// A renewed certificate isn't picked up until the files are checked again
+	renewed := newTestCert(t, "127.0.0.1", p.ca, x509.ExtKeyUsageServerAuth)
+	p.writeServerCert(t, renewed)
+	touch(time.Second)
+	assert.Equal(t, p.server.cert.SerialNumber, serving().SerialNumber)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, renewed.cert.SerialNumber, serving().SerialNumber)
+
+	// A broken certificate keeps the last one
+	writeTestFile(t, opts.CertFile, []byte("not a certificate"))
+	touch(2 * time.Second)
+	now = now.Add(time.Minute)
+	assert.Equal(t, renewed.cert.SerialNumber, serving().SerialNumber)
+}
+
+func TestClientIdentityMiddleware(t *testing.T) {
+
+	p := newTestPKI(t)
+	c, err := newCertReloader(p.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var identity apihandler.ClientIdentity
+	var found bool
+	srv := httptest.NewUnstartedServer(clientIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, found = apihandler.GetClientIdentity(r)
+	})))
+	srv.TLS = c.config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := p.httpClient(p.client.tlsCertificate(t)).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	assert.True(t, found)
+	assert.Equal(t, "inventory-service", identity.Subject)
+	assert.Equal(t, []string{"Pet Shop"}, identity.Organizations)
+	assert.Equal(t, apihandler.NewClientIdentity(p.client.cert).Fingerprint, identity.Fingerprint)
+
+	// Plain requests have no identity
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	clientIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, found = apihandler.GetClientIdentity(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, found)
+}
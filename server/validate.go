@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	apihandler "./handler"
+	"./route"
+	"./schema"
+)
+
+// validationMiddleware validates the params and JSON body of requests to rt
+// against the schemas the route documents, before they reach the handler.
+// Requests that fail get a Bad Request with an error for each field.
+func validationMiddleware(rt route.Route, reg *schema.Registry) func(http.Handler) http.Handler {
+	// Only JSON bodies are validated, others are streamed to the handler as is
+	var bodySchema *schema.Schema
+	if rt.Request != nil && rt.Request.Type != nil {
+		bodySchema = reg.Of(rt.Request.Type)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, errs := parseParams(reg, rt.Params, r)
+
+			if bodySchema != nil && isJSON(r) {
+				body, err := apihandler.ReadBody(r)
+				if err != nil {
+					apihandler.WriteBodyError(w, r, err)
+					return
+				}
+				r.Body.Close()
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+				// Deep bodies are refused before they are decoded
+				if err := apihandler.CheckJSONDepth(body); err != nil {
+					apihandler.WriteError(w, r, http.StatusBadRequest, err, false)
+					return
+				}
+
+				errs = append(errs, reg.ValidateJSON(bodySchema, body)...)
+			}
+
+			if len(errs) > 0 {
+				apihandler.WriteValidationError(w, r, errs)
+				return
+			}
+
+			next.ServeHTTP(w, apihandler.WithParams(r, params))
+		})
+	}
+}
+
+// parseParams parses the values of params in r, returning them by name
+func parseParams(reg *schema.Registry, params []route.Param, r *http.Request) (map[string]interface{}, []schema.FieldError) {
+	var values = make(map[string]interface{})
+	var errs []schema.FieldError
+
+	var query = r.URL.Query()
+	var vars = mux.Vars(r)
+
+	for _, p := range params {
+		var raw []string
+		switch p.In {
+		case route.InQuery:
+			raw = query[p.Name]
+		case route.InPath:
+			if v, exists := vars[p.Name]; exists {
+				raw = []string{v}
+			}
+		case route.InHeader:
+			raw = r.Header[http.CanonicalHeaderKey(p.Name)]
+		}
+
+		if len(raw) == 0 {
+			if p.Required || p.In == route.InPath {
+				errs = append(errs, schema.FieldError{Field: p.Name, In: p.In, Code: schema.CodeRequired, Message: "is required"})
+			}
+			continue
+		}
+		if len(raw) > 1 {
+			errs = append(errs, schema.FieldError{Field: p.Name, In: p.In, Code: schema.CodeMultiple, Message: "must only be given once"})
+			continue
+		}
+
+		v, paramErrs := reg.ParseParam(p.Schema, p.Name, p.In, raw[0])
+		if len(paramErrs) > 0 {
+			errs = append(errs, paramErrs...)
+			continue
+		}
+		values[p.Name] = v
+	}
+
+	return values, errs
+}
+
+// isJSON tells whether the request body is JSON. Requests without a
+// Content-Type are taken to be JSON, since that is all the API used to take.
+func isJSON(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == route.MediaTypeJSON
+}
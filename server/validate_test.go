@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/pet"
+	apihandler "./handler"
+	"./schema"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+	pet.PopulateMockPets()
+
+	h := newHandler(Options{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		method         string
+		route          string
+		contentType    string
+		body           string
+		expectedCode   int
+		expectedErrors []schema.FieldError
+	}{
+		{
+			name:         "valid query params should pass",
+			method:       http.MethodGet,
+			route:        "/v1/pets?limit=2&page=2",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "query params out of range should fail",
+			method:       http.MethodGet,
+			route:        "/v1/pets?limit=101&page=0",
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "limit", In: "query", Code: schema.CodeMaximum, Message: "must be at most 100"},
+				{Field: "page", In: "query", Code: schema.CodeMinimum, Message: "must be at least 1"},
+			},
+		},
+		{
+			name:         "query params given more than once should fail",
+			method:       http.MethodGet,
+			route:        "/v1/pets?limit=1&limit=2",
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "limit", In: "query", Code: schema.CodeMultiple, Message: "must only be given once"},
+			},
+		},
+		{
+			name:         "query params not in the enum should fail",
+			method:       http.MethodGet,
+			route:        "/v1/pets:export?format=xml",
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "format", In: "query", Code: schema.CodeEnum, Message: "must be one of: ndjson, csv"},
+			},
+		},
+		{
+			name:         "path params that overflow should fail",
+			method:       http.MethodGet,
+			route:        "/v1/pets/99999999999999999999",
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "id", In: "path", Code: schema.CodeInvalidType, Message: "must be an integer"},
+			},
+		},
+		{
+			name:         "query params of the wrong type should fail",
+			method:       http.MethodPost,
+			route:        "/v1/pets:batch?atomic=maybe",
+			body:         `{"operations": [{"op": "delete", "id": 1}]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "atomic", In: "query", Code: schema.CodeInvalidType, Message: "must be a boolean"},
+			},
+		},
+		{
+			name:         "invalid bodies should fail with all the field errors",
+			method:       http.MethodPost,
+			route:        "/v1/pets",
+			body:         `{"id": -1, "name": ""}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "id", In: "body", Code: schema.CodeMinimum, Message: "must be at least 1"},
+				{Field: "name", In: "body", Code: schema.CodePattern, Message: "must match the pattern \\S"},
+			},
+		},
+		{
+			name:         "nested body fields should fail with their path",
+			method:       http.MethodPost,
+			route:        "/v1/pets:batch",
+			body:         `{"operations": [{"op": "delete", "id": 1}, {"op": "rename", "pet": {"id": 1}}]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErrors: []schema.FieldError{
+				{Field: "operations[1].op", In: "body", Code: schema.CodeEnum, Message: "must be one of: create, update, delete"},
+				{Field: "operations[1].pet.name", In: "body", Code: schema.CodeRequired, Message: "is required"},
+			},
+		},
+		{
+			name:         "valid bodies should reach the handler",
+			method:       http.MethodPost,
+			route:        "/v1/pets",
+			body:         `{"id": 21, "name": "Rex"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "bodies that aren't JSON should not be validated",
+			method:       http.MethodPost,
+			route:        "/v1/pets:import",
+			contentType:  "text/csv",
+			body:         "id,name\n22,Max\n",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			req, err := http.NewRequest(tt.method, srv.URL+tt.route, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedErrors != nil {
+				var errH apihandler.Error
+				err = json.Unmarshal(body, &errH)
+				if err != nil {
+					t.Error(err)
+				}
+				assert.Equal(t, apihandler.CodeValidationFailed, errH.Code)
+				assert.Equal(t, "request validation failed", errH.Detail)
+				assert.Equal(t, tt.expectedErrors, errH.Errors)
+			}
+		})
+	}
+}
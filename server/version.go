@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	apihandler "./handler"
+	"./route"
+)
+
+// DefaultVersion is the version of the API for requests to paths without a
+// version that don't ask for one in their Accept header either
+var DefaultVersion = 1
+
+// versionedPathRegex matches paths that start with a version, e.g. /v2/pets
+var versionedPathRegex = regexp.MustCompile(`^/v[0-9]+/`)
+
+// negotiateVersion lets clients leave the version out of the path, and ask
+// for one with the version parameter of a media type in the Accept header
+// instead, e.g. Accept: application/json; version=2. The path is rewritten to
+// the versioned one, after prefix, before m routes the request. Paths that
+// have a version, or that don't match a route once they do, are left alone.
+func negotiateVersion(m *mux.Router, prefix string, versions []int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if len(path) == len(r.URL.Path) && prefix != "" || versionedPathRegex.MatchString(path) {
+			m.ServeHTTP(w, r)
+			return
+		}
+
+		version, err := getAcceptVersion(r.Header.Get("Accept"))
+		if err == nil && !containsVersion(versions, version) {
+			err = fmt.Errorf("version %d of the API is not supported, the supported versions are %v", version, versions)
+		}
+		if version == 0 {
+			version = DefaultVersion
+		}
+
+		var versioned = withVersion(r, prefix, version)
+		if !matchesRoute(m, versioned) {
+			// The path may be for one of the versions that wasn't asked
+			// for, which is only an error if the client asked for one
+			if err != nil && matchesRoute(m, withVersion(r, prefix, DefaultVersion)) {
+				apihandler.WriteError(w, r, http.StatusNotAcceptable, err, false)
+				return
+			}
+			m.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			apihandler.WriteError(w, r, http.StatusNotAcceptable, err, false)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept")
+		m.ServeHTTP(w, versioned)
+	})
+}
+
+// withVersion returns a copy of r with version in its path, after prefix
+func withVersion(r *http.Request, prefix string, version int) *http.Request {
+	var versioned = r.Clone(r.Context())
+	versioned.URL.Path = fmt.Sprintf("%s/v%d%s", prefix, version, strings.TrimPrefix(r.URL.Path, prefix))
+	versioned.URL.RawPath = ""
+	return versioned
+}
+
+// matchesRoute returns whether r is for one of the routes of m, with any method
+func matchesRoute(m *mux.Router, r *http.Request) bool {
+	var match mux.RouteMatch
+	return m.Match(r, &match) && match.MatchErr != mux.ErrNotFound
+}
+
+// getAcceptVersion returns the version parameter of the first media type in
+// accept that has one, or 0 if none do
+func getAcceptVersion(accept string) (int, error) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		if strings.TrimSpace(mediaRange) == "" {
+			continue
+		}
+		_, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		v, exists := params["version"]
+		if !exists || params["q"] == "0" {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+		if err != nil || version < 1 {
+			return 0, fmt.Errorf("invalid API version %q in the Accept header", v)
+		}
+		return version, nil
+	}
+	return 0, nil
+}
+
+func containsVersion(versions []int, version int) bool {
+	if version == 0 {
+		return true
+	}
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// deprecationMiddleware tells clients of the route rt that it is deprecated,
+// with the Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and links
+// to the route that replaces it
+func deprecationMiddleware(rt route.Route) func(http.Handler) http.Handler {
+	var d = rt.Deprecation
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Date.Unix()))
+			if !d.Sunset.IsZero() {
+				w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Successor > 0 {
+				successor := strings.Replace(r.URL.Path, fmt.Sprintf("/v%d/", rt.Version), fmt.Sprintf("/v%d/", d.Successor), 1)
+				w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
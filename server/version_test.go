@@ -0,0 +1,154 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+
+	"../service/pet"
+)
+
+func TestGetAcceptVersion(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		accept   string
+		expected int
+		isError  bool
+	}{
+		{"no header should have no version", "", 0, false},
+		{"media types without a version should have no version", "application/json, */*", 0, false},
+		{"the version param should be used", "application/json; version=2", 2, false},
+		{"a v prefix should be allowed", "application/json;version=v2", 2, false},
+		{"the first media type with a version should win", "text/html, application/json; version=1, */*; version=2", 1, false},
+		{"media types that aren't acceptable should be skipped", "application/json; version=1; q=0, application/json; version=2", 2, false},
+		{"versions that aren't numbers should be an error", "application/json; version=latest", 0, true},
+		{"versions less than 1 should be an error", "application/json; version=0", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := getAcceptVersion(tt.accept)
+			assert.Equal(t, tt.isError, err != nil)
+			assert.Equal(t, tt.expected, version)
+		})
+	}
+}
+
+func TestVersioning(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+		pet.ResetData()
+	}()
+
+	pet.AddPet(pet.DefaultTenant, pet.Pet{ID: 1, Name: "Tommy", Tag: "dog"})
+
+	h := newHandler(Options{})
+
+	var v1Headers = map[string]string{
+		"Deprecation": "@1790812800",
+		"Sunset":      "Thu, 01 Apr 2027 00:00:00 GMT",
+		"Link":        `</v2/pets/1>; rel="successor-version"`,
+	}
+	var v2Headers = map[string]string{
+		"Deprecation": "",
+		"Sunset":      "",
+		"Link":        "",
+	}
+
+	tests := []struct {
+		name            string
+		path            string
+		accept          string
+		expectedCode    int
+		expectedBody    string
+		expectedHeaders map[string]string
+	}{
+		{
+			name:            "v1 routes should be deprecated",
+			path:            "/v1/pets/1",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"id":1,"name":"Tommy","tag":"dog"}`,
+			expectedHeaders: v1Headers,
+		},
+		{
+			name:            "v2 routes should not be deprecated",
+			path:            "/v2/pets/1",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"data":{"id":1,"name":"Tommy","tags":["dog"],"links":{"self":"/v2/pets/1"}}}`,
+			expectedHeaders: v2Headers,
+		},
+		{
+			name:            "v1 routes without a replacement should not be deprecated",
+			path:            "/v1/jobs/abc",
+			expectedCode:    http.StatusNotFound,
+			expectedHeaders: v2Headers,
+		},
+		{
+			name:            "paths without a version should be the default version",
+			path:            "/pets/1",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"id":1,"name":"Tommy","tag":"dog"}`,
+			expectedHeaders: map[string]string{"Deprecation": "@1790812800", "Vary": "Accept"},
+		},
+		{
+			name:            "paths without a version should be the version in the Accept header",
+			path:            "/pets/1",
+			accept:          "application/json; version=2",
+			expectedCode:    http.StatusOK,
+			expectedBody:    `{"data":{"id":1,"name":"Tommy","tags":["dog"],"links":{"self":"/v2/pets/1"}}}`,
+			expectedHeaders: map[string]string{"Deprecation": "", "Vary": "Accept"},
+		},
+		{
+			name:         "the version in the path should win over the Accept header",
+			path:         "/v1/pets/1",
+			accept:       "application/json; version=2",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"name":"Tommy","tag":"dog"}`,
+		},
+		{
+			name:         "versions that don't exist should not be acceptable",
+			path:         "/pets/1",
+			accept:       "application/json; version=3",
+			expectedCode: http.StatusNotAcceptable,
+			expectedBody: `{"type":"urn:petsapi:problem:NOT_ACCEPTABLE","title":"Not acceptable","status":406,"detail":"version 3 of the API is not supported, the supported versions are [1 2]","instance":"/pets/1","code":"NOT_ACCEPTABLE"}`,
+		},
+		{
+			name:         "routes missing from the version asked for should not be found",
+			path:         "/pets:export",
+			accept:       "application/json; version=2",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "paths that aren't versioned should be left alone",
+			path:         "/docs",
+			accept:       "text/html; version=3",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r = httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			var w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+			}
+			for name, value := range tt.expectedHeaders {
+				assert.Equal(t, value, w.Header().Get(name), name)
+			}
+		})
+	}
+}
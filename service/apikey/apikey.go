@@ -0,0 +1,352 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key is an API key. The secret that authenticates with it is only known when
+// it is created or rotated, the store keeps a hash of it.
+type Key struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	// Roles grant the key permissions, see the rbac package
+	Roles []string `json:"roles"`
+	// Tenant is the only tenant the key can act for, any of them if it is
+	// empty
+	Tenant    string     `json:"tenant,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Static keys are set in the configuration, rather than through the
+	// API, so they can't be rotated or revoked
+	Static bool `json:"static,omitempty"`
+}
+
+// SecretPrefix starts every secret, so that they are easy to spot, e.g. by
+// secret scanners
+const SecretPrefix = "pk_"
+
+// ErrInvalid is returned for a secret that isn't one of the keys
+var ErrInvalid = fmt.Errorf("API key is not valid")
+
+// ErrExpired is returned for a secret of a key that has expired
+var ErrExpired = fmt.Errorf("API key has expired")
+
+// ErrRevoked is returned for a secret of a key that has been revoked
+var ErrRevoked = fmt.Errorf("API key has been revoked")
+
+// ErrNotExist is returned for a key ID that isn't in the store
+var ErrNotExist = fmt.Errorf("API key does not exist")
+
+// ErrStatic is returned when changing a key set in the configuration
+var ErrStatic = fmt.Errorf("API key is set in the configuration, and can't be changed through the API")
+
+// ErrInvalidLabel is returned when creating a key without a label
+var ErrInvalidLabel = fmt.Errorf("invalid label: cannot be empty")
+
+// ErrInvalidExpiry is returned when a key would expire in the past
+var ErrInvalidExpiry = fmt.Errorf("invalid expiry: must be in the future")
+
+// record is a key along with the hash of its secret, as it is stored
+type record struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// Store holds the API keys
+type Store struct {
+	lock sync.Mutex
+	keys map[string]*record
+	// hashes indexes the keys by the hash of their secret
+	hashes map[string]string
+	// path is the file the keys are saved to, if any
+	path string
+	now  func() time.Time
+}
+
+// DefaultStore is the store of the keys used by the server
+var DefaultStore = NewStore()
+
+// NewStore returns an empty Store that keeps the keys in memory
+func NewStore() *Store {
+	return &Store{
+		keys:   make(map[string]*record),
+		hashes: make(map[string]string),
+		now:    time.Now,
+	}
+}
+
+// Open replaces the keys in s with the ones in the JSON file at path, and
+// saves every change to them back there. A missing file has no keys, it is
+// created on the first change.
+func (s *Store) Open(path string) error {
+	var records []*record
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &records); err != nil {
+			return fmt.Errorf("could not read the API keys in %s: %w", path, err)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Static keys aren't saved, so they are kept
+	for id, rec := range s.keys {
+		if !rec.Static {
+			delete(s.keys, id)
+			delete(s.hashes, rec.Hash)
+		}
+	}
+	for _, rec := range records {
+		s.keys[rec.ID] = rec
+		s.hashes[rec.Hash] = rec.ID
+	}
+	s.path = path
+	return nil
+}
+
+// AddStatic adds a key with a secret set in the configuration. It isn't
+// saved, and is set again each time the server starts.
+func (s *Store) AddStatic(id, label, secret string, roles []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rec := &record{
+		Key:  Key{ID: id, Label: label, Roles: roles, CreatedAt: s.now(), Static: true},
+		Hash: hash(secret),
+	}
+	s.keys[id] = rec
+	s.hashes[rec.Hash] = id
+}
+
+// Create adds a key for tenant, returning it along with its secret. A zero
+// expiresAt never expires.
+func (s *Store) Create(label, tenant string, roles []string, expiresAt time.Time) (Key, string, error) {
+	if label == "" {
+		return Key{}, "", ErrInvalidLabel
+	}
+	id, err := newID()
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return Key{}, "", ErrInvalidExpiry
+	}
+	rec := &record{
+		Key:  Key{ID: id, Label: label, Roles: roles, Tenant: tenant, CreatedAt: now, ExpiresAt: timePtr(expiresAt)},
+		Hash: hash(secret),
+	}
+	s.keys[id] = rec
+	s.hashes[rec.Hash] = id
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		delete(s.hashes, rec.Hash)
+		return Key{}, "", err
+	}
+	return rec.Key, secret, nil
+}
+
+// Rotate replaces the secret of the key with id, so the old one stops
+// working straight away. A zero expiresAt keeps the expiry of the key.
+func (s *Store) Rotate(id string, expiresAt time.Time) (Key, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Key{}, "", err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rec, err := s.get(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	now := s.now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return Key{}, "", ErrInvalidExpiry
+	}
+
+	old := *rec
+	delete(s.hashes, rec.Hash)
+	rec.Hash = hash(secret)
+	rec.RotatedAt = &now
+	if !expiresAt.IsZero() {
+		rec.ExpiresAt = &expiresAt
+	}
+	s.hashes[rec.Hash] = id
+	if err := s.save(); err != nil {
+		delete(s.hashes, rec.Hash)
+		*rec = old
+		s.hashes[rec.Hash] = id
+		return Key{}, "", err
+	}
+	return rec.Key, secret, nil
+}
+
+// Revoke stops the key with id from working. It is kept, so that it can
+// still be listed.
+func (s *Store) Revoke(id string) (Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rec, err := s.get(id)
+	if err != nil {
+		return Key{}, err
+	}
+	if rec.RevokedAt != nil {
+		return rec.Key, nil
+	}
+
+	now := s.now()
+	rec.RevokedAt = &now
+	if err := s.save(); err != nil {
+		rec.RevokedAt = nil
+		return Key{}, err
+	}
+	return rec.Key, nil
+}
+
+// get returns the key with id that can be changed, s.lock must be held
+func (s *Store) get(id string) (*record, error) {
+	rec, exists := s.keys[id]
+	if !exists {
+		return nil, ErrNotExist
+	}
+	if rec.Static {
+		return nil, ErrStatic
+	}
+	return rec, nil
+}
+
+// Get returns the key with id
+func (s *Store) Get(id string) (Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rec, exists := s.keys[id]
+	if !exists {
+		return Key{}, ErrNotExist
+	}
+	return rec.Key, nil
+}
+
+// List returns all the keys, oldest first
+func (s *Store) List() []Key {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var keys = make([]Key, 0, len(s.keys))
+	for _, rec := range s.keys {
+		keys = append(keys, rec.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Authenticate returns the key that secret belongs to, if it can be used
+func (s *Store) Authenticate(secret string) (Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id, exists := s.hashes[hash(secret)]
+	if !exists {
+		return Key{}, ErrInvalid
+	}
+	rec := s.keys[id]
+	if rec.RevokedAt != nil {
+		return Key{}, ErrRevoked
+	}
+	if rec.ExpiresAt != nil && !s.now().Before(*rec.ExpiresAt) {
+		return Key{}, ErrExpired
+	}
+	return rec.Key, nil
+}
+
+// save writes the keys to the file opened with Open, if any. s.lock must be
+// held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	var records = make([]*record, 0, len(s.keys))
+	for _, rec := range s.keys {
+		if !rec.Static {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a failed write never leaves a
+	// partial file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// hash returns the hash of secret that is stored. Secrets are random, so
+// unlike passwords they don't need a slow hash.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
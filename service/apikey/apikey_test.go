@@ -0,0 +1,133 @@
+package apikey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore()
+	s.now = func() time.Time { return now }
+
+	// Creating keys
+	_, _, err := s.Create("", "", nil, time.Time{})
+	assert.Equal(t, ErrInvalidLabel, err)
+	_, _, err = s.Create("partner", "acme", []string{"reader"}, now)
+	assert.Equal(t, ErrInvalidExpiry, err)
+
+	partner, partnerSecret, err := s.Create("partner", "acme", []string{"reader"}, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(partnerSecret, SecretPrefix))
+	assert.Equal(t, "partner", partner.Label)
+	assert.Equal(t, "acme", partner.Tenant)
+	assert.Equal(t, now.Add(time.Hour), *partner.ExpiresAt)
+
+	now = now.Add(time.Minute)
+	ops, opsSecret, err := s.Create("ops", "", []string{"admin"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, ops.ExpiresAt)
+	assert.NotEqual(t, partnerSecret, opsSecret)
+
+	// Authenticating with them
+	key, err := s.Authenticate(partnerSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, partner, key)
+	_, err = s.Authenticate(partnerSecret + "x")
+	assert.Equal(t, ErrInvalid, err)
+
+	now = now.Add(time.Hour)
+	_, err = s.Authenticate(partnerSecret)
+	assert.Equal(t, ErrExpired, err)
+
+	// Rotating gives a new secret, and the old one stops working
+	rotated, newSecret, err := s.Rotate(partner.ID, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, now, *rotated.RotatedAt)
+	_, err = s.Authenticate(partnerSecret)
+	assert.Equal(t, ErrInvalid, err)
+	_, err = s.Authenticate(newSecret)
+	assert.NoError(t, err)
+
+	// Revoking
+	revoked, err := s.Revoke(ops.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, now, *revoked.RevokedAt)
+	_, err = s.Authenticate(opsSecret)
+	assert.Equal(t, ErrRevoked, err)
+
+	_, err = s.Revoke("missing")
+	assert.Equal(t, ErrNotExist, err)
+	_, _, err = s.Rotate("missing", time.Time{})
+	assert.Equal(t, ErrNotExist, err)
+
+	// Keys are listed oldest first, without their hash
+	assert.Equal(t, []Key{rotated, revoked}, s.List())
+}
+
+func TestStore_AddStatic(t *testing.T) {
+
+	s := NewStore()
+	s.AddStatic("admin", "Admin", "configured-secret", []string{"admin"})
+
+	key, err := s.Authenticate("configured-secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, key.Roles)
+	assert.True(t, key.Static)
+
+	_, err = s.Revoke("admin")
+	assert.Equal(t, ErrStatic, err)
+	_, _, err = s.Rotate("admin", time.Time{})
+	assert.Equal(t, ErrStatic, err)
+}
+
+func TestStore_Open(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "keys.json")
+
+	s := NewStore()
+	s.AddStatic("admin", "Admin", "configured-secret", []string{"admin"})
+	assert.NoError(t, s.Open(path))
+	key, secret, err := s.Create("partner", "acme", []string{"reader"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the hash of the secret is saved, along with the keys that
+	// aren't static
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), secret)
+	assert.Contains(t, string(content), hash(secret))
+	assert.NotContains(t, string(content), "configured")
+
+	// The keys work again once the file is opened
+	reopened := NewStore()
+	assert.NoError(t, reopened.Open(path))
+	authenticated, err := reopened.Authenticate(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, authenticated.ID)
+	assert.Equal(t, "acme", authenticated.Tenant)
+
+	// Files that aren't keys are an error
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, NewStore().Open(path))
+}
@@ -0,0 +1,300 @@
+// Package audit keeps an append-only log of the changes made to the pets:
+// who made each of them, when, and what the pet was before and after.
+//
+// The entries are chained by their hashes, each one covering the hash of the
+// entry before it, so changing, removing or reordering an entry breaks the
+// chain from there on. The chain is checked when the log is opened.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a change to a pet, as recorded in the log
+type Entry struct {
+	// Seq is the position of the entry in the log, starting at 1
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	// Actor is the principal that made the change, see Actor
+	Actor     string `json:"actor"`
+	RequestID string `json:"request_id,omitempty"`
+	// Action is the kind of change, e.g. pet.created
+	Action string `json:"action"`
+	PetID  int64  `json:"pet_id"`
+	// Old and New are the pet before and after the change, Old is missing
+	// for pets that were created and New for the ones that were deleted
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+	// PrevHash is the hash of the entry before, and Hash the hash of this
+	// entry along with PrevHash, both hex encoded SHA-256
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Actor is who changes the pets, along with the request they change them in
+type Actor struct {
+	// Principal identifies who made the change, e.g. api_key:ops
+	Principal string
+	RequestID string
+}
+
+// System is the actor of changes the server makes itself, e.g. loading the
+// mock pets
+var System = Actor{Principal: "system"}
+
+// genesisHash is the PrevHash of the first entry
+var genesisHash = strings.Repeat("0", 64)
+
+// ErrTampered is returned for a log whose hash chain is broken
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// Filter selects entries of the log. Zero fields match every entry, apart
+// from Tenant which always has to match.
+type Filter struct {
+	Tenant string
+	PetID  int64
+	Actor  string
+	// Since only matches entries recorded at or after it
+	Since time.Time
+	// After only matches entries with a greater Seq, to page through them
+	After int64
+	// Limit is the most entries to return
+	Limit int
+}
+
+// Log holds the entries, and appends them to a file if it was opened with one
+type Log struct {
+	lock    sync.RWMutex
+	entries []Entry
+	file    logFile
+	now     func() time.Time
+}
+
+// logFile is the file a Log appends to, an *os.File outside of tests
+type logFile interface {
+	io.WriteCloser
+	Sync() error
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// DefaultLog is the log of the changes made through the server
+var DefaultLog = NewLog()
+
+// NewLog returns a Log that keeps the entries in memory
+func NewLog() *Log {
+	return &Log{now: time.Now}
+}
+
+// Open replaces the entries of l with the ones in the file at path, one JSON
+// entry per line, and appends new entries to it. A missing file is an empty
+// log, it is created straight away. The file must have an unbroken hash
+// chain.
+func (l *Log) Open(path string) error {
+	entries, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	if err := verify(entries); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.entries = entries
+	l.file = f
+	return nil
+}
+
+func readFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("could not read the audit entry on line %d of %s: %w", line, path, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Close stops appending entries to the file l was opened with, and keeps them
+// in memory only
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Append adds entries to the end of l, setting their Seq, Time and hashes,
+// and returns them. They are written to the file l was opened with before
+// they are added, so none of them are if the write fails. The file is then
+// truncated back to where it was, so that it isn't left with a torn line.
+func (l *Log) Append(entries ...Entry) ([]Entry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	seq, prevHash := l.head()
+	now := l.now().UTC()
+	var lines bytes.Buffer
+	var added = make([]Entry, len(entries))
+	for i, e := range entries {
+		seq++
+		e.Seq = seq
+		e.Time = now
+		e.PrevHash = prevHash
+		hash, err := e.hash()
+		if err != nil {
+			return nil, err
+		}
+		e.Hash = hash
+		prevHash = hash
+
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		lines.Write(line)
+		lines.WriteByte('\n')
+		added[i] = e
+	}
+
+	if l.file != nil && lines.Len() > 0 {
+		if err := l.write(lines.Bytes()); err != nil {
+			return nil, fmt.Errorf("could not write to the audit log: %w", err)
+		}
+	}
+	l.entries = append(l.entries, added...)
+	return added, nil
+}
+
+// write appends lines to the file of l, truncating it back to its size
+// before if that fails. It must be called with the lock held.
+func (l *Log) write(lines []byte) error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(lines); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		if truncErr := l.file.Truncate(info.Size()); truncErr != nil {
+			return fmt.Errorf("%w, and could not truncate it back: %v", err, truncErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// head returns the Seq and Hash of the last entry. It must be called with the
+// lock held.
+func (l *Log) head() (int64, string) {
+	if len(l.entries) == 0 {
+		return 0, genesisHash
+	}
+	last := l.entries[len(l.entries)-1]
+	return last.Seq, last.Hash
+}
+
+// Query returns the entries that match f, oldest first
+func (l *Log) Query(f Filter) []Entry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	var found = []Entry{}
+	for _, e := range l.entries {
+		if f.Limit > 0 && len(found) == f.Limit {
+			break
+		}
+		if e.Seq <= f.After || e.Tenant != f.Tenant {
+			continue
+		}
+		if (f.PetID != 0 && e.PetID != f.PetID) || (f.Actor != "" && e.Actor != f.Actor) {
+			continue
+		}
+		if e.Time.Before(f.Since) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found
+}
+
+// Verify returns an error wrapping ErrTampered if the hash chain of l is
+// broken
+func (l *Log) Verify() error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return verify(l.entries)
+}
+
+func verify(entries []Entry) error {
+	prevHash := genesisHash
+	for i, e := range entries {
+		if e.Seq != int64(i+1) {
+			return fmt.Errorf("%w: entry %d has seq %d", ErrTampered, i+1, e.Seq)
+		}
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("%w: entry %d does not follow the one before it", ErrTampered, e.Seq)
+		}
+		hash, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if e.Hash != hash {
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrTampered, e.Seq)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// hash returns the hash of e, which covers all of its fields but Hash itself
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
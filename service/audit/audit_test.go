@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog_Query(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLog()
+	l.now = func() time.Time { return now }
+
+	_, err := l.Append(
+		Entry{Actor: "api_key:a", Action: "pet.created", PetID: 1, New: json.RawMessage(`{"id":1}`)},
+		Entry{Actor: "api_key:b", Action: "pet.created", PetID: 2, New: json.RawMessage(`{"id":2}`)},
+	)
+	assert.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = l.Append(Entry{Actor: "api_key:a", Action: "pet.deleted", PetID: 1, Old: json.RawMessage(`{"id":1}`)})
+	assert.NoError(t, err)
+	_, err = l.Append(Entry{Tenant: "acme", Actor: "api_key:a", Action: "pet.created", PetID: 1})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []int64
+	}{
+		{name: "no filter should match the entries of the tenant", filter: Filter{}, expected: []int64{1, 2, 3}},
+		{name: "entries should be matched by tenant", filter: Filter{Tenant: "acme"}, expected: []int64{4}},
+		{name: "entries should be matched by pet", filter: Filter{PetID: 1}, expected: []int64{1, 3}},
+		{name: "entries should be matched by actor", filter: Filter{Actor: "api_key:b"}, expected: []int64{2}},
+		{name: "entries should be matched by time", filter: Filter{Since: now}, expected: []int64{3}},
+		{name: "entries should be paged through", filter: Filter{After: 1, Limit: 1}, expected: []int64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seqs = []int64{}
+			for _, e := range l.Query(tt.filter) {
+				seqs = append(seqs, e.Seq)
+			}
+			assert.Equal(t, tt.expected, seqs)
+		})
+	}
+}
+
+func TestLog_Chain(t *testing.T) {
+	l := NewLog()
+	added, err := l.Append(Entry{Action: "pet.created", PetID: 1}, Entry{Action: "pet.updated", PetID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, genesisHash, added[0].PrevHash)
+	assert.Equal(t, added[0].Hash, added[1].PrevHash)
+	assert.Len(t, added[1].Hash, 64)
+	assert.NoError(t, l.Verify())
+
+	// Changing an entry breaks the chain
+	l.entries[0].PetID = 2
+	err = l.Verify()
+	assert.True(t, errors.Is(err, ErrTampered))
+	assert.EqualError(t, err, "audit log has been tampered with: entry 1 does not match its hash")
+	l.entries[0].PetID = 1
+
+	// and so does removing one
+	l.entries = l.entries[1:]
+	assert.EqualError(t, l.Verify(), "audit log has been tampered with: entry 1 has seq 2")
+}
+
+func TestLog_Open(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "audit.log")
+
+	l := NewLog()
+	assert.NoError(t, l.Open(path))
+	_, err = l.Append(Entry{Actor: "api_key:a", Action: "pet.created", PetID: 1, New: json.RawMessage(`{"id": 1, "name": "Rex"}`)})
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+
+	// The entries are kept across restarts, and new ones carry on the chain
+	reopened := NewLog()
+	assert.NoError(t, reopened.Open(path))
+	_, err = reopened.Append(Entry{Actor: "api_key:a", Action: "pet.deleted", PetID: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Close())
+
+	reopened = NewLog()
+	assert.NoError(t, reopened.Open(path))
+	entries := reopened.Query(Filter{})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, `{"id":1,"name":"Rex"}`, string(entries[0].New))
+	assert.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	assert.NoError(t, reopened.Close())
+
+	// Files that were changed aren't opened
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(string(content), "Rex", "Max", 1)), 0600))
+	err = NewLog().Open(path)
+	assert.True(t, errors.Is(err, ErrTampered))
+	assert.EqualError(t, err, path+": audit log has been tampered with: entry 1 does not match its hash")
+}
+
+// tornFile writes half of what it is given, and fails
+type tornFile struct {
+	*os.File
+}
+
+func (f tornFile) Write(b []byte) (int, error) {
+	n, _ := f.File.Write(b[:len(b)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestLog_Append_Torn(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "audit.log")
+
+	l := NewLog()
+	assert.NoError(t, l.Open(path))
+	_, err = l.Append(Entry{Action: "pet.created", PetID: 1})
+	assert.NoError(t, err)
+
+	// Writes that fail half way leave neither the entries nor a torn line
+	f := l.file.(*os.File)
+	l.file = tornFile{f}
+	_, err = l.Append(Entry{Action: "pet.updated", PetID: 1})
+	assert.EqualError(t, err, "could not write to the audit log: no space left on device")
+	assert.Len(t, l.Query(Filter{}), 1)
+
+	// so the log carries on, and can be opened again
+	l.file = f
+	_, err = l.Append(Entry{Action: "pet.deleted", PetID: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+
+	reopened := NewLog()
+	assert.NoError(t, reopened.Open(path))
+	entries := reopened.Query(Filter{})
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "pet.deleted", entries[1].Action)
+	}
+	assert.NoError(t, reopened.Close())
+}
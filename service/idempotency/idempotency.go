@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a stored HTTP response that can be replayed for a retried request
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Record is what the store keeps for an idempotency key
+type Record struct {
+	Key         string
+	Fingerprint string
+	Response    *Response
+	ExpiresAt   time.Time
+}
+
+// ErrFingerprintMismatch is returned when a key is reused for a different request
+var ErrFingerprintMismatch = fmt.Errorf("idempotency key has already been used for a different request")
+
+// ErrInProgress is returned when a request with the same key is still being processed
+var ErrInProgress = fmt.Errorf("a request with this idempotency key is still being processed")
+
+// Store keeps the first response for each idempotency key for a fixed window
+type Store struct {
+	window    time.Duration
+	records   map[string]*Record
+	lock      sync.Mutex
+	nextPurge time.Time
+	now       func() time.Time
+}
+
+// purgeInterval is how often expired records are cleared out of the store
+var purgeInterval = time.Minute
+
+// NewStore creates a new Store that keeps responses for the given window
+func NewStore(window time.Duration) *Store {
+	return &Store{
+		window:  window,
+		records: make(map[string]*Record),
+		now:     time.Now,
+	}
+}
+
+// Begin reserves key for a request with the given fingerprint. If the key is
+// new, it returns a nil Record and the caller should process the request and
+// then call Complete or Release. If the key has already been completed for the
+// same fingerprint, the stored Record is returned for replay.
+func (s *Store) Begin(key, fingerprint string) (*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	s.purge(now)
+
+	rec, exists := s.records[key]
+	if exists && now.After(rec.ExpiresAt) {
+		delete(s.records, key)
+		exists = false
+	}
+	if !exists {
+		s.records[key] = &Record{
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(s.window),
+		}
+		return nil, nil
+	}
+
+	if rec.Fingerprint != fingerprint {
+		return nil, ErrFingerprintMismatch
+	}
+	if rec.Response == nil {
+		return nil, ErrInProgress
+	}
+
+	r := *rec
+	return &r, nil
+}
+
+// Complete stores the response for a key reserved by Begin
+func (s *Store) Complete(key string, resp Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rec, exists := s.records[key]
+	if !exists {
+		return
+	}
+	rec.Response = &resp
+}
+
+// Release drops the reservation for a key so that the request can be retried
+func (s *Store) Release(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rec, exists := s.records[key]
+	if exists && rec.Response == nil {
+		delete(s.records, key)
+	}
+}
+
+// purge removes expired records. It must be called with the lock held.
+func (s *Store) purge(now time.Time) {
+	if now.Before(s.nextPurge) {
+		return
+	}
+	for key, rec := range s.records {
+		if now.After(rec.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	s.nextPurge = now.Add(purgeInterval)
+}
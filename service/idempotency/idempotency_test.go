@@ -0,0 +1,96 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	resp := Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       []byte(`{}`),
+	}
+
+	tests := []struct {
+		name        string
+		key         string
+		fingerprint string
+		advance     time.Duration
+		complete    bool
+		release     bool
+		isReplay    bool
+		err         error
+	}{
+		{
+			name:        "a new key should be reserved",
+			key:         "a",
+			fingerprint: "one",
+		},
+		{
+			name:        "reusing a key that is still in progress should error",
+			key:         "a",
+			fingerprint: "one",
+			err:         ErrInProgress,
+			complete:    true,
+		},
+		{
+			name:        "reusing a completed key should return the stored response",
+			key:         "a",
+			fingerprint: "one",
+			isReplay:    true,
+		},
+		{
+			name:        "reusing a completed key with a different fingerprint should error",
+			key:         "a",
+			fingerprint: "two",
+			err:         ErrFingerprintMismatch,
+		},
+		{
+			name:        "a released key should be reserved again",
+			key:         "b",
+			fingerprint: "one",
+			release:     true,
+		},
+		{
+			name:        "a released key can be used with a different fingerprint",
+			key:         "b",
+			fingerprint: "two",
+		},
+		{
+			name:        "an expired key should be reserved again",
+			key:         "a",
+			fingerprint: "two",
+			advance:     2 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+
+			rec, err := s.Begin(tt.key, tt.fingerprint)
+			assert.Equal(t, tt.err, err)
+			if tt.isReplay {
+				assert.Equal(t, &resp, rec.Response)
+			} else {
+				assert.Nil(t, rec)
+			}
+
+			if tt.complete {
+				s.Complete(tt.key, resp)
+			}
+			if tt.release {
+				s.Release(tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status is the state a job is in
+type Status string
+
+// Job statuses
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Job is a snapshot of a background job
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     Status     `json:"status"`
+	Progress   Progress   `json:"progress"`
+	Errors     []string   `json:"errors"`
+	ResultURL  string     `json:"result_url,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// HasResult is true once the job has finished with a result to fetch
+	HasResult bool `json:"-"`
+	// Owner is who submitted the job, e.g. a tenant, so that only they
+	// are shown it
+	Owner string `json:"-"`
+}
+
+// Progress is how far along a job is. Total is 0 when it isn't known.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Result is the output of a job that has finished
+type Result struct {
+	ContentType string
+	Data        []byte
+}
+
+// Func is the work done by a job. It should stop early when ctx is done, and
+// report progress through t. It is called even for a job that was canceled
+// while queued, with ctx already done, so that it can release what it holds.
+type Func func(ctx context.Context, t *Tracker) (*Result, error)
+
+// Finished returns true if the job is not going to change anymore
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// ErrNotExist is returned when there is no job with the given ID
+var ErrNotExist = fmt.Errorf("job does not exist")
+
+// ErrFinished is returned when trying to cancel a job that has already finished
+var ErrFinished = fmt.Errorf("job has already finished")
+
+// ErrNotFinished is returned when asking for the result of a job that is still going
+var ErrNotFinished = fmt.Errorf("job has not finished yet")
+
+// ErrNoResult is returned when asking for the result of a job that doesn't have one
+var ErrNoResult = fmt.Errorf("job does not have a result")
+
+// MaxErrors is the max number of errors kept for a job
+var MaxErrors = 1000
+
+// Tracker is how a running job reports its progress and errors. A nil
+// Tracker is valid and ignores everything, so the same code can run with or
+// without a job.
+type Tracker struct {
+	job *job
+}
+
+// SetTotal sets the total amount of work the job has to do
+func (t *Tracker) SetTotal(total int) {
+	if t == nil {
+		return
+	}
+	t.job.lock.Lock()
+	defer t.job.lock.Unlock()
+	t.job.Progress.Total = total
+}
+
+// Advance records n more units of work as done
+func (t *Tracker) Advance(n int) {
+	if t == nil {
+		return
+	}
+	t.job.lock.Lock()
+	defer t.job.lock.Unlock()
+	t.job.Progress.Done += n
+}
+
+// AddError records a non-fatal error for the job
+func (t *Tracker) AddError(err error) {
+	if t == nil {
+		return
+	}
+	t.job.lock.Lock()
+	defer t.job.lock.Unlock()
+	if len(t.job.Errors) < MaxErrors {
+		t.job.Errors = append(t.job.Errors, err.Error())
+	}
+}
+
+// job is the runner's internal, mutable copy of a Job
+type job struct {
+	Job
+	fn     Func
+	result *Result
+	ctx    context.Context
+	cancel context.CancelFunc
+	lock   sync.Mutex
+}
+
+func (j *job) snapshot() Job {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	s := j.Job
+	s.Errors = append([]string{}, j.Errors...)
+	s.HasResult = j.result != nil && j.Status == StatusSucceeded
+	return s
+}
+
+// newJobID returns a random 128 bit ID, hex encoded
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
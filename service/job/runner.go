@@ -0,0 +1,273 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+)
+
+// ErrQueueFull is returned when the runner has no room for another job
+var ErrQueueFull = fmt.Errorf("too many jobs queued, try again later")
+
+// ErrShutdown is returned when submitting a job to a runner that is shutting down
+var ErrShutdown = fmt.Errorf("job runner is shutting down")
+
+// Retention is how long finished jobs are kept around
+var Retention = time.Hour
+
+// Runner runs jobs on a fixed pool of workers
+type Runner struct {
+	queue    chan *job
+	jobs     map[string]*job
+	lock     sync.RWMutex
+	workers  sync.WaitGroup
+	shutdown bool
+}
+
+// Defaults for the DefaultRunner
+var (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 100
+)
+
+// DefaultRunner is the runner used by the API
+var DefaultRunner = NewRunner(DefaultWorkers, DefaultQueueSize)
+
+// NewRunner creates a new Runner and starts its workers
+func NewRunner(workers, queueSize int) *Runner {
+	r := &Runner{
+		queue: make(chan *job, queueSize),
+		jobs:  make(map[string]*job),
+	}
+	for i := 0; i < workers; i++ {
+		r.workers.Add(1)
+		go r.work()
+	}
+	return r
+}
+
+// Submit queues fn to be run as a job of the given type, without an owner
+func (r *Runner) Submit(jobType string, fn Func) (Job, error) {
+	return r.SubmitFor("", jobType, fn)
+}
+
+// SubmitFor queues fn to be run as a job of the given type, for owner
+func (r *Runner) SubmitFor(owner, jobType string, fn Func) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Job: Job{
+			ID:        id,
+			Type:      jobType,
+			Owner:     owner,
+			Status:    StatusQueued,
+			Errors:    []string{},
+			CreatedAt: time.Now().UTC(),
+		},
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.shutdown {
+		cancel()
+		return Job{}, ErrShutdown
+	}
+	r.purge()
+
+	select {
+	case r.queue <- j:
+	default:
+		cancel()
+		return Job{}, ErrQueueFull
+	}
+	r.jobs[id] = j
+
+	return j.snapshot(), nil
+}
+
+// Get returns the job with the given ID
+func (r *Runner) Get(id string) (Job, error) {
+	j, err := r.get(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return j.snapshot(), nil
+}
+
+// GetResult returns the result of the job with the given ID
+func (r *Runner) GetResult(id string) (*Result, error) {
+	j, err := r.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if !j.Status.Finished() {
+		return nil, ErrNotFinished
+	}
+	if j.result == nil || j.Status != StatusSucceeded {
+		return nil, ErrNoResult
+	}
+	return j.result, nil
+}
+
+// Cancel stops the job with the given ID. A queued job is canceled right away,
+// a running one once it notices its context is done.
+func (r *Runner) Cancel(id string) (Job, error) {
+	j, err := r.get(id)
+	if err != nil {
+		return Job{}, err
+	}
+
+	j.lock.Lock()
+	if j.Status.Finished() {
+		j.lock.Unlock()
+		return Job{}, ErrFinished
+	}
+	if j.Status == StatusQueued {
+		j.finish(StatusCanceled)
+	}
+	j.lock.Unlock()
+
+	j.cancel()
+	return j.snapshot(), nil
+}
+
+// Shutdown stops accepting jobs and waits for the queued and running ones to
+// finish. If ctx is done first, the remaining jobs are canceled and Shutdown
+// waits for the workers to notice before returning ctx's error.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.lock.Lock()
+	if !r.shutdown {
+		r.shutdown = true
+		close(r.queue)
+	}
+	r.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	r.lock.RLock()
+	for _, j := range r.jobs {
+		j.cancel()
+	}
+	r.lock.RUnlock()
+	<-done
+	return ctx.Err()
+}
+
+// IsShutdown returns true once Shutdown has been called
+func (r *Runner) IsShutdown() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.shutdown
+}
+
+func (r *Runner) get(id string) (*job, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	j, exists := r.jobs[id]
+	if !exists {
+		return nil, ErrNotExist
+	}
+	return j, nil
+}
+
+// purge drops the jobs that finished more than Retention ago. It must be
+// called with the lock held.
+func (r *Runner) purge() {
+	cutoff := time.Now().Add(-Retention)
+	for id, j := range r.jobs {
+		j.lock.Lock()
+		expired := j.FinishedAt != nil && j.FinishedAt.Before(cutoff)
+		j.lock.Unlock()
+		if expired {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+func (r *Runner) work() {
+	defer r.workers.Done()
+	for j := range r.queue {
+		r.run(j)
+	}
+}
+
+func (r *Runner) run(j *job) {
+	j.lock.Lock()
+	// It may have been canceled while it was queued, the Func still gets
+	// called so it can clean up
+	if j.Status != StatusQueued || j.ctx.Err() != nil {
+		if !j.Status.Finished() {
+			j.finish(StatusCanceled)
+		}
+		j.lock.Unlock()
+		runFunc(j, nil)
+		return
+	}
+	now := time.Now().UTC()
+	j.StartedAt = &now
+	j.Status = StatusRunning
+	j.lock.Unlock()
+
+	clog.Debugf("Job: starting %s job %s", j.Type, j.ID)
+	result, err := runFunc(j, &Tracker{job: j})
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	switch {
+	case j.ctx.Err() != nil:
+		j.finish(StatusCanceled)
+	case err != nil:
+		if len(j.Errors) < MaxErrors {
+			j.Errors = append(j.Errors, err.Error())
+		}
+		j.finish(StatusFailed)
+	default:
+		j.result = result
+		j.finish(StatusSucceeded)
+	}
+	j.cancel()
+	clog.Debugf("Job: %s job %s finished as %s", j.Type, j.ID, j.Status)
+}
+
+// runFunc runs the job's Func, turning a panic into an error so that one bad
+// job can't take a worker down with it
+func runFunc(j *job, t *Tracker) (result *Result, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("job panicked: %v", rec)
+		}
+	}()
+	return j.fn(j.ctx, t)
+}
+
+// finish marks the job as done. It must be called with the job's lock held.
+func (j *job) finish(status Status) {
+	now := time.Now().UTC()
+	j.Status = status
+	j.FinishedAt = &now
+}
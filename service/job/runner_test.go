@@ -0,0 +1,207 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+)
+
+// waitFor polls the job until it has finished
+func waitFor(t *testing.T, r *Runner, id string) Job {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		j, err := r.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status.Finished() {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return Job{}
+}
+
+func TestRunner(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+	}()
+
+	r := NewRunner(2, 10)
+	defer r.Shutdown(context.Background())
+
+	tests := []struct {
+		name           string
+		fn             Func
+		expectedStatus Status
+		expectedErrors []string
+		expectedResult *Result
+		expectedDone   int
+	}{
+		{
+			name: "a job that succeeds should keep its result and progress",
+			fn: func(ctx context.Context, t *Tracker) (*Result, error) {
+				t.SetTotal(3)
+				t.Advance(2)
+				t.AddError(fmt.Errorf("row 2 was bad"))
+				t.Advance(1)
+				return &Result{ContentType: "text/plain", Data: []byte("done")}, nil
+			},
+			expectedStatus: StatusSucceeded,
+			expectedErrors: []string{"row 2 was bad"},
+			expectedResult: &Result{ContentType: "text/plain", Data: []byte("done")},
+			expectedDone:   3,
+		},
+		{
+			name: "a job that errors should fail",
+			fn: func(ctx context.Context, t *Tracker) (*Result, error) {
+				return nil, fmt.Errorf("something went wrong")
+			},
+			expectedStatus: StatusFailed,
+			expectedErrors: []string{"something went wrong"},
+		},
+		{
+			name: "a job that panics should fail",
+			fn: func(ctx context.Context, t *Tracker) (*Result, error) {
+				panic("oops")
+			},
+			expectedStatus: StatusFailed,
+			expectedErrors: []string{"job panicked: oops"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := r.Submit("test", tt.fn)
+			assert.Nil(t, err)
+			assert.Equal(t, StatusQueued, j.Status)
+
+			j = waitFor(t, r, j.ID)
+			assert.Equal(t, tt.expectedStatus, j.Status)
+			assert.Equal(t, tt.expectedErrors, j.Errors)
+			assert.Equal(t, tt.expectedDone, j.Progress.Done)
+			assert.NotNil(t, j.StartedAt)
+			assert.NotNil(t, j.FinishedAt)
+
+			result, err := r.GetResult(j.ID)
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedResult == nil {
+				assert.Equal(t, ErrNoResult, err)
+			}
+		})
+	}
+
+	_, err := r.Get("missing")
+	assert.Equal(t, ErrNotExist, err)
+}
+
+func TestRunner_Cancel(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+	}()
+
+	// One worker, so the second job stays queued behind the first
+	r := NewRunner(1, 10)
+	defer r.Shutdown(context.Background())
+
+	started := make(chan struct{})
+	running, err := r.Submit("test", func(ctx context.Context, t *Tracker) (*Result, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Nil(t, err)
+
+	var cleanedUp = make(chan bool, 1)
+	queued, err := r.Submit("test", func(ctx context.Context, t *Tracker) (*Result, error) {
+		cleanedUp <- ctx.Err() != nil
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	<-started
+
+	// Canceling a queued job should take effect right away
+	j, err := r.Cancel(queued.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusCanceled, j.Status)
+
+	// Canceling a running job should stop it
+	j, err = r.Cancel(running.ID)
+	assert.Nil(t, err)
+	j = waitFor(t, r, running.ID)
+	assert.Equal(t, StatusCanceled, j.Status)
+
+	// The queued job should still get to clean up, with its context done
+	assert.True(t, <-cleanedUp)
+
+	// Canceling a finished job should error
+	_, err = r.Cancel(running.ID)
+	assert.Equal(t, ErrFinished, err)
+}
+
+func TestRunner_Shutdown(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() {
+		clog.LogLevel = 0
+	}()
+
+	r := NewRunner(1, 10)
+
+	// A job that finishes on its own should be waited for
+	finished, err := r.Submit("test", func(ctx context.Context, t *Tracker) (*Result, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	})
+	assert.Nil(t, err)
+
+	// A job that never finishes on its own should be canceled once the
+	// shutdown context is done
+	stuck, err := r.Submit("test", func(ctx context.Context, t *Tracker) (*Result, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Nil(t, err)
+
+	assert.False(t, r.IsShutdown())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = r.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, r.IsShutdown())
+
+	j, _ := r.Get(finished.ID)
+	assert.Equal(t, StatusSucceeded, j.Status)
+	j, _ = r.Get(stuck.ID)
+	assert.Equal(t, StatusCanceled, j.Status)
+
+	// No more jobs should be accepted
+	_, err = r.Submit("test", func(ctx context.Context, t *Tracker) (*Result, error) {
+		return nil, nil
+	})
+	assert.Equal(t, ErrShutdown, err)
+}
+
+func TestRunner_QueueFull(t *testing.T) {
+
+	// No workers, so nothing leaves the queue
+	r := NewRunner(0, 1)
+
+	var fn = func(ctx context.Context, t *Tracker) (*Result, error) { return nil, nil }
+	_, err := r.Submit("test", fn)
+	assert.Nil(t, err)
+	_, err = r.Submit("test", fn)
+	assert.Equal(t, ErrQueueFull, err)
+}
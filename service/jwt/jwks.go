@@ -0,0 +1,278 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+)
+
+// jwk is a JSON Web Key, as in RFC 7517. Only the public RSA and EC keys
+// used to sign tokens are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a key tokens can be verified with
+type publicKey struct {
+	kid string
+	// alg is the algorithm the key is for, any that fits it if empty
+	alg string
+	key crypto.PublicKey
+}
+
+// parseJWKS returns the signing keys in a JSON Web Key Set. Keys that aren't
+// for signing, or of a type that isn't supported, are skipped.
+func parseJWKS(data []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	var keys []publicKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS: key %d: %w", i, err)
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("n: %w", err)
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("e: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("e: unsupported exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("x: %w", err)
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("y: %w", err)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("is empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// KeySet holds the keys of a JWKS, fetched again every refresh interval so
+// that rotated keys are picked up. A token signed with a key that isn't in the
+// set also fetches it again, at most once per MinRefreshInterval.
+type KeySet struct {
+	fetch           func() ([]byte, error)
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	lock      sync.Mutex
+	keys      []publicKey
+	attempted time.Time
+	// refreshing is the fetch that is going on, if any, which others wait
+	// for rather than fetching again
+	refreshing *refreshCall
+}
+
+// refreshCall is a fetch of a KeySet, err is set once done is closed
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// MinRefreshInterval is the least time between fetching a KeySet again for
+// tokens signed with a key that isn't in it, so that such tokens can't be used
+// to flood the issuer with requests
+var MinRefreshInterval = time.Minute
+
+// NewFileKeySet returns a KeySet read from the JWKS file at path
+func NewFileKeySet(path string, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func() ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}, refreshInterval)
+}
+
+// NewURLKeySet returns a KeySet fetched from the JWKS at url, e.g. the
+// jwks_uri of an OpenID Connect issuer
+func NewURLKeySet(url string, client *http.Client, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}, refreshInterval)
+}
+
+func newKeySet(fetch func() ([]byte, error), refreshInterval time.Duration) *KeySet {
+	return &KeySet{fetch: fetch, refreshInterval: refreshInterval, now: time.Now}
+}
+
+// Refresh fetches the keys again. If it fails, the keys fetched last are
+// kept.
+func (s *KeySet) Refresh() error {
+	return s.refreshIf(func() bool { return true }, true)
+}
+
+// refreshIf fetches the keys again if due returns true. due is called with
+// s.lock held, while the fetch itself is made without it so that tokens can
+// still be verified with the keys there are. If a fetch is already going on,
+// it is waited for if wait is true, rather than fetching again.
+func (s *KeySet) refreshIf(due func() bool, wait bool) error {
+	s.lock.Lock()
+	if call := s.refreshing; call != nil {
+		s.lock.Unlock()
+		if !wait {
+			return nil
+		}
+		<-call.done
+		return call.err
+	}
+	if !due() {
+		s.lock.Unlock()
+		return nil
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	s.refreshing = call
+	s.attempted = s.now()
+	s.lock.Unlock()
+
+	keys, err := s.fetchKeys()
+
+	s.lock.Lock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.refreshing = nil
+	s.lock.Unlock()
+
+	call.err = err
+	close(call.done)
+	return err
+}
+
+func (s *KeySet) fetchKeys() ([]publicKey, error) {
+	data, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// find returns the keys that a token with kid and alg may be signed with.
+// Keys that are due a refresh are still used while it is going on, but a
+// token with a key that isn't known waits for it.
+func (s *KeySet) find(kid, alg string) []publicKey {
+	err := s.refreshIf(func() bool {
+		return s.now().Sub(s.attempted) >= s.refreshInterval
+	}, false)
+	if err != nil {
+		clog.Errorf("JWT: could not refresh the JWKS, still using the old keys: %v", err)
+	}
+	keys := s.match(kid, alg)
+	if len(keys) > 0 {
+		return keys
+	}
+
+	err = s.refreshIf(func() bool {
+		return s.now().Sub(s.attempted) >= MinRefreshInterval
+	}, true)
+	if err != nil {
+		clog.Errorf("JWT: could not refresh the JWKS for key %q: %v", kid, err)
+	}
+	return s.match(kid, alg)
+}
+
+// match returns the keys with kid, or all of them if kid is empty, that can
+// verify alg
+func (s *KeySet) match(kid, alg string) []publicKey {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var keys []publicKey
+	for _, k := range s.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if !algorithms[alg].fits(k.key) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,179 @@
+package jwt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/teejays/clog"
+)
+
+func TestParseJWKS(t *testing.T) {
+
+	keys, err := parseJWKS(newTestIssuer(t).jwks())
+	assert.NoError(t, err)
+	// The key for encryption is skipped
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "rsa", keys[0].kid)
+		assert.Equal(t, "ec", keys[1].kid)
+		assert.Equal(t, "ES256", keys[1].alg)
+	}
+
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}]}`))
+	assert.EqualError(t, err, `invalid JWKS: key 0: unsupported curve "P-192"`)
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`))
+	assert.EqualError(t, err, `invalid JWKS: key 0: point is not on curve P-256`)
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "RSA", "n": "", "e": "AQAB"}]}`))
+	assert.EqualError(t, err, `invalid JWKS: key 0: n: is empty`)
+	_, err = parseJWKS([]byte(`[`))
+	assert.Error(t, err)
+
+	// Key types that aren't supported are skipped
+	keys, err = parseJWKS([]byte(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestKeySet_File(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "jwks.json")
+
+	old, rotated := newTestIssuer(t), newTestIssuer(t)
+	if err := ioutil.WriteFile(path, old.jwks(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := NewFileKeySet(path, time.Hour)
+	keys.now = func() time.Time { return now }
+	assert.NoError(t, keys.Refresh())
+	assert.Len(t, keys.find("rsa", "RS256"), 1)
+	assert.Empty(t, keys.find("missing", "RS256"))
+
+	// Keys that are rotated are picked up at the next refresh
+	if err := ioutil.WriteFile(path, rotated.jwks(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, old.rsa.Public(), keys.find("rsa", "RS256")[0].key)
+	now = now.Add(time.Hour)
+	assert.Equal(t, rotated.rsa.Public(), keys.find("rsa", "RS256")[0].key)
+
+	// The old keys are kept when the file can't be read
+	assert.NoError(t, os.Remove(path))
+	now = now.Add(time.Hour)
+	assert.Len(t, keys.find("rsa", "RS256"), 1)
+	assert.Error(t, keys.Refresh())
+}
+
+func TestKeySet_URL(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	issuer := newTestIssuer(t)
+	var fetches int
+	var up bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if !up {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(issuer.jwks())
+	}))
+	defer srv.Close()
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := NewURLKeySet(srv.URL, srv.Client(), time.Hour)
+	keys.now = func() time.Time { return now }
+
+	// The issuer isn't up yet
+	err := keys.Refresh()
+	assert.EqualError(t, err, "fetching "+srv.URL+": 503 Service Unavailable")
+	assert.Empty(t, keys.find("rsa", "RS256"))
+	assert.Equal(t, 1, fetches)
+
+	// Tokens with keys that aren't known fetch the keys again, but only
+	// once per MinRefreshInterval
+	up = true
+	assert.Empty(t, keys.find("rsa", "RS256"))
+	assert.Equal(t, 1, fetches)
+	now = now.Add(MinRefreshInterval)
+	assert.Len(t, keys.find("rsa", "RS256"), 1)
+	assert.Equal(t, 2, fetches)
+	assert.Len(t, keys.find("rsa", "RS256"), 1)
+	assert.Empty(t, keys.find("missing", "RS256"))
+	assert.Equal(t, 2, fetches)
+
+	v := NewVerifier("https://issuer.test", "pets", keys, 0)
+	_, err = v.Verify(issuer.sign("ES256", "ec", map[string]interface{}{
+		"iss": "https://issuer.test", "aud": "pets", "exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	assert.NoError(t, err)
+}
+
+func TestKeySet_Concurrent(t *testing.T) {
+
+	// Reduce the amount of logs
+	clog.LogLevel = 6
+	defer func() { clog.LogLevel = 0 }()
+
+	issuer := newTestIssuer(t)
+	var fetches int32
+	var release = make(chan struct{})
+	keys := newKeySet(func() ([]byte, error) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		return issuer.jwks(), nil
+	}, time.Hour)
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+	assert.NoError(t, keys.Refresh())
+	now = now.Add(MinRefreshInterval)
+
+	// Tokens with a key that isn't known fetch the keys again
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys.find("missing", "RS256")
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// while the known keys can still be found
+	found := make(chan int)
+	go func() { found <- len(keys.find("rsa", "RS256")) }()
+	select {
+	case n := <-found:
+		assert.Equal(t, 1, n)
+	case <-time.After(time.Second):
+		t.Fatal("finding a known key waited for the fetch")
+	}
+
+	// and they all share the one fetch
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
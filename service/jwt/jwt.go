@@ -0,0 +1,284 @@
+// Package jwt verifies JSON Web Tokens, as in RFC 7519, signed by an issuer
+// with one of the keys in its JWKS.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes for the algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalid is the error for tokens that can't be trusted. Errors about
+	// why a token can't be trusted wrap it.
+	ErrInvalid = errors.New("token is not valid")
+	// ErrExpired is the error for tokens that have expired
+	ErrExpired = errors.New("token has expired")
+
+	errMalformed          = fmt.Errorf("%w: malformed token", ErrInvalid)
+	errAlgorithm          = fmt.Errorf("%w: unsupported signing algorithm", ErrInvalid)
+	errUnknownKey         = fmt.Errorf("%w: signed with an unknown key", ErrInvalid)
+	errSignature          = fmt.Errorf("%w: invalid signature", ErrInvalid)
+	errIssuer             = fmt.Errorf("%w: wrong issuer", ErrInvalid)
+	errAudience           = fmt.Errorf("%w: wrong audience", ErrInvalid)
+	errNoExpiry           = fmt.Errorf("%w: no expiry", ErrInvalid)
+	errNotYetValid        = fmt.Errorf("%w: not valid yet", ErrInvalid)
+	errInvalidNumericDate = fmt.Errorf("%w: invalid date", ErrInvalid)
+)
+
+// algorithm is a signing algorithm for tokens
+type algorithm struct {
+	hash crypto.Hash
+	// verify returns whether sig is a signature of digest with key
+	verify func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool
+	// fits returns whether key can verify the algorithm
+	fits func(key crypto.PublicKey) bool
+}
+
+// algorithms are the asymmetric algorithms tokens can be signed with.
+// Symmetric ones, and "none", are left out on purpose: the keys are public.
+var algorithms = map[string]algorithm{
+	"RS256": rsaPKCS1(crypto.SHA256),
+	"RS384": rsaPKCS1(crypto.SHA384),
+	"RS512": rsaPKCS1(crypto.SHA512),
+	"PS256": rsaPSS(crypto.SHA256),
+	"PS384": rsaPSS(crypto.SHA384),
+	"PS512": rsaPSS(crypto.SHA512),
+	"ES256": ecdsaCurve(crypto.SHA256, 256),
+	"ES384": ecdsaCurve(crypto.SHA384, 384),
+	"ES512": ecdsaCurve(crypto.SHA512, 521),
+}
+
+func isRSA(key crypto.PublicKey) bool {
+	_, ok := key.(*rsa.PublicKey)
+	return ok
+}
+
+func rsaPKCS1(hash crypto.Hash) algorithm {
+	return algorithm{
+		hash: hash,
+		verify: func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+			return rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), hash, digest, sig) == nil
+		},
+		fits: isRSA,
+	}
+}
+
+func rsaPSS(hash crypto.Hash) algorithm {
+	return algorithm{
+		hash: hash,
+		verify: func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(key.(*rsa.PublicKey), hash, digest, sig, opts) == nil
+		},
+		fits: isRSA,
+	}
+}
+
+// ecdsaCurve returns the ECDSA algorithm with hash, on the curve that has
+// bitSize
+func ecdsaCurve(hash crypto.Hash, bitSize int) algorithm {
+	return algorithm{
+		hash: hash,
+		verify: func(key crypto.PublicKey, hash crypto.Hash, digest, sig []byte) bool {
+			// The signature is r and s, each the size of the curve
+			size := (bitSize + 7) / 8
+			if len(sig) != 2*size {
+				return false
+			}
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			return ecdsa.Verify(key.(*ecdsa.PublicKey), digest, r, s)
+		},
+		fits: func(key crypto.PublicKey) bool {
+			k, ok := key.(*ecdsa.PublicKey)
+			return ok && k.Curve.Params().BitSize == bitSize
+		},
+	}
+}
+
+// Claims are the claims of a token
+type Claims map[string]interface{}
+
+// Subject returns the "sub" claim, the principal the token is about
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// String returns the claim with name if it is a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim with name if it is a string or a list of them,
+// like "aud". Space separated strings, like "scope", are split.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var s []string
+		for _, e := range v {
+			if str, ok := e.(string); ok {
+				s = append(s, str)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// Time returns the claim with name if it is a NumericDate, i.e. seconds
+// since the epoch
+func (c Claims) Time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, errInvalidNumericDate
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, errInvalidNumericDate
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true, nil
+}
+
+// Verifier verifies tokens from an issuer, meant for an audience
+type Verifier struct {
+	// Issuer is the "iss" tokens must have
+	Issuer string
+	// Audience is the value "aud" must have, or include
+	Audience string
+	// Keys are the keys tokens may be signed with
+	Keys *KeySet
+	// Leeway is how far clocks may be off when checking "exp" and "nbf"
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// NewVerifier returns a Verifier for tokens from issuer, meant for
+// audience, signed with keys
+func NewVerifier(issuer, audience string, keys *KeySet, leeway time.Duration) *Verifier {
+	return &Verifier{Issuer: issuer, Audience: audience, Keys: keys, Leeway: leeway, now: time.Now}
+}
+
+// Verify returns the claims of token once its signature, issuer, audience
+// and expiry are verified
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		Typ string `json:"typ"`
+	}
+	if err := decodePart(parts[0], &header); err != nil {
+		return nil, errMalformed
+	}
+	alg, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, errAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	// The signature is checked before anything in the payload is trusted
+	keys := v.Keys.find(header.Kid, header.Alg)
+	if len(keys) == 0 {
+		return nil, errUnknownKey
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	verified := false
+	for _, k := range keys {
+		if alg.verify(k.key, alg.hash, digest, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errSignature
+	}
+
+	var claims Claims
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, errMalformed
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims of a token with a valid signature
+func (v *Verifier) validate(claims Claims) error {
+	if claims.String("iss") != v.Issuer {
+		return errIssuer
+	}
+	if v.Audience != "" && !contains(claims.Strings("aud"), v.Audience) {
+		return errAudience
+	}
+
+	now := v.now()
+	exp, ok, err := claims.Time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoExpiry
+	}
+	if !now.Before(exp.Add(v.Leeway)) {
+		return ErrExpired
+	}
+	nbf, ok, err := claims.Time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.Leeway).Before(nbf) {
+		return errNotYetValid
+	}
+	return nil
+}
+
+// decodePart decodes a base64url encoded JSON part of a token into v
+func decodePart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
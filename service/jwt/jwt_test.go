@@ -0,0 +1,234 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testIssuer is a stand-in for an OpenID Connect issuer, that signs tokens
+// with its keys
+type testIssuer struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testIssuer{rsa: rsaKey, ec: ecKey}
+}
+
+// jwks returns the JWKS of the issuer, with the RSA key as kid "rsa" and the
+// EC key as kid "ec"
+func (i testIssuer) jwks() []byte {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(i.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(i.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-256", "alg": "ES256",
+			"x": b64(i.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64(i.ec.Y.FillBytes(make([]byte, 32))),
+		},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	return data
+}
+
+// sign returns a token with claims, signed with alg by the key with kid
+func (i testIssuer) sign(alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	if a, ok := algorithms[alg]; ok {
+		h := a.hash.New()
+		h.Write([]byte(input))
+		digest := h.Sum(nil)
+		switch alg[:2] {
+		case "RS":
+			sig, _ = rsa.SignPKCS1v15(rand.Reader, i.rsa, a.hash, digest)
+		case "PS":
+			sig, _ = rsa.SignPSS(rand.Reader, i.rsa, a.hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case "ES":
+			r, s, _ := ecdsa.Sign(rand.Reader, i.ec, digest)
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifier(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := newTestIssuer(t)
+	keys := newKeySet(func() ([]byte, error) { return issuer.jwks(), nil }, time.Hour)
+	keys.now = func() time.Time { return now }
+	v := NewVerifier("https://issuer.test", "pets", keys, time.Minute)
+	v.now = func() time.Time { return now }
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://issuer.test",
+			"aud": "pets",
+			"sub": "alice",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{
+			name:  "RS256 tokens should be verified",
+			token: issuer.sign("RS256", "rsa", claims(nil)),
+		},
+		{
+			name:  "PS384 tokens should be verified",
+			token: issuer.sign("PS384", "rsa", claims(nil)),
+		},
+		{
+			name:  "ES256 tokens should be verified",
+			token: issuer.sign("ES256", "ec", claims(nil)),
+		},
+		{
+			name:  "tokens without a kid should be verified with any key that fits",
+			token: issuer.sign("ES256", "", claims(nil)),
+		},
+		{
+			name:  "the audience may be one of a list",
+			token: issuer.sign("RS256", "rsa", claims(map[string]interface{}{"aud": []string{"other", "pets"}})),
+		},
+		{
+			name:  "tokens that expired within the leeway should be verified",
+			token: issuer.sign("RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})),
+		},
+		{
+			name:        "expired tokens should be refused",
+			token:       issuer.sign("RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+			expectedErr: ErrExpired,
+		},
+		{
+			name:        "tokens without an expiry should be refused",
+			token:       issuer.sign("RS256", "rsa", claims(map[string]interface{}{"exp": nil})),
+			expectedErr: errNoExpiry,
+		},
+		{
+			name:        "tokens that aren't valid yet should be refused",
+			token:       issuer.sign("RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+			expectedErr: errNotYetValid,
+		},
+		{
+			name:        "tokens from another issuer should be refused",
+			token:       issuer.sign("RS256", "rsa", claims(map[string]interface{}{"iss": "https://other.test"})),
+			expectedErr: errIssuer,
+		},
+		{
+			name:        "tokens for another audience should be refused",
+			token:       issuer.sign("RS256", "rsa", claims(map[string]interface{}{"aud": "other"})),
+			expectedErr: errAudience,
+		},
+		{
+			name:        "unsigned tokens should be refused",
+			token:       issuer.sign("none", "", claims(nil)),
+			expectedErr: errAlgorithm,
+		},
+		{
+			name:        "symmetric algorithms should be refused",
+			token:       issuer.sign("HS256", "rsa", claims(nil)),
+			expectedErr: errAlgorithm,
+		},
+		{
+			name:        "keys should only verify their own algorithm",
+			token:       issuer.sign("RS256", "ec", claims(nil)),
+			expectedErr: errUnknownKey,
+		},
+		{
+			name:        "keys that aren't for signing should not be used",
+			token:       issuer.sign("RS256", "enc", claims(nil)),
+			expectedErr: errUnknownKey,
+		},
+		{
+			name:        "tokens with a changed payload should be refused",
+			token:       tamper(issuer.sign("RS256", "rsa", claims(nil)), claims(map[string]interface{}{"sub": "admin"})),
+			expectedErr: errSignature,
+		},
+		{
+			name:        "malformed tokens should be refused",
+			token:       "not.a-token",
+			expectedErr: errMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := v.Verify(tt.token)
+			assert.Equal(t, tt.expectedErr, err)
+			if tt.expectedErr == nil {
+				assert.Equal(t, "alice", c.Subject())
+			} else {
+				assert.Nil(t, c)
+				assert.True(t, err == ErrExpired || strings.HasPrefix(err.Error(), ErrInvalid.Error()))
+			}
+		})
+	}
+}
+
+// tamper returns token with its payload replaced by claims
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+func TestClaims(t *testing.T) {
+
+	var c Claims
+	assert.NoError(t, decodePart(base64.RawURLEncoding.EncodeToString([]byte(
+		`{"sub": "alice", "scope": "pets:read pets:write", "roles": ["admin", 1], "exp": 1767225600.5}`)), &c))
+
+	assert.Equal(t, "alice", c.Subject())
+	assert.Equal(t, []string{"pets:read", "pets:write"}, c.Strings("scope"))
+	assert.Equal(t, []string{"admin"}, c.Strings("roles"))
+	exp, ok, err := c.Time("exp")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 5e8, time.UTC), exp.UTC())
+
+	_, ok, err = c.Time("nbf")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = c.Time("sub")
+	assert.Equal(t, errInvalidNumericDate, err)
+}
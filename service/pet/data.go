@@ -1,14 +1,28 @@
 package pet
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
 	"sync"
+
+	"../audit"
 )
 
-var data = []Pet{}
-var dataMapID map[int64]int
+// DefaultTenant is the tenant of the pets when the API isn't shared between
+// tenants
+const DefaultTenant = ""
+
+// tenantData holds the pets of a tenant, along with their index by ID. IDs
+// are only unique within a tenant.
+type tenantData struct {
+	pets  []Pet
+	index map[int64]int
+}
+
+// tenants holds the pets of every tenant, by tenant
+var tenants = map[string]*tenantData{}
 var dataLock sync.RWMutex
 
 // ResetData is the exported wrapper for resetData()
@@ -19,67 +33,296 @@ func ResetData() {
 func resetData() {
 	dataLock.Lock()
 	defer dataLock.Unlock()
-	data = []Pet{}
-	dataMapID = make(map[int64]int)
+	tenants = map[string]*tenantData{}
+	outbox = []outboxRecord{}
+}
+
+// getTenant returns the pets of tenant, or nil if it has none. It must be
+// called with the lock held.
+func getTenant(tenant string) *tenantData {
+	return tenants[tenant]
+}
+
+// getOrAddTenant returns the pets of tenant, adding them if it has none. It
+// must be called with the write lock held.
+func getOrAddTenant(tenant string) *tenantData {
+	d := tenants[tenant]
+	if d == nil {
+		d = &tenantData{pets: []Pet{}, index: make(map[int64]int)}
+		tenants[tenant] = d
+	}
+	return d
 }
 
 // ErrNotExist represents entity not found in DB error
 var ErrNotExist = fmt.Errorf("entity does not exist")
 
-// AddPet adds a new pet
-func AddPet(p Pet) error {
+// Tx is a unit of work against the pet store. Writes made through a Tx, along
+// with the events and audit entries they produce, are only applied when the
+// function passed to Transact returns without an error.
+type Tx struct {
+	tenant string
+	actor  audit.Actor
+	// pets holds the staged writes, a nil entry means the pet was deleted
+	pets    map[int64]*Pet
+	order   []int64
+	events  []Event
+	entries []audit.Entry
+}
+
+// Transact runs fn inside a store transaction on the pets of tenant, as
+// audit.System. See TransactAs.
+func Transact(tenant string, fn func(tx *Tx) error) error {
+	return TransactAs(tenant, audit.System, fn)
+}
+
+// TransactAs runs fn inside a store transaction on the pets of tenant. All
+// the writes made by fn, and the outbox records for them, are committed
+// together or not at all. They are recorded in the audit log as made by
+// actor first, so nothing is committed if they can't be.
+func TransactAs(tenant string, actor audit.Actor, fn func(tx *Tx) error) error {
+	// Hold the write lock for the whole transaction so it's isolated
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	tx := &Tx{tenant: tenant, actor: actor, pets: make(map[int64]*Pet)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.entries) > 0 {
+		if _, err := audit.DefaultLog.Append(tx.entries...); err != nil {
+			return err
+		}
+	}
+	tx.commit()
+	return nil
+}
+
+// GetPetByID gets the Pet with the provided ID, as seen by the transaction
+func (tx *Tx) GetPetByID(id int64) (*Pet, error) {
+	if p, staged := tx.pets[id]; staged {
+		if p == nil {
+			return nil, ErrNotExist
+		}
+		c := *p
+		return &c, nil
+	}
+	return getPetByID(tx.tenant, id)
+}
+
+// AddPet stages a new pet, or a replacement for an existing one
+func (tx *Tx) AddPet(p Pet) error {
 	// Validate
 	if err := p.Validate(); err != nil {
 		return err
 	}
 
-	// Apply a mutex to avoid race conditions for IDs
-	dataLock.Lock()
-	defer dataLock.Unlock()
+	eventType := EventPetCreated
+	old, err := tx.GetPetByID(p.ID)
+	if err == nil {
+		eventType = EventPetUpdated
+	}
 
-	if dataMapID == nil {
-		dataMapID = make(map[int64]int)
+	tx.stage(p.ID, &p)
+	return tx.record(eventType, p.ID, old, &p)
+}
+
+// UpdatePet stages a replacement for an existing pet
+func (tx *Tx) UpdatePet(p Pet) error {
+	// Validate
+	if err := p.Validate(); err != nil {
+		return err
 	}
-	index, exists := dataMapID[p.ID]
-	if exists {
-		// replace the item
-		data[index] = p
-		return nil
+
+	old, err := tx.GetPetByID(p.ID)
+	if err != nil {
+		return err
+	}
+
+	tx.stage(p.ID, &p)
+	return tx.record(EventPetUpdated, p.ID, old, &p)
+}
+
+// DeletePet stages the removal of an existing pet
+func (tx *Tx) DeletePet(id int64) error {
+	old, err := tx.GetPetByID(id)
+	if err != nil {
+		return err
+	}
+
+	tx.stage(id, nil)
+	return tx.record(EventPetDeleted, id, old, nil)
+}
+
+func (tx *Tx) stage(id int64, p *Pet) {
+	if _, staged := tx.pets[id]; !staged {
+		tx.order = append(tx.order, id)
 	}
+	tx.pets[id] = p
+}
 
-	data = append(data, p)
-	dataMapID[p.ID] = len(data) - 1
+// record adds the event and the audit entry for a change to the pet with the
+// given ID, from old to p. Either of them is nil if the pet didn't exist
+// before or after the change.
+func (tx *Tx) record(eventType EventType, id int64, old, p *Pet) error {
+	e, err := newEvent(eventType, tx.tenant, id, p)
+	if err != nil {
+		return err
+	}
+	entry := audit.Entry{
+		Tenant:    tx.tenant,
+		Actor:     tx.actor.Principal,
+		RequestID: tx.actor.RequestID,
+		Action:    string(eventType),
+		PetID:     id,
+	}
+	if entry.Old, err = marshalPet(old); err != nil {
+		return err
+	}
+	if entry.New, err = marshalPet(p); err != nil {
+		return err
+	}
+	tx.events = append(tx.events, e)
+	tx.entries = append(tx.entries, entry)
 	return nil
 }
 
-// GetPetByID gets the Pet with the provided ID
-func GetPetByID(id int64) (*Pet, error) {
+// marshalPet returns p as JSON for the audit log, nil if p is nil
+func marshalPet(p *Pet) (json.RawMessage, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+// commit applies the staged writes and events. It must be called with the
+// write lock held.
+func (tx *Tx) commit() {
+	if len(tx.order) > 0 {
+		d := getOrAddTenant(tx.tenant)
+		for _, id := range tx.order {
+			p := tx.pets[id]
+			if p == nil {
+				d.remove(id)
+				continue
+			}
+			index, exists := d.index[id]
+			if exists {
+				// replace the item
+				d.pets[index] = *p
+				continue
+			}
+			d.pets = append(d.pets, *p)
+			d.index[id] = len(d.pets) - 1
+		}
+		dirty = true
+	}
+	for _, e := range tx.events {
+		outbox = append(outbox, outboxRecord{Event: e})
+	}
+}
+
+// remove removes the pet with the given ID by moving the last pet into its
+// place. It must be called with the write lock held.
+func (d *tenantData) remove(id int64) {
+	index, exists := d.index[id]
+	if !exists {
+		return
+	}
+	last := len(d.pets) - 1
+	if index != last {
+		d.pets[index] = d.pets[last]
+		d.index[d.pets[index].ID] = index
+	}
+	d.pets = d.pets[:last]
+	delete(d.index, id)
+}
+
+// AddPet adds a new pet for tenant
+func AddPet(tenant string, p Pet) error {
+	return Transact(tenant, func(tx *Tx) error {
+		return tx.AddPet(p)
+	})
+}
+
+// UpdatePet replaces an existing pet of tenant
+func UpdatePet(tenant string, p Pet) error {
+	return Transact(tenant, func(tx *Tx) error {
+		return tx.UpdatePet(p)
+	})
+}
+
+// DeletePet deletes the pet of tenant with the provided ID
+func DeletePet(tenant string, id int64) error {
+	return Transact(tenant, func(tx *Tx) error {
+		return tx.DeletePet(id)
+	})
+}
+
+// GetPetByID gets the Pet of tenant with the provided ID
+func GetPetByID(tenant string, id int64) (*Pet, error) {
 	// Apply a mutex so we can read safely
 	dataLock.RLock()
 	defer dataLock.RUnlock()
 
-	index, exists := dataMapID[id]
+	return getPetByID(tenant, id)
+}
+
+func getPetByID(tenant string, id int64) (*Pet, error) {
+	d := getTenant(tenant)
+	if d == nil {
+		return nil, ErrNotExist
+	}
+	index, exists := d.index[id]
 	if !exists {
 		return nil, ErrNotExist
 	}
-	p := data[index]
+	p := d.pets[index]
 
 	return &p, nil
 }
 
-// ListPets gets the Pet with the provided ID
-func ListPets() ([]Pet, error) {
+// ListPets gets all the Pets of tenant, sorted by ID
+func ListPets(tenant string) ([]Pet, error) {
 	// Apply a mutex so we can read safely
 	dataLock.RLock()
 	defer dataLock.RUnlock()
 
-	var pets = data
+	d := getTenant(tenant)
+	if d == nil {
+		return []Pet{}, nil
+	}
+	// copy the pets so sorting doesn't move them around under the index
+	var pets = make([]Pet, len(d.pets))
+	copy(pets, d.pets)
 	// sort pets by ID
 	sort.Slice(pets, func(i, j int) bool {
 		return pets[i].ID < pets[j].ID
 	})
 
-	return data, nil
+	return pets, nil
+}
+
+// Reindex rebuilds the ID index of tenant from its stored pets and returns
+// the number of pets indexed
+func Reindex(tenant string) (int, error) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	d := getTenant(tenant)
+	if d == nil {
+		return 0, nil
+	}
+	var index = make(map[int64]int, len(d.pets))
+	for i, p := range d.pets {
+		if _, exists := index[p.ID]; exists {
+			return 0, fmt.Errorf("found more than one pet with id %d", p.ID)
+		}
+		index[p.ID] = i
+	}
+	d.index = index
+
+	return len(d.pets), nil
 }
 
 // Paginate takes a []Pet and returns only the elements appropriate
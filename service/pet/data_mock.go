@@ -1,6 +1,6 @@
 package pet
 
-// PopulateMockPets populates the data with mock pets
+// PopulateMockPets populates the data of the default tenant with mock pets
 func PopulateMockPets() {
 	mockPets := getMockPets()
 	populateMockPets(mockPets)
@@ -40,7 +40,7 @@ func getMockPets() []Pet {
 func populateMockPets(mockPets []Pet) error {
 	// Popuate data with mock
 	for _, p := range mockPets {
-		err := AddPet(p)
+		err := AddPet(DefaultTenant, p)
 		if err != nil {
 			return err
 		}
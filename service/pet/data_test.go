@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"../audit"
 )
 
 func TestAddPet(t *testing.T) {
@@ -31,11 +33,62 @@ func TestAddPet(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			err := AddPet(test.input)
+			err := AddPet(DefaultTenant, test.input)
+			assert.Equal(t, test.isError, err != nil)
+			// if we saved it, let's make sure it's saved right
+			if !test.isError {
+				p, err := GetPetByID(DefaultTenant, test.input.ID)
+				if err != nil {
+					t.Error(err)
+				}
+				assert.Equal(t, &test.input, p)
+			}
+		})
+	}
+}
+
+func TestUpdatePet(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	// Popuate data with mock
+	mockPets := getMockPets()
+	err := populateMockPets(mockPets)
+	if err != nil {
+		t.Fatalf("Could not populate mock data: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   Pet
+		isError bool
+	}{
+		{
+			"passing an invalid Pet should return a validation err",
+			Pet{ID: 1},
+			true,
+		},
+		{
+			"passing a Pet that doesn't exist should return an error",
+			Pet{ID: 42, Name: "Tommy"},
+			true,
+		},
+		{
+			"passing a valid existing Pet should not return an error",
+			Pet{ID: 1, Name: "Tommy", Tag: "dog"},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := UpdatePet(DefaultTenant, test.input)
 			assert.Equal(t, test.isError, err != nil)
 			// if we saved it, let's make sure it's saved right
 			if !test.isError {
-				p, err := GetPetByID(test.input.ID)
+				p, err := GetPetByID(DefaultTenant, test.input.ID)
 				if err != nil {
 					t.Error(err)
 				}
@@ -45,6 +98,58 @@ func TestAddPet(t *testing.T) {
 	}
 }
 
+func TestDeletePet(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	// Popuate data with mock
+	mockPets := getMockPets()
+	err := populateMockPets(mockPets)
+	if err != nil {
+		t.Fatalf("Could not populate mock data: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		input   int64
+		isError bool
+	}{
+		{
+			"deleting a Pet that doesn't exist should return an error",
+			42,
+			true,
+		},
+		{
+			"deleting an existing Pet should not return an error",
+			mockPets[1].ID,
+			false,
+		},
+		{
+			"deleting the same Pet again should return an error",
+			mockPets[1].ID,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			err := DeletePet(DefaultTenant, test.input)
+			assert.Equal(t, test.isError, err != nil)
+			_, err = GetPetByID(DefaultTenant, test.input)
+			assert.Equal(t, ErrNotExist, err)
+		})
+	}
+
+	// the rest of the pets should still be reachable by ID
+	for _, p := range append(mockPets[:1], mockPets[2:]...) {
+		got, err := GetPetByID(DefaultTenant, p.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, &p, got)
+	}
+}
+
 func TestGetPetByID(t *testing.T) {
 
 	// Clean the data set once test is done
@@ -80,7 +185,7 @@ func TestGetPetByID(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
-			p, err := GetPetByID(test.input)
+			p, err := GetPetByID(DefaultTenant, test.input)
 			assert.Equal(t, test.isError, err != nil)
 			assert.Equal(t, test.output, p)
 
@@ -94,7 +199,7 @@ func TestListPets(t *testing.T) {
 	t.Run(
 		"clean slate should return empty slice",
 		func(t *testing.T) {
-			pets, err := ListPets()
+			pets, err := ListPets(DefaultTenant)
 			assert.Nil(t, err)
 			assert.Equal(t, []Pet{}, pets)
 		},
@@ -113,13 +218,83 @@ func TestListPets(t *testing.T) {
 				t.Fatalf("Could not populate mock data: %v", err)
 			}
 
-			pets, err := ListPets()
+			pets, err := ListPets(DefaultTenant)
 			assert.Nil(t, err)
 			assert.Equal(t, mockPets, pets)
 		},
 	)
 }
 
+func TestReindex(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	// Popuate data with mock
+	mockPets := getMockPets()
+	err := populateMockPets(mockPets)
+	if err != nil {
+		t.Fatalf("Could not populate mock data: %v", err)
+	}
+
+	// Lose the index, as if it had gone out of sync
+	dataLock.Lock()
+	tenants[DefaultTenant].index = make(map[int64]int)
+	dataLock.Unlock()
+
+	n, err := Reindex(DefaultTenant)
+	assert.Nil(t, err)
+	assert.Equal(t, len(mockPets), n)
+	for _, p := range mockPets {
+		got, err := GetPetByID(DefaultTenant, p.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, &p, got)
+	}
+}
+
+func TestTenants(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	// Both tenants have a pet with the same ID
+	assert.Nil(t, AddPet("shelter-a", Pet{ID: 1, Name: "Tommy"}))
+	assert.Nil(t, AddPet("shelter-b", Pet{ID: 1, Name: "Tiger"}))
+	assert.Nil(t, AddPet("shelter-b", Pet{ID: 2, Name: "Buddy"}))
+
+	// Each tenant only sees its own pets
+	p, err := GetPetByID("shelter-a", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tommy", p.Name)
+	_, err = GetPetByID("shelter-a", 2)
+	assert.Equal(t, ErrNotExist, err)
+	_, err = GetPetByID(DefaultTenant, 1)
+	assert.Equal(t, ErrNotExist, err)
+
+	pets, err := ListPets("shelter-b")
+	assert.Nil(t, err)
+	assert.Equal(t, []Pet{{ID: 1, Name: "Tiger"}, {ID: 2, Name: "Buddy"}}, pets)
+	pets, err = ListPets("shelter-c")
+	assert.Nil(t, err)
+	assert.Equal(t, []Pet{}, pets)
+
+	// Changes to a pet of one tenant leave the other tenants alone
+	assert.Equal(t, ErrNotExist, UpdatePet("shelter-a", Pet{ID: 2, Name: "Max"}))
+	assert.Nil(t, DeletePet("shelter-b", 1))
+	p, err = GetPetByID("shelter-a", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tommy", p.Name)
+
+	n, err := Reindex("shelter-b")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// Events say which tenant they are for
+	events := PendingEvents()
+	assert.Equal(t, "shelter-a", events[0].Tenant)
+	assert.Equal(t, "shelter-b", events[len(events)-1].Tenant)
+}
+
 func TestPaginate(t *testing.T) {
 
 	// get mock pets
@@ -193,3 +368,126 @@ func TestPaginate(t *testing.T) {
 	}
 
 }
+
+func TestTransact(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		seed           []Pet
+		fn             func(tx *Tx) error
+		isError        bool
+		expectedPets   []Pet
+		expectedEvents []EventType
+	}{
+		{
+			"a failed transaction should not write pets or events",
+			nil,
+			func(tx *Tx) error {
+				if err := tx.AddPet(Pet{ID: 1, Name: "Tommy"}); err != nil {
+					return err
+				}
+				return tx.AddPet(Pet{ID: 2})
+			},
+			true,
+			[]Pet{},
+			[]EventType{},
+		},
+		{
+			"a successful transaction should write pets and events together",
+			nil,
+			func(tx *Tx) error {
+				if err := tx.AddPet(Pet{ID: 1, Name: "Tommy"}); err != nil {
+					return err
+				}
+				return tx.AddPet(Pet{ID: 2, Name: "Tiger"})
+			},
+			false,
+			[]Pet{{ID: 1, Name: "Tommy"}, {ID: 2, Name: "Tiger"}},
+			[]EventType{EventPetCreated, EventPetCreated},
+		},
+		{
+			"replacing a pet should emit an update event",
+			[]Pet{{ID: 1, Name: "Tommy"}},
+			func(tx *Tx) error {
+				return tx.AddPet(Pet{ID: 1, Name: "Tommy", Tag: "dog"})
+			},
+			false,
+			[]Pet{{ID: 1, Name: "Tommy", Tag: "dog"}},
+			[]EventType{EventPetCreated, EventPetUpdated},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// Clean the data set once test is done
+			defer resetData()
+			err := populateMockPets(test.seed)
+			if err != nil {
+				t.Fatalf("Could not populate mock data: %v", err)
+			}
+
+			err = Transact(DefaultTenant, test.fn)
+			assert.Equal(t, test.isError, err != nil)
+
+			pets, err := ListPets(DefaultTenant)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedPets, pets)
+
+			var types = []EventType{}
+			for _, e := range PendingEvents() {
+				types = append(types, e.Type)
+			}
+			assert.Equal(t, test.expectedEvents, types)
+		})
+	}
+}
+
+func TestTransactAs_Audit(t *testing.T) {
+	defer func(log *audit.Log) {
+		audit.DefaultLog = log
+		resetData()
+	}(audit.DefaultLog)
+	audit.DefaultLog = audit.NewLog()
+
+	actor := audit.Actor{Principal: "api_key:ops", RequestID: "req-1"}
+	err := TransactAs("acme", actor, func(tx *Tx) error {
+		if err := tx.AddPet(Pet{ID: 1, Name: "Tommy"}); err != nil {
+			return err
+		}
+		if err := tx.UpdatePet(Pet{ID: 1, Name: "Tommy", Tag: "dog"}); err != nil {
+			return err
+		}
+		return tx.DeletePet(1)
+	})
+	assert.NoError(t, err)
+
+	// A failed transaction isn't recorded
+	err = TransactAs("acme", actor, func(tx *Tx) error {
+		return tx.DeletePet(1)
+	})
+	assert.Equal(t, ErrNotExist, err)
+
+	entries := audit.DefaultLog.Query(audit.Filter{Tenant: "acme"})
+	assert.Len(t, entries, 3)
+	for _, e := range entries {
+		assert.Equal(t, "api_key:ops", e.Actor)
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.Equal(t, int64(1), e.PetID)
+	}
+	assert.Equal(t, "pet.created", entries[0].Action)
+	assert.Nil(t, entries[0].Old)
+	assert.Equal(t, `{"id":1,"name":"Tommy"}`, string(entries[0].New))
+	assert.Equal(t, "pet.updated", entries[1].Action)
+	assert.Equal(t, `{"id":1,"name":"Tommy"}`, string(entries[1].Old))
+	assert.Equal(t, `{"id":1,"name":"Tommy","tag":"dog"}`, string(entries[1].New))
+	assert.Equal(t, "pet.deleted", entries[2].Action)
+	assert.Equal(t, `{"id":1,"name":"Tommy","tag":"dog"}`, string(entries[2].Old))
+	assert.Nil(t, entries[2].New)
+
+	// Changes made by the server itself are recorded as the system's
+	assert.NoError(t, AddPet(DefaultTenant, Pet{ID: 2, Name: "Tiger"}))
+	entries = audit.DefaultLog.Query(audit.Filter{})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, audit.System.Principal, entries[0].Actor)
+}
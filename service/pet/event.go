@@ -0,0 +1,54 @@
+package pet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// EventType identifies what kind of change an Event describes
+type EventType string
+
+// Types of events emitted for pet changes
+const (
+	EventPetCreated EventType = "pet.created"
+	EventPetUpdated EventType = "pet.updated"
+	EventPetDeleted EventType = "pet.deleted"
+)
+
+// Event represents a change made to a pet. The ID is assigned when the change
+// is written and never changes, so consumers can use it to dedupe deliveries.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      EventType `json:"type"`
+	Tenant    string    `json:"tenant,omitempty"`
+	PetID     int64     `json:"pet_id"`
+	Pet       *Pet      `json:"pet,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newEvent(eventType EventType, tenant string, petID int64, p *Pet) (Event, error) {
+	id, err := newEventID()
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:        id,
+		Type:      eventType,
+		Tenant:    tenant,
+		PetID:     petID,
+		Pet:       p,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// newEventID returns a random 128 bit ID, hex encoded
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate event id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,248 @@
+package pet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teejays/clog"
+)
+
+// outbox holds the events that have been committed but not yet delivered.
+// It is guarded by dataLock so that records are written in the same
+// transaction as the pet changes they describe.
+var outbox = []outboxRecord{}
+
+// outboxRecord is an event in the outbox, along with how many times
+// delivering it failed. Events that fail too often are parked: they are kept,
+// but no longer delivered, so that they don't hold up the others.
+type outboxRecord struct {
+	Event    Event `json:"event"`
+	Attempts int   `json:"attempts"`
+	Parked   bool  `json:"parked,omitempty"`
+}
+
+// PendingEvents returns the events in the outbox that are yet to be delivered,
+// oldest first. Parked events aren't.
+func PendingEvents() []Event {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+
+	return pendingEvents(len(outbox), func(string) bool { return true })
+}
+
+// ParkedEvents returns the events in the outbox that are no longer delivered,
+// as delivering them failed too many times, oldest first
+func ParkedEvents() []Event {
+	dataLock.RLock()
+	defer dataLock.RUnlock()
+
+	var events = []Event{}
+	for _, rec := range outbox {
+		if rec.Parked {
+			events = append(events, rec.Event)
+		}
+	}
+	return events
+}
+
+// pendingEvents returns up to max of the events that aren't parked, and that
+// due returns true for the ID of. It must be called with dataLock held.
+func pendingEvents(max int, due func(id string) bool) []Event {
+	var events = []Event{}
+	for _, rec := range outbox {
+		if len(events) == max {
+			break
+		}
+		if !rec.Parked && due(rec.Event.ID) {
+			events = append(events, rec.Event)
+		}
+	}
+	return events
+}
+
+// markDelivered removes the events with the given IDs from the outbox
+func markDelivered(ids map[string]bool) {
+	if len(ids) == 0 {
+		return
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	var kept = outbox[:0]
+	for _, rec := range outbox {
+		if !ids[rec.Event.ID] {
+			kept = append(kept, rec)
+		}
+	}
+	outbox = kept
+	dirty = true
+}
+
+// markFailed records a failed delivery attempt for the event with the given
+// ID, and parks it once it has had maxAttempts. It returns the attempts made
+// so far, and whether the event was parked.
+func markFailed(id string, maxAttempts int) (int, bool) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+
+	for i := range outbox {
+		if outbox[i].Event.ID == id {
+			outbox[i].Attempts++
+			outbox[i].Parked = outbox[i].Attempts >= maxAttempts
+			dirty = true
+			return outbox[i].Attempts, outbox[i].Parked
+		}
+	}
+	return 0, false
+}
+
+// PublishFunc delivers an event to its consumers
+type PublishFunc func(Event) error
+
+// Dispatcher drains the outbox in the background, handing each event to a
+// PublishFunc. An event is only removed from the outbox once it has been
+// published successfully, so delivery is at-least-once: consumers should
+// dedupe on Event.ID.
+//
+// Events that fail to publish are tried again after a backoff, which doubles
+// with each attempt, and are parked once they have failed maxAttempts times.
+type Dispatcher struct {
+	publish     PublishFunc
+	interval    time.Duration
+	batchSize   int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	now         func() time.Time
+
+	drainLock sync.Mutex
+	// retryAt is when events that failed to publish are tried again, it is
+	// guarded by drainLock
+	retryAt map[string]time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+var defaultDispatchBatchSize = 100
+
+// How events that fail to publish are tried again by default
+var (
+	defaultDispatchBackoff     = time.Second
+	defaultDispatchMaxBackoff  = 10 * time.Minute
+	defaultDispatchMaxAttempts = 10
+)
+
+// NewDispatcher creates a new Dispatcher that checks the outbox every interval
+func NewDispatcher(publish PublishFunc, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		publish:     publish,
+		interval:    interval,
+		batchSize:   defaultDispatchBatchSize,
+		backoff:     defaultDispatchBackoff,
+		maxBackoff:  defaultDispatchMaxBackoff,
+		maxAttempts: defaultDispatchMaxAttempts,
+		now:         time.Now,
+		retryAt:     make(map[string]time.Time),
+	}
+}
+
+// Start begins draining the outbox in the background
+func (d *Dispatcher) Start() {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.run()
+}
+
+// Stop stops the background loop, making one final attempt to drain the outbox
+func (d *Dispatcher) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.drainAndLog()
+		case <-d.stop:
+			d.drainAndLog()
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) drainAndLog() {
+	n, err := d.Drain()
+	if err != nil {
+		clog.Errorf("Outbox: delivered %d event(s) before failing: %v", n, err)
+	}
+}
+
+// Drain publishes the pending events, oldest first, until there are none
+// left or a publish fails. Events that failed before are skipped until their
+// backoff is over. It returns the number of events delivered.
+func (d *Dispatcher) Drain() (int, error) {
+	d.drainLock.Lock()
+	defer d.drainLock.Unlock()
+
+	now := d.now()
+	due := func(id string) bool {
+		return !now.Before(d.retryAt[id])
+	}
+
+	var delivered int
+	for {
+		dataLock.RLock()
+		events := pendingEvents(d.batchSize, due)
+		dataLock.RUnlock()
+
+		if len(events) == 0 {
+			return delivered, nil
+		}
+
+		var ids = make(map[string]bool, len(events))
+		for _, e := range events {
+			if err := d.publish(e); err != nil {
+				// Keep the event for the next attempt, and everything after
+				// it for the next drain
+				markDelivered(ids)
+				d.retry(e, err)
+				return delivered, err
+			}
+			ids[e.ID] = true
+			delete(d.retryAt, e.ID)
+			delivered++
+		}
+		markDelivered(ids)
+	}
+}
+
+// retry records that e failed to publish with err, and works out when it is
+// tried again, if it isn't parked. It must be called with drainLock held.
+func (d *Dispatcher) retry(e Event, err error) {
+	attempts, parked := markFailed(e.ID, d.maxAttempts)
+	if parked {
+		delete(d.retryAt, e.ID)
+		clog.Errorf("Outbox: parked event %s after %d failed attempts: %v", e.ID, attempts, err)
+		return
+	}
+
+	backoff := d.backoff
+	for i := 1; i < attempts && backoff < d.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.maxBackoff {
+		backoff = d.maxBackoff
+	}
+	d.retryAt[e.ID] = d.now().Add(backoff)
+}
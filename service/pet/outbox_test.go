@@ -0,0 +1,142 @@
+package pet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcher_Drain(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	err := populateMockPets(getMockPets())
+	if err != nil {
+		t.Fatalf("Could not populate mock data: %v", err)
+	}
+	pending := PendingEvents()
+	assert.Equal(t, len(getMockPets()), len(pending))
+
+	// A publisher that fails on the third event it sees
+	var published []Event
+	var failOn = pending[2].ID
+	publish := func(e Event) error {
+		if e.ID == failOn {
+			return fmt.Errorf("consumer unavailable")
+		}
+		published = append(published, e)
+		return nil
+	}
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDispatcher(publish, time.Hour)
+	d.now = func() time.Time { return now }
+
+	// The first drain should stop at the failing event and keep it
+	n, err := d.Drain()
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, pending[2:], PendingEvents())
+
+	// The next one should skip it while it backs off, and deliver the rest
+	failOn = ""
+	n, err = d.Drain()
+	assert.Nil(t, err)
+	assert.Equal(t, len(pending)-3, n)
+	assert.Equal(t, pending[2:3], PendingEvents())
+
+	// Once the backoff is over, it should be delivered with the same ID
+	now = now.Add(defaultDispatchBackoff)
+	n, err = d.Drain()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, append(append(pending[:2:2], pending[3:]...), pending[2]), published)
+	assert.Equal(t, []Event{}, PendingEvents())
+}
+
+func TestDispatcher_Park(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	AddPet(DefaultTenant, Pet{ID: 1, Name: "Tommy"})
+	AddPet(DefaultTenant, Pet{ID: 2, Name: "Tiger"})
+	pending := PendingEvents()
+
+	// A publisher that can never take the first event
+	var published []Event
+	publish := func(e Event) error {
+		if e.ID == pending[0].ID {
+			return fmt.Errorf("consumer can't read the event")
+		}
+		published = append(published, e)
+		return nil
+	}
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDispatcher(publish, time.Hour)
+	d.now = func() time.Time { return now }
+	d.maxAttempts = 3
+
+	// The first attempt fails, and the event backs off while the others
+	// are delivered
+	n, err := d.Drain()
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	n, err = d.Drain()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// The event is tried again after a backoff that doubles each time
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		now = now.Add(backoff - time.Nanosecond)
+		n, err = d.Drain()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		now = now.Add(time.Nanosecond)
+		_, err = d.Drain()
+		assert.NotNil(t, err)
+		assert.Equal(t, i+2, outbox[0].Attempts)
+	}
+
+	// and is parked after the last attempt, without holding up the others
+	assert.Equal(t, pending[1:], published)
+	assert.Equal(t, []Event{}, PendingEvents())
+	assert.Equal(t, pending[:1], ParkedEvents())
+	now = now.Add(time.Hour)
+	n, err = d.Drain()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDispatcher_StartStop(t *testing.T) {
+
+	// Clean the data set once test is done
+	defer resetData()
+
+	var delivered = make(chan Event, 1)
+	d := NewDispatcher(func(e Event) error {
+		delivered <- e
+		return nil
+	}, time.Millisecond)
+	d.Start()
+	defer d.Stop()
+
+	err := AddPet(DefaultTenant, Pet{ID: 1, Name: "Tommy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-delivered:
+		assert.Equal(t, EventPetCreated, e.Type)
+		assert.Equal(t, int64(1), e.PetID)
+		assert.Equal(t, 32, len(e.ID))
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
@@ -3,28 +3,27 @@ package pet
 import (
 	"fmt"
 	"strings"
+
+	"../validation"
 )
 
 // Pet represents the model for pet entity
 type Pet struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID   int64  `json:"id" schema:"minimum=1"`
+	Name string `json:"name" schema:"pattern=\\S"`
 	Tag  string `json:"tag,omitempty"`
 }
 
 var ErrInvalidID = fmt.Errorf("invalid id: cannot be less than 1")
 var ErrInvalidName = fmt.Errorf("invalid name: cannot be empty")
 
-// Validate returns an error if any of the fields in Pet is not valid
+// Validate returns an error if any of the fields in Pet is not valid. The
+// error is a validation.Errors with every field that is not.
 func (p Pet) Validate() error {
-	// Validate params
-	if p.ID < 1 {
-		return ErrInvalidID
-	}
-	if strings.TrimSpace(p.Name) == "" {
-		return ErrInvalidName
-	}
-	return nil
+	var v validation.Validator
+	v.Check(p.ID >= 1, "id", validation.CodeMinimum, ErrInvalidID)
+	v.Check(strings.TrimSpace(p.Name) != "", "name", validation.CodeRequired, ErrInvalidName)
+	return v.Err()
 }
 
 // NewPet create a new instance of a Pet
@@ -1,9 +1,12 @@
 package pet
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"../validation"
 )
 
 func TestValidate(t *testing.T) {
@@ -54,6 +57,19 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidate_AllFields(t *testing.T) {
+
+	err := Pet{ID: 0, Name: " "}.Validate()
+
+	var errs validation.Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, validation.Errors{
+		{Field: "id", Code: validation.CodeMinimum, Message: ErrInvalidID.Error(), Err: ErrInvalidID},
+		{Field: "name", Code: validation.CodeRequired, Message: ErrInvalidName.Error(), Err: ErrInvalidName},
+	}, errs)
+	assert.True(t, errors.Is(err, ErrInvalidName))
+}
+
 func TestNewPet(t *testing.T) {
 
 	tests := []struct {
@@ -0,0 +1,234 @@
+package pet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Storage backends for the pets
+const (
+	// StorageMemory keeps the pets in memory only, so they are lost when
+	// the server stops
+	StorageMemory = "memory"
+	// StorageFile keeps the pets in memory, and writes them to a JSON file
+	// when flushed
+	StorageFile = "file"
+)
+
+// Backends lists the storage backends
+var Backends = []string{StorageMemory, StorageFile}
+
+// storagePath is the file the pets are flushed to, if the file backend is used
+var storagePath string
+
+// dirty is set when the pets, or the outbox, have changed since they were
+// last flushed. It is guarded by dataLock.
+var dirty bool
+
+// storedFile is what is written to the file: the pets, along with the outbox
+// records of the events that are yet to be delivered, so that they are
+// written together and neither is lost on restart. Sections has the state of
+// the sections that were added, by name.
+type storedFile struct {
+	Pets     []storedPet                `json:"pets"`
+	Outbox   []outboxRecord             `json:"outbox"`
+	Sections map[string]json.RawMessage `json:"sections,omitempty"`
+}
+
+// Section is state from outside the package that is written to the file along
+// with the pets, so that the file backend doesn't lose it on restart either
+type Section interface {
+	// LoadSection replaces the state with data, which is nil if the file
+	// has none
+	LoadSection(data json.RawMessage) error
+	// SaveSection returns the state to write, and true if it changed since
+	// it was last returned
+	SaveSection() (json.RawMessage, bool, error)
+}
+
+// sections are the sections that were added, by name. It is guarded by
+// dataLock.
+var sections = map[string]Section{}
+
+// AddSection makes s be written to the file along with the pets, under name.
+// It must be added before OpenFile, to be loaded from the file.
+func AddSection(name string, s Section) {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	sections[name] = s
+}
+
+// storedPet is a pet as it is written to the file, along with its tenant.
+// Pets without a tenant belong to DefaultTenant, as written before the API
+// was shared between tenants.
+type storedPet struct {
+	Tenant string `json:"tenant,omitempty"`
+	Pet
+}
+
+// OpenFile replaces the pets in the store, the outbox and the sections, with
+// the ones in the JSON file at path, and makes Flush write them back there. A
+// missing file is an empty store, it is created on the first flush.
+func OpenFile(path string) error {
+	f, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	var loaded = map[string]*tenantData{}
+	for i, p := range f.Pets {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("pet %d in %s: %w", i, path, err)
+		}
+		d := loaded[p.Tenant]
+		if d == nil {
+			d = &tenantData{pets: []Pet{}, index: make(map[int64]int)}
+			loaded[p.Tenant] = d
+		}
+		if _, exists := d.index[p.ID]; exists {
+			return fmt.Errorf("found more than one pet with id %d in %s", p.ID, path)
+		}
+		d.pets = append(d.pets, p.Pet)
+		d.index[p.ID] = len(d.pets) - 1
+	}
+
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	for name, s := range sections {
+		if err := s.LoadSection(f.Sections[name]); err != nil {
+			return fmt.Errorf("section %s in %s: %w", name, path, err)
+		}
+	}
+	tenants = loaded
+	outbox = f.Outbox
+	storagePath = path
+	dirty = false
+	return nil
+}
+
+// readFile reads the file at path. Files written before the outbox was kept
+// in them only have the array of pets.
+func readFile(path string) (storedFile, error) {
+	var f storedFile
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return f, err
+	}
+	if err == nil {
+		if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+			err = json.Unmarshal(content, &f.Pets)
+		} else {
+			err = json.Unmarshal(content, &f)
+		}
+		if err != nil {
+			return f, fmt.Errorf("could not read the pets in %s: %w", path, err)
+		}
+	}
+	if f.Pets == nil {
+		f.Pets = []storedPet{}
+	}
+	if f.Outbox == nil {
+		f.Outbox = []outboxRecord{}
+	}
+	return f, nil
+}
+
+// Flush writes the pets, along with the outbox and the sections, to the file
+// opened with OpenFile, if any of them have changed since the last flush. It
+// does nothing for the memory backend.
+func Flush() error {
+	dataLock.Lock()
+	if storagePath == "" {
+		dataLock.Unlock()
+		return nil
+	}
+	var changed = dirty
+	var f = storedFile{Pets: []storedPet{}, Outbox: make([]outboxRecord, len(outbox))}
+	if len(sections) > 0 {
+		f.Sections = make(map[string]json.RawMessage, len(sections))
+	}
+	for name, s := range sections {
+		data, sectionChanged, err := s.SaveSection()
+		if err != nil {
+			// Write the sections that changed on the next flush
+			dirty = changed
+			dataLock.Unlock()
+			return fmt.Errorf("section %s: %w", name, err)
+		}
+		f.Sections[name] = data
+		changed = changed || sectionChanged
+	}
+	if !changed {
+		dataLock.Unlock()
+		return nil
+	}
+	var path = storagePath
+	for tenant, d := range tenants {
+		for _, p := range d.pets {
+			f.Pets = append(f.Pets, storedPet{Tenant: tenant, Pet: p})
+		}
+	}
+	copy(f.Outbox, outbox)
+	dirty = false
+	dataLock.Unlock()
+
+	sort.Slice(f.Pets, func(i, j int) bool {
+		if f.Pets[i].Tenant != f.Pets[j].Tenant {
+			return f.Pets[i].Tenant < f.Pets[j].Tenant
+		}
+		return f.Pets[i].ID < f.Pets[j].ID
+	})
+	if err := writeFile(path, f); err != nil {
+		// Try again on the next flush
+		dataLock.Lock()
+		dirty = true
+		dataLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeFile replaces the file at path with f. It is written to a temporary
+// file first, so a failed write never leaves a partial file behind.
+func writeFile(path string, f storedFile) error {
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CloseFile flushes the pets to the file opened with OpenFile, and goes back
+// to keeping them in memory only
+func CloseFile() error {
+	if err := Flush(); err != nil {
+		return err
+	}
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	storagePath = ""
+	return nil
+}
@@ -0,0 +1,274 @@
+package pet
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		resetData()
+		storagePath = ""
+	}()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedPets []Pet
+		isError      bool
+	}{
+		{
+			name:         "a missing file should be an empty store",
+			path:         filepath.Join(dir, "missing.json"),
+			expectedPets: []Pet{},
+		},
+		{
+			name:         "the pets in the file should be loaded",
+			path:         write("pets.json", `[{"id": 2, "name": "Tiger"}, {"id": 1, "name": "Tommy", "tag": "dog"}]`),
+			expectedPets: []Pet{{ID: 1, Name: "Tommy", Tag: "dog"}, {ID: 2, Name: "Tiger"}},
+		},
+		{
+			name:         "the pets should be loaded along with the outbox",
+			path:         write("outbox.json", `{"pets": [{"id": 1, "name": "Tommy"}], "outbox": [{"event": {"id": "e1", "type": "pet.created", "pet_id": 1}}]}`),
+			expectedPets: []Pet{{ID: 1, Name: "Tommy"}},
+		},
+		{
+			name:         "pets of other tenants should not be loaded into the default tenant",
+			path:         write("tenants.json", `[{"id": 1, "name": "Tommy"}, {"tenant": "shelter-a", "id": 1, "name": "Tiger"}]`),
+			expectedPets: []Pet{{ID: 1, Name: "Tommy"}},
+		},
+		{
+			name:    "invalid JSON should be an error",
+			path:    write("bad.json", `[{"id": 1`),
+			isError: true,
+		},
+		{
+			name:    "invalid pets should be an error",
+			path:    write("invalid.json", `[{"id": 0, "name": "Tommy"}]`),
+			isError: true,
+		},
+		{
+			name:    "duplicate pets should be an error",
+			path:    write("duplicate.json", `[{"id": 1, "name": "Tommy"}, {"id": 1, "name": "Tiger"}]`),
+			isError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetData()
+			err := OpenFile(tt.path)
+			assert.Equal(t, tt.isError, err != nil)
+			if !tt.isError {
+				pets, _ := ListPets(DefaultTenant)
+				assert.Equal(t, tt.expectedPets, pets)
+			}
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		resetData()
+		storagePath = ""
+	}()
+
+	// The memory backend has nothing to flush
+	resetData()
+	AddPet(DefaultTenant, Pet{ID: 1, Name: "Tommy"})
+	assert.NoError(t, Flush())
+
+	var path = filepath.Join(dir, "pets.json")
+	assert.NoError(t, OpenFile(path))
+
+	// Nothing changed, so nothing is written
+	assert.NoError(t, Flush())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Changes are written, sorted by ID
+	AddPet(DefaultTenant, Pet{ID: 2, Name: "Tiger"})
+	AddPet(DefaultTenant, Pet{ID: 1, Name: "Tommy"})
+	assert.NoError(t, Flush())
+
+	resetData()
+	assert.NoError(t, OpenFile(path))
+	pets, _ := ListPets(DefaultTenant)
+	assert.Equal(t, []Pet{{ID: 1, Name: "Tommy"}, {ID: 2, Name: "Tiger"}}, pets)
+
+	// Only the pets file is left behind
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+
+	// Closing flushes, and stops writing to the file
+	AddPet(DefaultTenant, Pet{ID: 3, Name: "Buddy"})
+	assert.NoError(t, CloseFile())
+	AddPet(DefaultTenant, Pet{ID: 4, Name: "Kitty"})
+	assert.NoError(t, Flush())
+	stored, err := readFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, stored.Pets, 3)
+}
+
+func TestFlush_Tenants(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		resetData()
+		storagePath = ""
+	}()
+
+	var path = filepath.Join(dir, "pets.json")
+	resetData()
+	assert.NoError(t, OpenFile(path))
+	AddPet("shelter-b", Pet{ID: 1, Name: "Tiger"})
+	AddPet("shelter-a", Pet{ID: 1, Name: "Tommy"})
+	AddPet(DefaultTenant, Pet{ID: 1, Name: "Buddy"})
+	assert.NoError(t, Flush())
+
+	// The pets are written along with their tenant, sorted by tenant
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	stored, err := readFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []storedPet{
+		{Pet: Pet{ID: 1, Name: "Buddy"}},
+		{Tenant: "shelter-a", Pet: Pet{ID: 1, Name: "Tommy"}},
+		{Tenant: "shelter-b", Pet: Pet{ID: 1, Name: "Tiger"}},
+	}, stored.Pets)
+	assert.Contains(t, string(content), `"tenant": "shelter-a"`)
+
+	// and read back into their tenant
+	resetData()
+	assert.NoError(t, OpenFile(path))
+	pets, _ := ListPets("shelter-a")
+	assert.Equal(t, []Pet{{ID: 1, Name: "Tommy"}}, pets)
+	pets, _ = ListPets(DefaultTenant)
+	assert.Equal(t, []Pet{{ID: 1, Name: "Buddy"}}, pets)
+}
+
+func TestFlush_Outbox(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		resetData()
+		storagePath = ""
+	}()
+
+	var path = filepath.Join(dir, "pets.json")
+	resetData()
+	assert.NoError(t, OpenFile(path))
+	AddPet(DefaultTenant, Pet{ID: 1, Name: "Tommy"})
+	AddPet(DefaultTenant, Pet{ID: 2, Name: "Tiger"})
+	pending := PendingEvents()
+	assert.Len(t, pending, 2)
+
+	// Deliver one of the events, and restart before the other one is
+	d := NewDispatcher(func(e Event) error {
+		if e.PetID == 2 {
+			return errors.New("consumer is down")
+		}
+		return nil
+	}, time.Hour)
+	d.Drain()
+	assert.NoError(t, Flush())
+	resetData()
+	assert.NoError(t, OpenFile(path))
+
+	// The undelivered event is still there, along with its attempts
+	assert.Equal(t, pending[1:], PendingEvents())
+	assert.Equal(t, 1, outbox[0].Attempts)
+	pets, _ := ListPets(DefaultTenant)
+	assert.Len(t, pets, 2)
+}
+
+// fakeSection is a Section that keeps its state as is
+type fakeSection struct {
+	data    json.RawMessage
+	changed bool
+}
+
+func (s *fakeSection) LoadSection(data json.RawMessage) error {
+	s.data = data
+	return nil
+}
+
+func (s *fakeSection) SaveSection() (json.RawMessage, bool, error) {
+	changed := s.changed
+	s.changed = false
+	return s.data, changed, nil
+}
+
+func TestFlush_Sections(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "pets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		resetData()
+		storagePath = ""
+		sections = map[string]Section{}
+	}()
+
+	var path = filepath.Join(dir, "pets.json")
+	resetData()
+	section := &fakeSection{}
+	AddSection("counts", section)
+	assert.NoError(t, OpenFile(path))
+	assert.Nil(t, section.data)
+
+	// Sections are written when they change, even if the pets don't
+	section.data, section.changed = json.RawMessage(`{"a":1}`), true
+	assert.NoError(t, Flush())
+	stored, err := readFile(path)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"a": 1}`, string(stored.Sections["counts"]))
+
+	// and read back on restart
+	section.data = nil
+	assert.NoError(t, OpenFile(path))
+	assert.JSONEq(t, `{"a": 1}`, string(section.data))
+
+	// Nothing is written when neither has changed
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, Flush())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
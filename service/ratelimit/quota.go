@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dayLayout is how days are written in the quota file
+const dayLayout = "2006-01-02"
+
+// Quotas count the requests each key makes in a day, in UTC, so that they
+// can be capped. The counts start again from zero every day.
+type Quotas struct {
+	lock sync.Mutex
+	// day is the day used counts the requests of
+	day  string
+	used map[string]int
+	// path is the file the counts are saved to, if any, and dirty is set
+	// when they changed since they were last saved
+	path  string
+	dirty bool
+	now   func() time.Time
+}
+
+// quotaFile is what is saved to the quota file
+type quotaFile struct {
+	Day  string         `json:"day"`
+	Used map[string]int `json:"used"`
+}
+
+// DefaultQuotas are the quotas used by the server
+var DefaultQuotas = NewQuotas()
+
+// NewQuotas returns Quotas that keep the counts in memory
+func NewQuotas() *Quotas {
+	return &Quotas{
+		used: make(map[string]int),
+		now:  time.Now,
+	}
+}
+
+// Open replaces the counts in q with the ones in the JSON file at path, and
+// saves them back there on Save. A missing file has no counts, and counts
+// from another day are dropped.
+func (q *Quotas) Open(path string) error {
+	var f quotaFile
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &f); err != nil {
+			return fmt.Errorf("could not read the quotas in %s: %w", path, err)
+		}
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.load(f)
+	q.path = path
+	return nil
+}
+
+// LoadSection replaces the counts in q with data, as returned by
+// SaveSection, so that q can be kept in the storage file of the pets as a
+// pet.Section. Counts from another day are dropped.
+func (q *Quotas) LoadSection(data json.RawMessage) error {
+	var f quotaFile
+	if data != nil {
+		if err := json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("could not read the quotas: %w", err)
+		}
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.load(f)
+	return nil
+}
+
+// SaveSection returns the counts in q, and true if they changed since they
+// were last returned
+func (q *Quotas) SaveSection() (json.RawMessage, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	data, err := json.Marshal(quotaFile{Day: q.day, Used: q.used})
+	if err != nil {
+		return nil, false, err
+	}
+	changed := q.dirty
+	q.dirty = false
+	return data, changed, nil
+}
+
+// load replaces the counts in q with the ones in f. It must be called with
+// the lock held.
+func (q *Quotas) load(f quotaFile) {
+	q.day = f.Day
+	q.used = f.Used
+	if q.used == nil {
+		q.used = make(map[string]int)
+	}
+	q.dirty = false
+	q.rollOver()
+}
+
+// Take counts cost requests for key, if that keeps it within limit for the
+// day
+func (q *Quotas) Take(key string, cost, limit int) Decision {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.rollOver()
+	used := q.used[key]
+	allowed := used+cost <= limit
+	if allowed {
+		used += cost
+		q.used[key] = used
+		q.dirty = true
+	}
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: limit - used,
+		Reset:     nextDay(now).Sub(now),
+	}
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	if !allowed {
+		d.RetryAfter = d.Reset
+	}
+	return d
+}
+
+// Save writes the counts to the file q was opened with, if they changed
+func (q *Quotas) Save() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.path == "" || !q.dirty {
+		return nil
+	}
+	content, err := json.MarshalIndent(quotaFile{Day: q.day, Used: q.used}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a failed write never leaves a
+	// partial file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+// rollOver drops the counts of a day that is over, and returns the time now.
+// It must be called with the lock held.
+func (q *Quotas) rollOver() time.Time {
+	now := q.now().UTC()
+	if day := now.Format(dayLayout); day != q.day {
+		q.day = day
+		q.used = make(map[string]int)
+		q.dirty = true
+	}
+	return now
+}
+
+// nextDay returns the start of the day after t, in UTC
+func nextDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotas(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)
+	q := NewQuotas()
+	q.now = func() time.Time { return now }
+
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 2, Reset: 6 * time.Hour}, q.Take("a", 3, 5))
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 4, Reset: 6 * time.Hour}, q.Take("b", 1, 5))
+
+	// Requests past the quota are refused, without being counted
+	assert.Equal(t, Decision{Limit: 5, Remaining: 2, Reset: 6 * time.Hour, RetryAfter: 6 * time.Hour}, q.Take("a", 3, 5))
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 0, Reset: 6 * time.Hour}, q.Take("a", 2, 5))
+
+	// The counts start again the next day
+	now = now.Add(7 * time.Hour)
+	assert.Equal(t, Decision{Allowed: true, Limit: 5, Remaining: 4, Reset: 23 * time.Hour}, q.Take("a", 1, 5))
+}
+
+func TestQuotas_Save(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "quotas.json")
+
+	var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuotas()
+	q.now = func() time.Time { return now }
+	assert.NoError(t, q.Open(path))
+	q.Take("a", 3, 5)
+	assert.NoError(t, q.Save())
+
+	// The counts are kept across restarts on the same day
+	reopened := NewQuotas()
+	reopened.now = func() time.Time { return now }
+	assert.NoError(t, reopened.Open(path))
+	assert.Equal(t, 2, reopened.Take("a", 0, 5).Remaining)
+
+	// but not into the next one
+	now = now.Add(24 * time.Hour)
+	reopened = NewQuotas()
+	reopened.now = func() time.Time { return now }
+	assert.NoError(t, reopened.Open(path))
+	assert.Equal(t, 5, reopened.Take("a", 0, 5).Remaining)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("used: 3"), 0600))
+	assert.EqualError(t, NewQuotas().Open(path), "could not read the quotas in "+path+": invalid character 'u' looking for beginning of value")
+}
+
+func TestQuotas_Section(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuotas()
+	q.now = func() time.Time { return now }
+	assert.NoError(t, q.LoadSection(nil))
+	q.Take("a", 3, 5)
+
+	data, changed, err := q.SaveSection()
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.JSONEq(t, `{"day": "2026-01-01", "used": {"a": 3}}`, string(data))
+	_, changed, _ = q.SaveSection()
+	assert.False(t, changed)
+
+	// The counts are kept across restarts on the same day
+	reloaded := NewQuotas()
+	reloaded.now = func() time.Time { return now }
+	assert.NoError(t, reloaded.LoadSection(data))
+	assert.Equal(t, 2, reloaded.Take("a", 0, 5).Remaining)
+
+	// but not into the next one
+	now = now.Add(24 * time.Hour)
+	reloaded = NewQuotas()
+	reloaded.now = func() time.Time { return now }
+	assert.NoError(t, reloaded.LoadSection(data))
+	assert.Equal(t, 5, reloaded.Take("a", 0, 5).Remaining)
+
+	assert.EqualError(t, NewQuotas().LoadSection(json.RawMessage("3")), "could not read the quotas: json: cannot unmarshal number into Go value of type ratelimit.quotaFile")
+}
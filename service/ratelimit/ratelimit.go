@@ -0,0 +1,171 @@
+// Package ratelimit limits how often clients can make requests, with a token
+// bucket for each of them, and how many they can make in a day, with quotas.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// What clients can be told apart by, to limit each of them
+const (
+	// KeyByPrincipal limits each API key or token subject, and anonymous
+	// requests by their IP address
+	KeyByPrincipal = "principal"
+	// KeyByTenant limits each tenant, and requests that aren't scoped to
+	// one as KeyByPrincipal
+	KeyByTenant = "tenant"
+	// KeyByIP limits each IP address
+	KeyByIP = "ip"
+)
+
+// Keys lists what clients can be told apart by
+var Keys = []string{KeyByPrincipal, KeyByTenant, KeyByIP}
+
+// ErrRateLimited is returned for requests made faster than the limit allows
+var ErrRateLimited = fmt.Errorf("too many requests, slow down")
+
+// ErrQuotaExceeded is returned for requests once the daily quota is used up
+var ErrQuotaExceeded = fmt.Errorf("daily quota exceeded")
+
+// Decision is whether a request can go ahead, and what is left of the limit
+// it was checked against
+type Decision struct {
+	Allowed bool
+	// Limit is the most requests that can be made at once
+	Limit int
+	// Remaining is how many requests can be made now
+	Remaining int
+	// Reset is how long until all of Limit is available again
+	Reset time.Duration
+	// RetryAfter is how long until a request that isn't allowed would be
+	RetryAfter time.Duration
+}
+
+// Limiter is a token bucket for each key. A bucket holds up to a limit of
+// tokens, each request takes some of them, and they are given back evenly
+// over a period.
+type Limiter struct {
+	limit  int
+	period time.Duration
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	nextPurge time.Time
+	now       func() time.Time
+}
+
+// bucket is the tokens left for a key when it was last updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// purgeInterval is how often full buckets are cleared out of a Limiter
+var purgeInterval = time.Minute
+
+// NewLimiter returns a Limiter that lets each key make limit requests every
+// period, all at once or spread out
+func NewLimiter(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		period:  period,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Limit returns the most requests that can be made at once
+func (l *Limiter) Limit() int {
+	return l.limit
+}
+
+// Period returns how long the tokens of a bucket take to come back
+func (l *Limiter) Period() time.Duration {
+	return l.period
+}
+
+// Take takes cost tokens from the bucket of key, if it has them. A cost above
+// the limit takes the whole bucket, so that such requests can still be made.
+func (l *Limiter) Take(key string, cost int) Decision {
+	return l.decide(key, cost, true)
+}
+
+// Peek returns what Take would decide, without taking the tokens
+func (l *Limiter) Peek(key string, cost int) Decision {
+	return l.decide(key, cost, false)
+}
+
+// decide is Take, only taking the tokens if take is true
+func (l *Limiter) decide(key string, cost int, take bool) Decision {
+	if cost > l.limit {
+		cost = l.limit
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.purge(now)
+
+	b := l.refill(key, now)
+	allowed := b.tokens >= float64(cost)
+	if allowed && take {
+		b.tokens -= float64(cost)
+	}
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     l.limit,
+		Remaining: int(math.Floor(b.tokens)),
+		Reset:     l.duration(float64(l.limit) - b.tokens),
+	}
+	if !allowed {
+		d.RetryAfter = l.duration(float64(cost) - b.tokens)
+	}
+	return d
+}
+
+// refill returns the bucket of key, with the tokens given back since it was
+// last updated. It must be called with the lock held.
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.limit), updated: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(l.limit), b.tokens+elapsed.Seconds()*l.rate())
+		b.updated = now
+	}
+	return b
+}
+
+// rate is how many tokens are given back a second
+func (l *Limiter) rate() float64 {
+	return float64(l.limit) / l.period.Seconds()
+}
+
+// duration returns how long it takes to give back tokens
+func (l *Limiter) duration(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / l.rate() * float64(time.Second)))
+}
+
+// purge removes the buckets that are full again, as they are the same as new
+// ones. It must be called with the lock held.
+func (l *Limiter) purge(now time.Time) {
+	if now.Before(l.nextPurge) {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate() >= float64(l.limit) {
+			delete(l.buckets, key)
+		}
+	}
+	l.nextPurge = now.Add(purgeInterval)
+}
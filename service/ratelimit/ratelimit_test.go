@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(10, 10*time.Second)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		key     string
+		cost    int
+		advance time.Duration
+		want    Decision
+	}{
+		{
+			name: "a new key should have the whole limit",
+			key:  "a",
+			cost: 4,
+			want: Decision{Allowed: true, Limit: 10, Remaining: 6, Reset: 4 * time.Second},
+		},
+		{
+			name: "keys should have buckets of their own",
+			key:  "b",
+			cost: 1,
+			want: Decision{Allowed: true, Limit: 10, Remaining: 9, Reset: time.Second},
+		},
+		{
+			name: "requests that cost more than is left should be refused",
+			key:  "a",
+			cost: 8,
+			want: Decision{Limit: 10, Remaining: 6, Reset: 4 * time.Second, RetryAfter: 2 * time.Second},
+		},
+		{
+			name:    "tokens should come back over the period",
+			key:     "a",
+			cost:    8,
+			advance: 2 * time.Second,
+			want:    Decision{Allowed: true, Limit: 10, Remaining: 0, Reset: 10 * time.Second},
+		},
+		{
+			name:    "requests that cost more than the limit should take the whole bucket",
+			key:     "b",
+			cost:    25,
+			advance: time.Minute,
+			want:    Decision{Allowed: true, Limit: 10, Remaining: 0, Reset: 10 * time.Second},
+		},
+		{
+			name:    "buckets should not fill up past the limit",
+			key:     "a",
+			cost:    0,
+			advance: time.Hour,
+			want:    Decision{Allowed: true, Limit: 10, Remaining: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			assert.Equal(t, tt.want, l.Take(tt.key, tt.cost))
+		})
+	}
+
+	// Full buckets are purged, as they are the same as new ones
+	assert.NotContains(t, l.buckets, "b")
+}
+
+func TestLimiter_Peek(t *testing.T) {
+
+	var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(2, 10*time.Second)
+	l.now = func() time.Time { return now }
+
+	// Peeking doesn't take any tokens
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 2}, l.Peek("a", 1))
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}, l.Take("a", 1))
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}, l.Peek("a", 1))
+
+	// but tells when Take would refuse
+	l.Take("a", 1)
+	assert.Equal(t, Decision{Limit: 2, Reset: 10 * time.Second, RetryAfter: 5 * time.Second}, l.Peek("a", 1))
+}
@@ -0,0 +1,116 @@
+// Package rbac decides what principals may do, from the permissions their
+// roles are granted by a policy.
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Permissions that routes can require
+const (
+	PermissionPetsRead  = "pets:read"
+	PermissionPetsWrite = "pets:write"
+	PermissionAuditRead = "audit:read"
+	PermissionAdmin     = "admin"
+)
+
+// Permissions lists every permission that can be granted
+var Permissions = []string{PermissionPetsRead, PermissionPetsWrite, PermissionAuditRead, PermissionAdmin}
+
+// RoleAdmin is the role of the admin API key set in the configuration, so
+// every policy must have it
+const RoleAdmin = "admin"
+
+// Policy maps roles to the permissions they grant
+type Policy map[string][]string
+
+// DefaultPolicy is the policy principals are checked against. It is replaced
+// by the policy file when one is configured.
+var DefaultPolicy = Policy{
+	RoleAdmin: {PermissionPetsRead, PermissionPetsWrite, PermissionAuditRead, PermissionAdmin},
+	"editor":  {PermissionPetsRead, PermissionPetsWrite},
+	"reader":  {PermissionPetsRead},
+}
+
+// ErrUnknownRole is returned for roles that aren't in the policy
+var ErrUnknownRole = fmt.Errorf("role is not in the policy")
+
+// LoadFile reads a policy from the JSON file at path, e.g.
+// {"reader": ["pets:read"]}
+func LoadFile(path string) (Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate returns an error if a role of p has no name, or grants a
+// permission that doesn't exist, or if p doesn't have RoleAdmin
+func (p Policy) Validate() error {
+	if _, ok := p[RoleAdmin]; !ok {
+		return fmt.Errorf("the %s role is required", RoleAdmin)
+	}
+	for _, role := range p.Roles() {
+		if strings.TrimSpace(role) == "" {
+			return fmt.Errorf("roles must have a name")
+		}
+		for _, perm := range p[role] {
+			if !contains(Permissions, perm) {
+				return fmt.Errorf("role %s: unknown permission %q, must be one of: %s", role, perm, strings.Join(Permissions, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// Roles returns the roles of p, sorted
+func (p Policy) Roles() []string {
+	roles := make([]string, 0, len(p))
+	for role := range p {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Allows returns true if any of roles grants permission
+func (p Policy) Allows(roles []string, permission string) bool {
+	for _, role := range roles {
+		if contains(p[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRoles returns an error wrapping ErrUnknownRole for the first of roles
+// that isn't in p
+func (p Policy) CheckRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := p[role]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownRole, role)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
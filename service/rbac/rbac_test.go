@@ -0,0 +1,117 @@
+package rbac
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{
+			name:       "readers should read pets",
+			roles:      []string{"reader"},
+			permission: PermissionPetsRead,
+			want:       true,
+		},
+		{
+			name:       "readers should not write pets",
+			roles:      []string{"reader"},
+			permission: PermissionPetsWrite,
+		},
+		{
+			name:       "any of the roles should grant the permission",
+			roles:      []string{"reader", "editor"},
+			permission: PermissionPetsWrite,
+			want:       true,
+		},
+		{
+			name:       "editors should not administer the API",
+			roles:      []string{"editor"},
+			permission: PermissionAdmin,
+		},
+		{
+			name:       "roles that aren't in the policy should grant nothing",
+			roles:      []string{"owner"},
+			permission: PermissionPetsRead,
+		},
+		{
+			name:       "principals without roles should be allowed nothing",
+			permission: PermissionPetsRead,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DefaultPolicy.Allows(tt.roles, tt.permission))
+		})
+	}
+}
+
+func TestPolicy_CheckRoles(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.CheckRoles(nil))
+	assert.NoError(t, DefaultPolicy.CheckRoles([]string{"admin", "reader"}))
+	err := DefaultPolicy.CheckRoles([]string{"reader", "owner"})
+	assert.EqualError(t, err, `role is not in the policy: "owner"`)
+	assert.True(t, errors.Is(err, ErrUnknownRole))
+}
+
+func TestLoadFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "policy.json")
+
+	tests := []struct {
+		name        string
+		content     string
+		want        Policy
+		expectedErr string
+	}{
+		{
+			name:    "policies should be loaded",
+			content: `{"admin": ["admin"], "partner": ["pets:read"]}`,
+			want:    Policy{"admin": {"admin"}, "partner": {"pets:read"}},
+		},
+		{
+			name:        "unknown permissions should be an error",
+			content:     `{"admin": ["admin"], "partner": ["pets:delete"]}`,
+			expectedErr: `invalid policy ` + path + `: role partner: unknown permission "pets:delete", must be one of: pets:read, pets:write, audit:read, admin`,
+		},
+		{
+			name:        "the admin role should be required",
+			content:     `{"partner": ["pets:read"]}`,
+			expectedErr: `invalid policy ` + path + `: the admin role is required`,
+		},
+		{
+			name:        "files that aren't JSON should be an error",
+			content:     `partner = pets:read`,
+			expectedErr: `invalid policy ` + path + `: invalid character 'p' looking for beginning of value`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadFile(path)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
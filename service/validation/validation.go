@@ -0,0 +1,122 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError describes why a field failed validation
+type FieldError struct {
+	// Field is the path to the field, e.g. operations[1].pet.name
+	Field string `json:"field"`
+	// In is where the field is in the request (query, path, header or body),
+	// if known
+	In      string `json:"in,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Err is the typed error behind the failure, if there is one
+	Err error `json:"-"`
+	// Params are the values in the message, e.g. the minimum, so that it can
+	// be translated
+	Params map[string]interface{} `json:"-"`
+}
+
+// Codes for the ways a field can fail validation
+const (
+	CodeRequired    = "required"
+	CodeInvalid     = "invalid"
+	CodeInvalidType = "invalid_type"
+	CodeInvalidJSON = "invalid_json"
+	CodeMultiple    = "multiple_values"
+	CodeMinimum     = "minimum"
+	CodeMaximum     = "maximum"
+	CodeMinLength   = "min_length"
+	CodeMaxLength   = "max_length"
+	CodePattern     = "pattern"
+	CodeEnum        = "enum"
+	CodeMinItems    = "min_items"
+	CodeMaxItems    = "max_items"
+)
+
+// Errors is a list of field errors. It is an error itself, so models can
+// return every violation at once from their Validate methods.
+type Errors []FieldError
+
+// Error joins the messages of all the field errors
+func (e Errors) Error() string {
+	var msgs = make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the typed errors behind the field errors, so errors.Is can
+// find them
+func (e Errors) Unwrap() []error {
+	var errs []error
+	for _, fe := range e {
+		if fe.Err != nil {
+			errs = append(errs, fe.Err)
+		}
+	}
+	return errs
+}
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+// Check adds a field error for err, a typed error whose message is used, if
+// ok is false
+func (v *Validator) Check(ok bool, field, code string, err error) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: err.Error(), Err: err})
+	}
+}
+
+// Add adds a field error
+func (v *Validator) Add(fe FieldError) {
+	v.errs = append(v.errs, fe)
+}
+
+// Nested adds the errors from validating a nested value, e.g. the result of
+// its Validate method, under field. Errors other than Errors are added as an
+// invalid field.
+func (v *Validator) Nested(field string, err error) {
+	if err == nil {
+		return
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		v.errs = append(v.errs, FieldError{Field: field, Code: CodeInvalid, Message: err.Error(), Err: err})
+		return
+	}
+	for _, fe := range errs {
+		fe.Field = Join(field, fe.Field)
+		v.errs = append(v.errs, fe)
+	}
+}
+
+// Err returns the collected errors as Errors, or nil if there are none
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Join joins a field path and a field name
+func Join(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	if field == "" {
+		return parent
+	}
+	if strings.HasPrefix(field, "[") {
+		return parent + field
+	}
+	return parent + "." + field
+}
@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTooShort = fmt.Errorf("invalid name: too short")
+var errNegative = fmt.Errorf("invalid id: negative")
+
+func TestValidator(t *testing.T) {
+
+	var v Validator
+	assert.Nil(t, v.Err())
+
+	v.Check(true, "id", CodeMinimum, errNegative)
+	assert.Nil(t, v.Err())
+
+	v.Check(false, "id", CodeMinimum, errNegative)
+	v.Check(false, "name", CodeMinLength, errTooShort)
+
+	err := v.Err()
+	assert.EqualError(t, err, "invalid id: negative; invalid name: too short")
+	assert.True(t, errors.Is(err, errNegative))
+	assert.True(t, errors.Is(err, errTooShort))
+
+	var errs Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, Errors{
+		{Field: "id", Code: CodeMinimum, Message: "invalid id: negative", Err: errNegative},
+		{Field: "name", Code: CodeMinLength, Message: "invalid name: too short", Err: errTooShort},
+	}, errs)
+}
+
+func TestValidator_Nested(t *testing.T) {
+
+	var inner Validator
+	inner.Check(false, "name", CodeMinLength, errTooShort)
+
+	var v Validator
+	v.Nested("pets[0]", nil)
+	v.Nested("pets[1]", inner.Err())
+	v.Nested("owner", errNegative)
+
+	var errs Errors
+	assert.True(t, errors.As(v.Err(), &errs))
+	assert.Equal(t, Errors{
+		{Field: "pets[1].name", Code: CodeMinLength, Message: "invalid name: too short", Err: errTooShort},
+		{Field: "owner", Code: CodeInvalid, Message: "invalid id: negative", Err: errNegative},
+	}, errs)
+}
+
+func TestJoin(t *testing.T) {
+
+	tests := []struct {
+		parent   string
+		field    string
+		expected string
+	}{
+		{"", "name", "name"},
+		{"pet", "", "pet"},
+		{"pet", "name", "pet.name"},
+		{"pets", "[1]", "pets[1]"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, Join(tt.parent, tt.field))
+	}
+}